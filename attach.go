@@ -0,0 +1,570 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"qsrdocker/container"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hpcloud/tail"
+	log "github.com/sirupsen/logrus"
+)
+
+// attach 客户端发送给 monitor 的数据帧类型
+// 帧格式: [1 byte 类型][4 byte 大端长度][数据]
+// monitor 发送给客户端的是 pty 的原始输出，不分帧
+const (
+	attachFrameStdin  byte = 0 // 标准输入
+	attachFrameResize byte = 1 // 窗口大小 rows cols 各 2 byte
+
+	// attachMaxFrameSize 客户端数据帧的最大长度, 超过时断开连接
+	attachMaxFrameSize = 64 * 1024
+	// attachClientBuffer 每个客户端缓存的 pty 输出块数, 写满时断开该客户端
+	attachClientBuffer = 64
+	// attachWriteTimeout 向客户端写入的超时时间
+	attachWriteTimeout = 10 * time.Second
+	// attachPendingSize 前台 run -it 在 attach 之前缓存的最大输出
+	attachPendingSize = 1024 * 1024
+)
+
+// consoleServer monitor 持有 tty 容器的 pty master
+// pty 的输出写入 container.log 并转发给所有 attach 的客户端
+type consoleServer struct {
+	containerID string
+	listener    net.Listener
+	logFile     *os.File
+
+	lock    sync.Mutex
+	master  *os.File                 // 当前容器进程的 pty master, 容器退出后为 nil
+	clients map[net.Conn]chan []byte // attach 的客户端与待发送的 pty 输出
+	pumpWg  sync.WaitGroup           // pty 输出转发
+	holding bool                     // 前台 run -it, 第一个客户端 attach 之前缓存 pty 输出
+	pending []byte                   // 缓存的 pty 输出, 发送给第一个客户端
+}
+
+// startConsoleServer 在容器目录下监听 attach.sock
+// holdOutput 时缓存第一个客户端 attach 之前的输出, 前台 run -it 先启动容器再 attach
+func startConsoleServer(containerID string, holdOutput bool) (*consoleServer, error) {
+
+	socketPath := path.Join(container.ContainerDir, containerID, container.AttachSocketFile)
+
+	// 上一个 monitor 异常退出时残留的 socket
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("Listen %v error %v", socketPath, err)
+	}
+
+	// 以追加方式打开 log 文件, 重启后保留之前的日志
+	containerLogFile := path.Join(container.ContainerDir, containerID, container.ContainerLogFile)
+	logFile, err := os.OpenFile(containerLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Get log file %s error %v", containerLogFile, err)
+	}
+
+	server := &consoleServer{
+		containerID: containerID,
+		listener:    listener,
+		logFile:     logFile,
+		clients:     map[net.Conn]chan []byte{},
+		holding:     holdOutput,
+	}
+
+	go server.serve()
+
+	return server, nil
+}
+
+// newConsole 为新启动的容器进程创建 pty, 返回 slave 交给容器进程
+func (server *consoleServer) newConsole() (*os.File, error) {
+
+	master, slave, err := container.NewConsole()
+	if err != nil {
+		return nil, err
+	}
+
+	server.lock.Lock()
+	server.master = master
+	server.lock.Unlock()
+
+	server.pumpWg.Add(1)
+	go server.pump(master)
+
+	return slave, nil
+}
+
+// closeConsole 容器进程退出后调用, 等待剩余输出写完并断开所有客户端
+func (server *consoleServer) closeConsole() {
+
+	// 容器进程退出后 slave 全部关闭, 读取 master 返回 EIO
+	server.pumpWg.Wait()
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	if server.master != nil {
+		server.master.Close()
+		server.master = nil
+	}
+
+	// 关闭 channel, 由 writeClient 发送完剩余输出后断开
+	for conn, out := range server.clients {
+		close(out)
+		delete(server.clients, conn)
+	}
+}
+
+// close monitor 退出时关闭 socket
+func (server *consoleServer) close() {
+	server.closeConsole()
+	server.listener.Close()
+	server.logFile.Close()
+	os.Remove(path.Join(container.ContainerDir, server.containerID, container.AttachSocketFile))
+}
+
+// pump 将 pty 输出写入日志并转发给客户端
+// 不在持有锁时写入客户端, 跟不上输出的客户端被断开, 避免阻塞 pty 与日志
+func (server *consoleServer) pump(master *os.File) {
+	defer server.pumpWg.Done()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := master.Read(buf)
+		if n > 0 {
+			if _, err := server.logFile.Write(buf[:n]); err != nil {
+				log.Warnf("Write container %v log error %v", server.containerID, err)
+			}
+
+			data := make([]byte, n)
+			copy(data, buf[:n])
+
+			server.lock.Lock()
+			if server.holding && len(server.pending)+n <= attachPendingSize {
+				server.pending = append(server.pending, data...)
+			}
+			for conn, out := range server.clients {
+				select {
+				case out <- data:
+				default:
+					log.Warnf("Attach client of container %v is too slow, disconnect", server.containerID)
+					close(out)
+					delete(server.clients, conn)
+					conn.Close()
+				}
+			}
+			server.lock.Unlock()
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// serve 接受 attach 连接
+func (server *consoleServer) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		server.lock.Lock()
+		// 第一个客户端先发送 attach 之前缓存的输出
+		pending := server.pending
+		server.holding = false
+		server.pending = nil
+
+		if server.master == nil {
+			// 容器进程未运行, 等待重启中, 或者在 attach 之前已经退出
+			server.lock.Unlock()
+			if len(pending) > 0 {
+				conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+				conn.Write(pending)
+			}
+			conn.Close()
+			continue
+		}
+		out := make(chan []byte, attachClientBuffer)
+		if len(pending) > 0 {
+			out <- pending
+		}
+		server.clients[conn] = out
+		server.lock.Unlock()
+
+		go server.writeClient(conn, out)
+		go server.handleClient(conn)
+	}
+}
+
+// writeClient 将 pty 输出发送给客户端, channel 关闭后断开连接
+func (server *consoleServer) writeClient(conn net.Conn, out chan []byte) {
+	defer conn.Close()
+
+	var err error
+	for data := range out {
+		// 写入失败后丢弃剩余输出
+		if err != nil {
+			continue
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+		if _, err = conn.Write(data); err != nil {
+			server.dropClient(conn)
+		}
+	}
+}
+
+// dropClient 断开客户端
+func (server *consoleServer) dropClient(conn net.Conn) {
+	server.lock.Lock()
+	if out, ok := server.clients[conn]; ok {
+		close(out)
+		delete(server.clients, conn)
+	}
+	server.lock.Unlock()
+	conn.Close()
+}
+
+// handleClient 读取客户端的输入与窗口大小
+func (server *consoleServer) handleClient(conn net.Conn) {
+
+	defer server.dropClient(conn)
+
+	header := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+
+		// 长度由客户端决定, 需要限制
+		frameSize := binary.BigEndian.Uint32(header[1:])
+		if frameSize > attachMaxFrameSize {
+			log.Warnf("Attach frame of container %v is too large : %v", server.containerID, frameSize)
+			return
+		}
+
+		data := make([]byte, frameSize)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+
+		server.lock.Lock()
+		master := server.master
+		server.lock.Unlock()
+
+		if master == nil {
+			return
+		}
+
+		switch header[0] {
+		case attachFrameStdin:
+			if _, err := master.Write(data); err != nil {
+				return
+			}
+		case attachFrameResize:
+			if len(data) == 4 {
+				container.SetWinsize(master, &container.Winsize{
+					Rows: binary.BigEndian.Uint16(data[0:2]),
+					Cols: binary.BigEndian.Uint16(data[2:4]),
+				})
+			}
+		}
+	}
+}
+
+// writeAttachFrame 客户端发送一帧数据
+func writeAttachFrame(conn net.Conn, frameType byte, data []byte) error {
+	frame := make([]byte, 5+len(data))
+	frame[0] = frameType
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+
+	_, err := conn.Write(frame)
+	return err
+}
+
+// attachContainer 连接容器的标准输入输出
+// tty 容器连接 monitor 持有的 pty, 按下 detachKeys 断开连接, 容器继续运行
+// 非 tty 容器没有标准输入, 由 qsrdockerd 持续输出 container.log 中的新日志
+func attachContainer(containerName, detachKeys string) error {
+
+	// 获取containerInfo信息
+	containerInfo := &container.ContainerInfo{}
+	if err := daemonRequest(http.MethodGet, containerAPIPath(containerName, ""), nil, nil, containerInfo); err != nil {
+		return err
+	}
+
+	if containerInfo.Status.Paused {
+		return fmt.Errorf("You cannot attach to a paused container, unpause it first")
+	}
+
+	if !containerInfo.Status.Running {
+		return fmt.Errorf("You cannot attach to a stopped container, start it first")
+	}
+
+	keys, err := container.ParseDetachKeys(detachKeys)
+	if err != nil {
+		return err
+	}
+
+	if !containerInfo.TTy {
+		output, err := daemonStream(http.MethodGet, containerAPIPath(containerInfo.ID, "attach"), nil, nil)
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+
+		io.Copy(os.Stdout, output)
+		return nil
+	}
+
+	_, err = attachConsole(containerInfo.ID, keys)
+	return err
+}
+
+// runAttachedContainer 前台运行 -it 容器
+// 由 qsrdockerd 创建并启动, monitor 持有 pty 并缓存 attach 之前的输出
+// 当前终端 attach 到容器, 容器退出后删除; 按下 detach 按键时容器继续运行
+func runAttachedContainer(runConfig *container.RunConfig) error {
+
+	createResp := &apiRunResponse{}
+	if err := daemonRequest(http.MethodPost, "/containers/create", nil, runConfig, createResp); err != nil {
+		return err
+	}
+	containerID := createResp.ID
+
+	// 与 -it 容器退出后相同, 删除容器并释放匿名数据卷
+	removeQuery := url.Values{}
+	removeQuery.Set("force", "1")
+	removeQuery.Set("volumes", "1")
+
+	startQuery := url.Values{}
+	startQuery.Set("attach", "1")
+	if err := daemonRequest(http.MethodPost, containerAPIPath(containerID, "start"), startQuery, nil, nil); err != nil {
+		if e := daemonRequest(http.MethodDelete, containerAPIPath(containerID, ""), removeQuery, nil, nil); e != nil {
+			log.Errorf("Remove container %v error : %v", containerID, e)
+		}
+		return err
+	}
+
+	keys, err := container.ParseDetachKeys(container.DefaultDetachKeys)
+	if err != nil {
+		return err
+	}
+
+	detached, err := attachConsole(containerID, keys)
+	if err != nil {
+		// 容器在 attach 之前已经退出, monitor 不再监听 attach.sock, 输出从日志获取
+		log.Debugf("Attach container %v error : %v", containerID, err)
+
+		output, e := daemonStream(http.MethodGet, containerAPIPath(containerID, "logs"), nil, nil)
+		if e != nil {
+			return err
+		}
+		io.Copy(os.Stdout, output)
+		output.Close()
+	}
+
+	if detached {
+		return nil
+	}
+
+	waitResp := &apiWaitResponse{}
+	if err := daemonRequest(http.MethodPost, containerAPIPath(containerID, "wait"), nil, nil, waitResp); err != nil {
+		return err
+	}
+
+	return daemonRequest(http.MethodDelete, containerAPIPath(containerID, ""), removeQuery, nil, nil)
+}
+
+// attachConsole 连接 monitor 持有的 pty, 容器退出或按下 detach 按键后返回
+// 按下 detach 按键时返回 true
+func attachConsole(containerID string, keys []byte) (bool, error) {
+
+	socketPath := path.Join(container.ContainerDir, containerID, container.AttachSocketFile)
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return false, fmt.Errorf("Attach container %v error %v", containerID, err)
+	}
+	defer conn.Close()
+
+	// 终端设置为 raw 模式, 并同步窗口大小
+	if container.IsTerminal(os.Stdin) {
+		restore, err := container.SetRawTerminal(os.Stdin)
+		if err != nil {
+			return false, err
+		}
+		defer restore()
+
+		resizeCh := make(chan os.Signal, 1)
+		signal.Notify(resizeCh, syscall.SIGWINCH)
+		defer signal.Stop(resizeCh)
+
+		sendResize := func() {
+			if ws, err := container.GetWinsize(os.Stdin); err == nil {
+				size := make([]byte, 4)
+				binary.BigEndian.PutUint16(size[0:2], ws.Rows)
+				binary.BigEndian.PutUint16(size[2:4], ws.Cols)
+				writeAttachFrame(conn, attachFrameResize, size)
+			}
+		}
+		sendResize()
+
+		go func() {
+			for range resizeCh {
+				sendResize()
+			}
+		}()
+	}
+
+	// 容器输出
+	outputDone := make(chan struct{})
+	go func() {
+		io.Copy(os.Stdout, conn)
+		close(outputDone)
+	}()
+
+	// 标准输入, 检测 detach 按键
+	detached := make(chan struct{})
+	go func() {
+		if readStdinWithDetachKeys(conn, keys) {
+			close(detached)
+		}
+	}()
+
+	select {
+	case <-outputDone:
+		// 容器退出
+		return false, nil
+	case <-detached:
+		fmt.Fprint(os.Stdout, "\r\nread escape sequence\r\n")
+		return true, nil
+	}
+}
+
+// readStdinWithDetachKeys 将标准输入发送给容器, 读到 detach 按键时返回 true
+// 与 detach 按键部分匹配的输入暂不发送, 之后不匹配时补发
+func readStdinWithDetachKeys(conn net.Conn, keys []byte) bool {
+
+	buf := make([]byte, 1024)
+	matched := 0
+
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return false
+		}
+
+		var data []byte
+		for _, b := range buf[:n] {
+			if b == keys[matched] {
+				matched++
+				if matched == len(keys) {
+					writeAttachFrame(conn, attachFrameStdin, data)
+					return true
+				}
+				continue
+			}
+
+			if matched > 0 {
+				data = append(data, keys[:matched]...)
+				matched = 0
+				if b == keys[0] {
+					matched = 1
+					continue
+				}
+			}
+
+			data = append(data, b)
+		}
+
+		if len(data) > 0 {
+			if err := writeAttachFrame(conn, attachFrameStdin, data); err != nil {
+				return false
+			}
+		}
+	}
+}
+
+// followContainerLog 从当前位置持续输出容器日志到 w, 直到容器停止或 done 关闭
+// 由 qsrdockerd 提供给 attach 非 tty 容器的客户端
+func followContainerLog(w io.Writer, containerID string, done <-chan struct{}) error {
+
+	containerLogFile := path.Join(container.ContainerDir, containerID, container.ContainerLogFile)
+
+	t, err := tail.TailFile(containerLogFile, tail.Config{
+		ReOpen:    true,
+		Poll:      true,
+		Follow:    true,
+		MustExist: false,
+		Location:  &tail.SeekInfo{Offset: 0, Whence: os.SEEK_END},
+		Logger:    tail.DiscardingLogger,
+	})
+	if err != nil {
+		return fmt.Errorf("Follow log file %v error %v", containerLogFile, err)
+	}
+	defer t.Cleanup()
+	defer t.Stop()
+
+	// 容器停止后结束, 按重启策略重启时继续输出
+	stopWait := make(chan struct{})
+	defer close(stopWait)
+
+	exited := make(chan struct{})
+	go func() {
+		waitContainer(containerID, stopWait)
+		close(exited)
+	}()
+
+	for {
+		select {
+		case line, ok := <-t.Lines:
+			if !ok {
+				return t.Err()
+			}
+			fmt.Fprintln(w, line.Text)
+		case <-exited:
+			return nil
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// launchMonitoredContainer monitor 启动容器进程
+// tty 容器的标准输入输出为 consoleServer 持有的 pty
+func launchMonitoredContainer(containerInfo *container.ContainerInfo, server *consoleServer) (*exec.Cmd, error) {
+
+	if server == nil {
+		return launchContainer(containerInfo, nil)
+	}
+
+	slave, err := server.newConsole()
+	if err != nil {
+		return nil, fmt.Errorf("Create container console error : %v", err)
+	}
+
+	containerProcess, err := launchContainer(containerInfo, slave)
+
+	// 容器进程已持有 slave, monitor 关闭自己的副本, 容器退出后 master 读取返回 EIO
+	slave.Close()
+
+	if containerProcess == nil {
+		server.closeConsole()
+	}
+
+	return containerProcess, err
+}
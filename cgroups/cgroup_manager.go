@@ -1,7 +1,9 @@
 package cgroups
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 	"qsrdocker/cgroups/subsystems"
 
 	log "github.com/sirupsen/logrus"
@@ -29,6 +31,24 @@ func (c *CgroupManager) Init() {
 			 		log.Warnf("Init cgroup %v-%s fail: %v", t.Field(i).Tag.Get("subsystem"), t.Field(i).Tag.Get("file"), err) // 不能直接 return err 等保证其他 subsystem set
 		}
 	}
+
+	// freezer cpuacct pids blkio 不需要设置资源限制，用于 pause / unpause 以及 stats
+	for _, subsystem := range subsystems.ControlSubsystems {
+		// 未挂载的 cgroup v1 subsystem, 由 cgroup v2 提供
+		if subsystems.FindCgroupMountpoint(subsystem) == "" {
+			continue
+		}
+		if err := subsystems.Init(subsystem, ""); err != nil {
+			log.Warnf("Init cgroup %v fail: %v", subsystem, err)
+		}
+	}
+
+	// 存在 cgroup v2 时容器进程需要独立的 cgroup v2
+	if subsystems.FindCgroupV2Mountpoint() != "" {
+		if err := subsystems.InitCgroupV2(); err != nil {
+			log.Warnf("Init cgroup v2 fail: %v", err)
+		}
+	}
 }
 
 
@@ -40,18 +60,42 @@ func (c *CgroupManager) Apply(pid int) {
 			log.Warnf("Apply cgroup %v-%v fail: %v", t.Field(i).Tag.Get("subsystem"), t.Field(i).Tag.Get("file"), err) // 不能直接 return err 等保证其他 subsystem set
 		}
 	}
+
+	for _, subsystem := range subsystems.ControlSubsystems {
+		if subsystems.FindCgroupMountpoint(subsystem) == "" {
+			continue
+		}
+		if err := subsystems.Apply(c.Path, subsystem, "", pid); err != nil {
+			log.Warnf("Apply cgroup %v fail: %v", subsystem, err)
+		}
+	}
+
+	// 移动到 [cgroup2]/qsrdocker/[containerID], 不留在 qsrdockerd / monitor 的 cgroup 中
+	if subsystems.FindCgroupV2Mountpoint() != "" {
+		if err := subsystems.ApplyCgroupV2(c.Path, pid); err != nil {
+			log.Warnf("Apply cgroup v2 fail: %v", err)
+		}
+	}
 }
 
 // Set 设置各个 subsystem的限制值
-func (c *CgroupManager) Set() {
+// 某个 subsystem 设置失败时继续设置其他 subsystem, 返回所有失败的原因
+func (c *CgroupManager) Set() error {
 	t := reflect.TypeOf(c.Resource).Elem()
 	v := reflect.ValueOf(c.Resource).Elem()
+	var errs []string
 	for i := 0; i < t.NumField(); i++ {
 		val := v.Field(i).Interface().(string)
 		if err := subsystems.Set(c.Path, t.Field(i).Tag.Get("subsystem"), t.Field(i).Tag.Get("file"), val); err != nil {
-			log.Warnf("Set cgroup %v-%v fail: %v", t.Field(i).Tag.Get("subsystem"), t.Field(i).Tag.Get("file"), err) // 不能直接 return err 等保证其他 subsystem set
+			// 不能直接 return err 等保证其他 subsystem set
+			errs = append(errs, fmt.Sprintf("set %v=%v fail: %v", t.Field(i).Tag.Get("file"), val, err))
 		}
 	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
 }
 
 // Destroy 释放挂载的Cgroup 对应 Remove
@@ -62,4 +106,47 @@ func (c *CgroupManager) Destroy() {
 			log.Warnf("Remove cgroup %v-%v fail: %v", t.Field(i).Tag.Get("subsystem"), t.Field(i).Tag.Get("file"), err) // 不能直接 return err 等保证其他 subsystem set
 		}
 	}
+	for _, subsystem := range subsystems.ControlSubsystems {
+		if subsystems.FindCgroupMountpoint(subsystem) == "" {
+			continue
+		}
+		if err := subsystems.Remove(c.Path, subsystem, ""); err != nil {
+			log.Warnf("Remove cgroup %v fail: %v", subsystem, err)
+		}
+	}
+
+	if err := subsystems.RemoveCgroupV2(c.Path); err != nil {
+		log.Warnf("Remove cgroup v2 fail: %v", err)
+	}
+}
+
+// Freeze 冻结 cgroup 中的所有进程 qsrdocker pause
+func (c *CgroupManager) Freeze(pid int) error {
+	return subsystems.SetFreezerState(c.Path, pid, subsystems.FreezerFrozen)
+}
+
+// Thaw 解冻 cgroup 中的所有进程 qsrdocker unpause
+func (c *CgroupManager) Thaw(pid int) error {
+	return subsystems.SetFreezerState(c.Path, pid, subsystems.FreezerThawed)
+}
+
+// NotifyOOM 注册 cgroup 的 OOM 通知, 由 monitor 进程监听
+func (c *CgroupManager) NotifyOOM(pid int) (<-chan struct{}, func(), error) {
+	return subsystems.NotifyOOM(c.Path, pid)
+}
+
+// OOMState 获取 cgroup 当前的 OOM 状态
+func (c *CgroupManager) OOMState(pid int) (*subsystems.OOMState, error) {
+	return subsystems.GetOOMState(c.Path, pid)
+}
+
+// GetPids 获取 cgroup 中所有进程的 PID, qsrdocker top
+// freezer 不依赖资源限制配置，所有容器进程都会被 Apply 到 freezer 中
+func (c *CgroupManager) GetPids(pid int) ([]int, error) {
+	return subsystems.GetPids(c.Path, subsystems.FreezerSubsystem, pid)
+}
+
+// Stats 读取 cgroup 的资源使用统计, qsrdocker stats
+func (c *CgroupManager) Stats(pid int) (*subsystems.Stats, error) {
+	return subsystems.GetStats(c.Path, pid)
 }
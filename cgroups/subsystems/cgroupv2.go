@@ -0,0 +1,185 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cgroup v2 相关文件
+const (
+	cgroupV2ProcsFile          = "cgroup.procs"           // cgroup v2 进程列表
+	cgroupV2ControllersFile    = "cgroup.controllers"     // cgroup v2 可用的 controller
+	cgroupV2SubtreeControlFile = "cgroup.subtree_control" // cgroup v2 子 cgroup 开启的 controller
+)
+
+// cgroupV2Files ResourceConfig 中 cgroup v1 文件对应的 cgroup v2 文件
+var cgroupV2Files = map[string]string{
+	"memory.limit_in_bytes": "memory.max",
+	"cpu.shares":            "cpu.weight",
+	"cpuset.cpus":           "cpuset.cpus",
+	"cpuset.mems":           "cpuset.mems",
+	"pids.max":              "pids.max",
+}
+
+// InitCgroupV2 在 cgroup v2 中创建 qsrdocker, 并为容器的 cgroup 开启所有可用的 controller
+// 容器进程需要移动到独立的 [cgroup2]/qsrdocker/[containerID] 中
+// 留在 qsrdockerd / monitor 所在的 cgroup 时, pause stats top 会作用于 daemon 与其他容器
+func InitCgroupV2() error {
+
+	cgroupRoot, err := GetCgroupV2Path("", true)
+	if err != nil {
+		return err
+	}
+
+	controllers, err := ioutil.ReadFile(path.Join(cgroupRoot, cgroupV2ControllersFile))
+	if err != nil {
+		return fmt.Errorf("Read cgroup %s fail %v", cgroupV2ControllersFile, err)
+	}
+
+	// hybrid 模式下 controller 都在 cgroup v1 中, cgroup.controllers 为空
+	for _, controller := range strings.Fields(string(controllers)) {
+		if err := ioutil.WriteFile(path.Join(cgroupRoot, cgroupV2SubtreeControlFile), []byte("+"+controller), 0644); err != nil {
+			log.Debugf("Enable cgroup v2 controller %v in %v fail %v", controller, cgroupRoot, err)
+		}
+	}
+
+	return nil
+}
+
+// GetCgroupV2Path 获取 cgroup v2 中容器独立的 cgroup 绝对路径 [cgroup2]/qsrdocker/[cgroupPath]
+func GetCgroupV2Path(cgroupPath string, autoCreate bool) (string, error) {
+
+	cgroupRoot := FindCgroupV2Mountpoint()
+	if cgroupRoot == "" {
+		return "", fmt.Errorf("Can't find cgroup2 mountpoint")
+	}
+
+	absCgroupPath := path.Join(cgroupRoot, "qsrdocker", cgroupPath)
+	if _, err := os.Stat(absCgroupPath); err != nil {
+		if !autoCreate || !os.IsNotExist(err) {
+			return "", fmt.Errorf("Cgroup path error %v", err)
+		}
+		if err := os.MkdirAll(absCgroupPath, 0755); err != nil {
+			return "", fmt.Errorf("Error create cgroup %v", err)
+		}
+	}
+
+	return absCgroupPath, nil
+}
+
+// setCgroupV2 将 cgroup v1 的资源限制转换后写入容器独立的 cgroup v2
+// 空值不设置, 新建的 cgroup v2 默认不限制
+func setCgroupV2(cgroupPath, subsystem, subsystemFile, cgroupConf string) error {
+
+	cgroupConf = strings.TrimSpace(cgroupConf)
+	if cgroupConf == "" {
+		return nil
+	}
+
+	switch subsystemFile {
+	case OOMControlFile:
+		// cgroup v2 无法禁止 OOM killer
+		if cgroupConf == "0" {
+			return nil
+		}
+		return fmt.Errorf("oom_kill_disable is not supported by cgroup v2")
+	case "memory.limit_in_bytes":
+		if cgroupConf == "-1" {
+			cgroupConf = "max"
+		}
+	case "cpu.shares":
+		// cpu.shares [2, 262144] 转换为 cpu.weight [1, 10000]
+		shares, err := strconv.ParseUint(cgroupConf, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid cpushare %v", cgroupConf)
+		}
+		if shares < 2 {
+			shares = 2
+		} else if shares > 262144 {
+			shares = 262144
+		}
+		cgroupConf = strconv.FormatUint(1+((shares-2)*9999)/262142, 10)
+	}
+
+	v2File, ok := cgroupV2Files[subsystemFile]
+	if !ok {
+		return fmt.Errorf("%s-%s is not supported by cgroup v2", subsystem, subsystemFile)
+	}
+
+	cgroupV2Path, err := GetCgroupV2Path(cgroupPath, true)
+	if err != nil {
+		return err
+	}
+
+	// controller 未在 qsrdocker 的 cgroup.subtree_control 中开启时文件不存在
+	if _, err := os.Stat(path.Join(cgroupV2Path, v2File)); err != nil {
+		return fmt.Errorf("cgroup v2 controller %v is not enabled in %v", subsystem, cgroupV2Path)
+	}
+
+	if err := ioutil.WriteFile(path.Join(cgroupV2Path, v2File), []byte(cgroupConf), 0644); err != nil {
+		return fmt.Errorf("cgroup v2 %s fail %v", v2File, err)
+	}
+
+	log.Debugf("Set cgroup v2 %v in %v: %v", v2File, cgroupV2Path, cgroupConf)
+	return nil
+}
+
+// ApplyCgroupV2 将进程移动到容器独立的 cgroup v2 中
+func ApplyCgroupV2(cgroupPath string, pid int) error {
+
+	cgroupV2Path, err := GetCgroupV2Path(cgroupPath, true)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path.Join(cgroupV2Path, cgroupV2ProcsFile), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("set cgroup v2 proc fail %v", err)
+	}
+
+	log.Debugf("Apply cgroup v2 %v successful. curr pid: %d", cgroupV2Path, pid)
+	return nil
+}
+
+// RemoveCgroupV2 删除容器独立的 cgroup v2, 不存在时直接返回
+func RemoveCgroupV2(cgroupPath string) error {
+
+	cgroupV2Path, err := GetCgroupV2Path(cgroupPath, false)
+	if err != nil {
+		return nil
+	}
+
+	if err := syscall.Rmdir(cgroupV2Path); err != nil && err != syscall.ENOENT {
+		return fmt.Errorf("Remove cgroup %v error %v", cgroupV2Path, err)
+	}
+
+	log.Debugf("Remove cgroup v2 %v", cgroupV2Path)
+	return nil
+}
+
+// getContainerCgroupV2Path 获取容器进程所在的独立 cgroup v2
+// 进程不在其中时 (如旧版本启动的容器仍在 qsrdockerd 的 cgroup 中) 返回错误, 不操作共享的 cgroup
+func getContainerCgroupV2Path(cgroupPath string, pid int) (string, error) {
+
+	cgroupV2Path, err := GetCgroupV2Path(cgroupPath, false)
+	if err != nil {
+		return "", err
+	}
+
+	procCgroupV2Path, err := FindCgroupV2Path(pid)
+	if err != nil {
+		return "", err
+	}
+
+	if procCgroupV2Path != cgroupV2Path {
+		return "", fmt.Errorf("Process %v is in cgroup %v instead of container cgroup %v, restart the container", pid, procCgroupV2Path, cgroupV2Path)
+	}
+
+	return cgroupV2Path, nil
+}
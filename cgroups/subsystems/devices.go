@@ -0,0 +1,325 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// devices subsystem 相关常量
+const (
+	DevicesSubsystem = "devices"       // cgroup v1 subsystem 名称
+	DevicesAllowFile = "devices.allow" // cgroup v1 允许访问的设备
+	DevicesDenyFile  = "devices.deny"  // cgroup v1 禁止访问的设备
+	DevicesListFile  = "devices.list"  // cgroup v1 当前允许访问的设备
+
+	// DeviceAllowAll 不限制设备访问, --privileged 使用
+	DeviceAllowAll = "a *:* rwm"
+
+	// bpf 系统调用参数
+	bpfProgLoad             = 5
+	bpfProgAttach           = 8
+	bpfProgTypeCgroupDevice = 15
+	bpfCgroupDevice         = 6
+
+	// bpf_cgroup_dev_ctx 中的设备类型与访问类型
+	bpfDevcgDevBlock  = 1
+	bpfDevcgDevChar   = 2
+	bpfDevcgAccMknod  = 1
+	bpfDevcgAccRead   = 2
+	bpfDevcgAccWrite  = 4
+	bpfDevcgAccessAll = bpfDevcgAccMknod | bpfDevcgAccRead | bpfDevcgAccWrite
+)
+
+// DefaultDeviceRules 容器默认允许访问的设备, 与 docker 相同
+// 允许 mknod 任意设备, 但只能读写以下设备
+var DefaultDeviceRules = []string{
+	"c *:* m",
+	"b *:* m",
+	"c 1:3 rwm",    // /dev/null
+	"c 1:5 rwm",    // /dev/zero
+	"c 1:7 rwm",    // /dev/full
+	"c 1:8 rwm",    // /dev/random
+	"c 1:9 rwm",    // /dev/urandom
+	"c 5:0 rwm",    // /dev/tty
+	"c 5:1 rwm",    // /dev/console
+	"c 5:2 rwm",    // /dev/ptmx
+	"c 136:* rwm",  // /dev/pts/*
+	"c 10:200 rwm", // /dev/net/tun
+}
+
+// DeviceRule devices cgroup 的一条规则, 格式为 type major:minor access
+// type 为 a b c, major minor 为 * 时匹配所有, access 为 rwm 的组合
+type DeviceRule struct {
+	Type   byte
+	Major  int64 // -1 表示 *
+	Minor  int64 // -1 表示 *
+	Access string
+}
+
+// String 转换为 devices.allow 的格式
+func (r *DeviceRule) String() string {
+	number := func(n int64) string {
+		if n < 0 {
+			return "*"
+		}
+		return strconv.FormatInt(n, 10)
+	}
+	return fmt.Sprintf("%c %v:%v %v", r.Type, number(r.Major), number(r.Minor), r.Access)
+}
+
+// ParseDeviceRule 解析一条设备规则, 如 c 1:3 rwm
+func ParseDeviceRule(rule string) (*DeviceRule, error) {
+
+	fields := strings.Fields(rule)
+	if len(fields) != 3 || len(fields[0]) != 1 || !strings.Contains("abc", fields[0]) {
+		return nil, fmt.Errorf("Invalid device rule %v, use type major:minor access like c 1:3 rwm", rule)
+	}
+
+	numbers := strings.Split(fields[1], ":")
+	if len(numbers) != 2 {
+		return nil, fmt.Errorf("Invalid device rule %v, use type major:minor access like c 1:3 rwm", rule)
+	}
+
+	deviceRule := &DeviceRule{Type: fields[0][0], Access: fields[2]}
+	for i, number := range []*int64{&deviceRule.Major, &deviceRule.Minor} {
+		if numbers[i] == "*" {
+			*number = -1
+			continue
+		}
+		n, err := strconv.ParseInt(numbers[i], 10, 64)
+		if err != nil || n < 0 || n > 0xffffffff {
+			return nil, fmt.Errorf("Invalid device number %v in rule %v", numbers[i], rule)
+		}
+		*number = n
+	}
+
+	if deviceRule.Access == "" || strings.Trim(deviceRule.Access, "rwm") != "" {
+		return nil, fmt.Errorf("Invalid device access %v in rule %v, use a combination of r w m", deviceRule.Access, rule)
+	}
+
+	return deviceRule, nil
+}
+
+// ParseDeviceRules 解析 ResourceConfig.Devices, 多条规则以 , 分隔
+func ParseDeviceRules(devices string) ([]*DeviceRule, error) {
+	var rules []*DeviceRule
+	for _, rule := range strings.Split(devices, ",") {
+		deviceRule, err := ParseDeviceRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, deviceRule)
+	}
+	return rules, nil
+}
+
+// setDevices 设置容器允许访问的设备, 先禁止所有设备再逐条允许
+// 未配置时不限制, 兼容旧版本创建的容器
+// 未挂载 cgroup v1 devices 时使用 cgroup v2 的 BPF 设备程序
+func setDevices(cgroupPath, devices string) error {
+
+	if strings.TrimSpace(devices) == "" {
+		return nil
+	}
+
+	rules, err := ParseDeviceRules(devices)
+	if err != nil {
+		return err
+	}
+
+	if FindCgroupMountpoint(DevicesSubsystem) == "" {
+		return setCgroupV2Devices(cgroupPath, rules)
+	}
+
+	subsysCgroupPath, err := GetCgroupPath(DevicesSubsystem, cgroupPath, true)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+
+	// 新建的 cgroup 继承父节点的 a *:* rwm, 需要先禁止所有设备
+	// update 时同样先清空白名单再写入全部规则, 否则无法收回已允许的设备
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, DevicesDenyFile), []byte("a"), 0644); err != nil {
+		return fmt.Errorf("cgroup %s-%s fail %v", DevicesSubsystem, DevicesDenyFile, err)
+	}
+
+	// devices.allow 每次只能写入一条规则
+	for _, rule := range rules {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, DevicesAllowFile), []byte(rule.String()), 0644); err != nil {
+			return fmt.Errorf("cgroup %s-%s %v fail %v", DevicesSubsystem, DevicesAllowFile, rule, err)
+		}
+	}
+
+	log.Debugf("Set cgroup %v in %v: %v", DevicesSubsystem, subsysCgroupPath, devices)
+
+	return nil
+}
+
+// setCgroupV2Devices 加载 BPF 设备程序并挂载到容器的 cgroup v2
+// 不使用 BPF_F_ALLOW_MULTI, 再次挂载时替换原来的程序
+func setCgroupV2Devices(cgroupPath string, rules []*DeviceRule) error {
+
+	cgroupV2Path, err := GetCgroupV2Path(cgroupPath, true)
+	if err != nil {
+		return err
+	}
+
+	program := compileDeviceRules(rules)
+	license := []byte("GPL\x00")
+
+	// union bpf_attr BPF_PROG_LOAD
+	loadAttr := struct {
+		progType    uint32
+		insnCnt     uint32
+		insns       uint64
+		license     uint64
+		logLevel    uint32
+		logSize     uint32
+		logBuf      uint64
+		kernVersion uint32
+		progFlags   uint32
+	}{
+		progType: bpfProgTypeCgroupDevice,
+		insnCnt:  uint32(len(program)),
+		insns:    uint64(uintptr(unsafe.Pointer(&program[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+
+	progFd, err := bpf(bpfProgLoad, unsafe.Pointer(&loadAttr), unsafe.Sizeof(loadAttr))
+	// loadAttr 中只保存了地址, 保证系统调用返回前指令不被回收
+	runtime.KeepAlive(program)
+	runtime.KeepAlive(license)
+	if err != nil {
+		return fmt.Errorf("Load BPF device program error %v", err)
+	}
+	defer syscall.Close(progFd)
+
+	cgroupFd, err := syscall.Open(cgroupV2Path, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("Open cgroup %v error %v", cgroupV2Path, err)
+	}
+	defer syscall.Close(cgroupFd)
+
+	// union bpf_attr BPF_PROG_ATTACH
+	attachAttr := struct {
+		targetFd    uint32
+		attachBpfFd uint32
+		attachType  uint32
+		attachFlags uint32
+	}{
+		targetFd:    uint32(cgroupFd),
+		attachBpfFd: uint32(progFd),
+		attachType:  bpfCgroupDevice,
+	}
+
+	if _, err := bpf(bpfProgAttach, unsafe.Pointer(&attachAttr), unsafe.Sizeof(attachAttr)); err != nil {
+		return fmt.Errorf("Attach BPF device program to %v error %v", cgroupV2Path, err)
+	}
+
+	log.Debugf("Set cgroup v2 %v in %v: %d rules", DevicesSubsystem, cgroupV2Path, len(rules))
+
+	return nil
+}
+
+// bpf 系统调用, 返回新的文件描述符
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	if sysBPF == 0 {
+		return -1, fmt.Errorf("bpf is not supported on this architecture")
+	}
+	fd, _, errno := syscall.Syscall(sysBPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// bpfInsn eBPF 指令 struct bpf_insn, regs 低 4 位为 dst_reg 高 4 位为 src_reg
+type bpfInsn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+// eBPF 指令编码
+const (
+	bpfLdxMemW   = 0x61 // dst = *(u32 *)(src + off)
+	bpfAlu32AndK = 0x54 // dst &= imm
+	bpfAlu32RshK = 0x74 // dst >>= imm
+	bpfAlu64MovK = 0xb7 // dst = imm
+	bpfJmpJneK   = 0x55 // if dst != imm goto pc + off
+	bpfJmpJsetK  = 0x45 // if dst & imm goto pc + off
+	bpfJmpExit   = 0x95 // return r0
+)
+
+// compileDeviceRules 生成 BPF_PROG_TYPE_CGROUP_DEVICE 程序, 返回 1 允许 0 禁止
+// r1 为 struct bpf_cgroup_dev_ctx { u32 access_type; u32 major; u32 minor; }
+// access_type 低 16 位为设备类型, 高 16 位为访问类型
+// 每条规则不匹配时跳过该规则的指令, 所有规则都不匹配时禁止
+func compileDeviceRules(rules []*DeviceRule) []bpfInsn {
+
+	program := []bpfInsn{
+		{code: bpfLdxMemW, regs: 2 | 1<<4, off: 0},
+		{code: bpfAlu32AndK, regs: 2, imm: 0xffff},
+		{code: bpfLdxMemW, regs: 3 | 1<<4, off: 0},
+		{code: bpfAlu32RshK, regs: 3, imm: 16},
+		{code: bpfLdxMemW, regs: 4 | 1<<4, off: 4},
+		{code: bpfLdxMemW, regs: 5 | 1<<4, off: 8},
+	}
+
+	for _, rule := range rules {
+		var block []bpfInsn
+
+		switch rule.Type {
+		case 'b':
+			block = append(block, bpfInsn{code: bpfJmpJneK, regs: 2, imm: bpfDevcgDevBlock})
+		case 'c':
+			block = append(block, bpfInsn{code: bpfJmpJneK, regs: 2, imm: bpfDevcgDevChar})
+		}
+
+		access := 0
+		for _, c := range rule.Access {
+			switch c {
+			case 'm':
+				access |= bpfDevcgAccMknod
+			case 'r':
+				access |= bpfDevcgAccRead
+			case 'w':
+				access |= bpfDevcgAccWrite
+			}
+		}
+		if access != bpfDevcgAccessAll {
+			block = append(block, bpfInsn{code: bpfJmpJsetK, regs: 3, imm: int32(bpfDevcgAccessAll &^ access)})
+		}
+
+		if rule.Major >= 0 {
+			block = append(block, bpfInsn{code: bpfJmpJneK, regs: 4, imm: int32(uint32(rule.Major))})
+		}
+		if rule.Minor >= 0 {
+			block = append(block, bpfInsn{code: bpfJmpJneK, regs: 5, imm: int32(uint32(rule.Minor))})
+		}
+
+		// 跳转到下一条规则
+		for i := range block {
+			block[i].off = int16(len(block) - i + 1)
+		}
+
+		block = append(block,
+			bpfInsn{code: bpfAlu64MovK, regs: 0, imm: 1},
+			bpfInsn{code: bpfJmpExit},
+		)
+		program = append(program, block...)
+	}
+
+	return append(program,
+		bpfInsn{code: bpfAlu64MovK, regs: 0, imm: 0},
+		bpfInsn{code: bpfJmpExit},
+	)
+}
@@ -0,0 +1,4 @@
+package subsystems
+
+// sysBPF amd64 的 bpf 系统调用编号, syscall 包中没有定义
+const sysBPF = 321
@@ -0,0 +1,4 @@
+package subsystems
+
+// sysBPF arm64 的 bpf 系统调用编号
+const sysBPF = 280
@@ -0,0 +1,7 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package subsystems
+
+// 其他架构不支持 cgroup v2 的 BPF 设备程序
+const sysBPF = 0
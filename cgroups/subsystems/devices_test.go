@@ -0,0 +1,67 @@
+package subsystems
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDeviceRule(t *testing.T) {
+	for _, c := range []struct {
+		rule string
+		want DeviceRule
+	}{
+		{"c 1:3 rwm", DeviceRule{Type: 'c', Major: 1, Minor: 3, Access: "rwm"}},
+		{"b 8:* r", DeviceRule{Type: 'b', Major: 8, Minor: -1, Access: "r"}},
+		{"c 136:* rw", DeviceRule{Type: 'c', Major: 136, Minor: -1, Access: "rw"}},
+		{" a *:* m ", DeviceRule{Type: 'a', Major: -1, Minor: -1, Access: "m"}},
+	} {
+		rule, err := ParseDeviceRule(c.rule)
+		if err != nil {
+			t.Fatalf("parse device rule %v error : %v", c.rule, err)
+		}
+		if !reflect.DeepEqual(*rule, c.want) {
+			t.Fatalf("device rule %v => %+v, want %+v", c.rule, *rule, c.want)
+		}
+	}
+
+	for _, rule := range []string{
+		"",
+		"c 1:3",
+		"c 1:3 rwm extra",
+		"x 1:3 rwm",
+		"cb 1:3 rwm",
+		"c 1 rwm",
+		"c 1:3:4 rwm",
+		"c a:3 rwm",
+		"c -1:3 rwm",
+		"c 1:4294967296 rwm",
+		"c 1:3 rwx",
+	} {
+		if _, err := ParseDeviceRule(rule); err == nil {
+			t.Fatalf("parse device rule %v should fail", rule)
+		}
+	}
+}
+
+func TestDeviceRuleString(t *testing.T) {
+	for _, rule := range append([]string{DeviceAllowAll}, DefaultDeviceRules...) {
+		deviceRule, err := ParseDeviceRule(rule)
+		if err != nil {
+			t.Fatalf("parse device rule %v error : %v", rule, err)
+		}
+		if deviceRule.String() != rule {
+			t.Fatalf("device rule %v => %v", rule, deviceRule.String())
+		}
+	}
+
+	rules, err := ParseDeviceRules("c 1:3 rwm,b 8:0 r")
+	if err != nil {
+		t.Fatalf("parse device rules error : %v", err)
+	}
+	if len(rules) != 2 || rules[1].String() != "b 8:0 r" {
+		t.Fatalf("parse device rules => %v", rules)
+	}
+	if _, err := ParseDeviceRules("c 1:3 rwm,"); err == nil {
+		t.Fatalf("parse device rules with empty rule should fail")
+	}
+}
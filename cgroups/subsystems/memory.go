@@ -0,0 +1,236 @@
+package subsystems
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// memory subsystem 相关常量
+const (
+	MemorySubsystem = "memory"             // cgroup v1 subsystem 名称
+	OOMControlFile  = "memory.oom_control" // cgroup v1 oom 控制文件
+
+	cgroupEventControlFile   = "cgroup.event_control" // cgroup v1 注册 eventfd 通知
+	cgroupV2MemoryEventsFile = "memory.events"        // cgroup v2 内存事件计数
+
+	// eventfd2 flags
+	efdCloexec  = 0x80000
+	efdNonblock = 0x800
+)
+
+// OOMState 容器 cgroup 的 OOM 状态
+type OOMState struct {
+	OOMKillDisable bool // memory.oom_control oom_kill_disable
+	UnderOOM       bool // 处于 OOM 状态, oom_kill_disable 时进程会被挂起
+	OOMCount       int  // 发生 OOM 的次数, 只有 cgroup v2 提供
+	OOMKillCount   int  // 被 OOM killer 杀死的进程数
+}
+
+// GetOOMState 获取 cgroupPath 当前的 OOM 状态
+// 未挂载 memory subsystem 时读取容器独立 cgroup v2 的 memory.events
+func GetOOMState(cgroupPath string, pid int) (*OOMState, error) {
+
+	if FindCgroupMountpoint(MemorySubsystem) == "" {
+		return getCgroupV2OOMState(cgroupPath, pid)
+	}
+
+	subsysCgroupPath, err := GetCgroupPath(MemorySubsystem, cgroupPath, false)
+	if err != nil {
+		return nil, fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+
+	// oom_kill_disable 0
+	// under_oom 0
+	// oom_kill 0
+	values, err := readKeyValueFile(path.Join(subsysCgroupPath, OOMControlFile))
+	if err != nil {
+		return nil, err
+	}
+
+	return &OOMState{
+		OOMKillDisable: values["oom_kill_disable"] == 1,
+		UnderOOM:       values["under_oom"] == 1,
+		OOMKillCount:   values["oom_kill"],
+	}, nil
+}
+
+// getCgroupV2OOMState 读取 cgroup v2 memory.events 中的 oom / oom_kill 计数
+// 容器进程不在独立的 cgroup v2 中时返回错误, 避免 qsrdockerd 与其他容器的 OOM 被记录到该容器
+func getCgroupV2OOMState(cgroupPath string, pid int) (*OOMState, error) {
+
+	cgroupV2Path, err := getContainerCgroupV2Path(cgroupPath, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	// low 0
+	// high 0
+	// max 0
+	// oom 0
+	// oom_kill 0
+	values, err := readKeyValueFile(path.Join(cgroupV2Path, cgroupV2MemoryEventsFile))
+	if err != nil {
+		return nil, err
+	}
+
+	return &OOMState{
+		OOMCount:     values["oom"],
+		OOMKillCount: values["oom_kill"],
+	}, nil
+}
+
+// NotifyOOM 注册 cgroupPath 的 OOM 通知, 每次 OOM 向返回的 channel 发送一次
+// cgroup v1 通过 cgroup.event_control 注册 eventfd, cgroup v2 轮询 memory.events
+// 返回的函数用于取消注册, 之后 channel 会被关闭
+func NotifyOOM(cgroupPath string, pid int) (<-chan struct{}, func(), error) {
+
+	if FindCgroupMountpoint(MemorySubsystem) == "" {
+		return notifyCgroupV2OOM(cgroupPath, pid)
+	}
+
+	subsysCgroupPath, err := GetCgroupPath(MemorySubsystem, cgroupPath, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+
+	oomControl, err := os.Open(path.Join(subsysCgroupPath, OOMControlFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Open cgroup %s-%s fail %v", MemorySubsystem, OOMControlFile, err)
+	}
+
+	// 非阻塞的 eventfd 由 runtime poller 管理, Close 时阻塞的 Read 会立即返回
+	efd, _, errno := syscall.RawSyscall(syscall.SYS_EVENTFD2, 0, efdCloexec|efdNonblock, 0)
+	if errno != 0 {
+		oomControl.Close()
+		return nil, nil, fmt.Errorf("Create eventfd fail %v", errno)
+	}
+	eventFile := os.NewFile(efd, "eventfd")
+
+	// 写入 "<eventfd> <memory.oom_control fd>"
+	eventControl := fmt.Sprintf("%d %d", efd, oomControl.Fd())
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, cgroupEventControlFile), []byte(eventControl), 0700); err != nil {
+		eventFile.Close()
+		oomControl.Close()
+		return nil, nil, fmt.Errorf("cgroup %s fail %v", cgroupEventControlFile, err)
+	}
+
+	log.Debugf("Register oom notify in %v", subsysCgroupPath)
+
+	oomCh := make(chan struct{}, 1)
+
+	go func() {
+		defer close(oomCh)
+		defer oomControl.Close()
+
+		buf := make([]byte, 8)
+		for {
+			if _, err := eventFile.Read(buf); err != nil {
+				return
+			}
+
+			// cgroup 被删除时 eventfd 同样会收到通知
+			if exist, _ := pathExists(path.Join(subsysCgroupPath, cgroupEventControlFile)); !exist {
+				return
+			}
+
+			sendOOMNotify(oomCh)
+		}
+	}()
+
+	return oomCh, func() { eventFile.Close() }, nil
+}
+
+// notifyCgroupV2OOM 轮询 cgroup v2 memory.events 中 oom 计数的变化
+func notifyCgroupV2OOM(cgroupPath string, pid int) (<-chan struct{}, func(), error) {
+
+	lastState, err := getCgroupV2OOMState(cgroupPath, pid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	oomCh := make(chan struct{}, 1)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(oomCh)
+
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			state, err := getCgroupV2OOMState(cgroupPath, pid)
+			if err != nil {
+				// 进程已退出
+				return
+			}
+
+			if state.OOMCount > lastState.OOMCount || state.OOMKillCount > lastState.OOMKillCount {
+				sendOOMNotify(oomCh)
+			}
+			lastState = state
+		}
+	}()
+
+	return oomCh, func() { close(done) }, nil
+}
+
+// sendOOMNotify 尚未被读取的通知合并为一次
+func sendOOMNotify(oomCh chan struct{}) {
+	select {
+	case oomCh <- struct{}{}:
+	default:
+	}
+}
+
+// readKeyValueFile 读取 "key value" 格式的 cgroup 文件
+func readKeyValueFile(filePath string) (map[string]int, error) {
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Read cgroup file %s fail %v", filePath, err)
+	}
+	defer f.Close()
+
+	values := map[string]int{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		if value, err := strconv.Atoi(fields[1]); err == nil {
+			values[fields[0]] = value
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// pathExists 判断文件是否存在
+func pathExists(filePath string) (bool, error) {
+	_, err := os.Stat(filePath)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,248 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 只用于统计资源使用的 subsystem, 不设置资源限制
+const (
+	CPUAcctSubsystem = "cpuacct" // cpuacct.usage
+	PidsSubsystem    = "pids"    // pids.current pids.max
+	BlkioSubsystem   = "blkio"   // blkio.throttle.io_service_bytes
+)
+
+// ControlSubsystems ResourceConfig 之外, 容器进程同样需要加入的 subsystem
+// freezer 用于 pause / unpause, 其余用于 stats
+var ControlSubsystems = []string{FreezerSubsystem, CPUAcctSubsystem, PidsSubsystem, BlkioSubsystem}
+
+// Stats cgroup 资源使用统计
+type Stats struct {
+	CPUUsage    uint64 `json:"CPUUsage"`    // 容器累计使用的 CPU 时间, 单位 ns
+	MemoryUsage uint64 `json:"MemoryUsage"` // 内存使用量, 不包含 page cache
+	MemoryCache uint64 `json:"MemoryCache"` // page cache
+	MemoryLimit uint64 `json:"MemoryLimit"` // 内存限制, 未限制时为宿主机内存
+	PidsCurrent uint64 `json:"PidsCurrent"` // 进程(线程)数
+	PidsLimit   uint64 `json:"PidsLimit"`   // 进程数限制, 0 为不限制
+	BlkioRead   uint64 `json:"BlkioRead"`   // 块设备读取字节数
+	BlkioWrite  uint64 `json:"BlkioWrite"`  // 块设备写入字节数
+}
+
+// GetStats 读取 cgroupPath 的资源使用统计
+// 未挂载 cgroup v1 subsystem 时读取容器独立 cgroup v2 的对应文件
+// 单个 subsystem 读取失败不影响其他统计
+func GetStats(cgroupPath string, pid int) (*Stats, error) {
+
+	stats := &Stats{}
+	var errs []string
+
+	for _, getStats := range []func(string, int, *Stats) error{
+		getCPUStats,
+		getMemoryStats,
+		getPidsStats,
+		getBlkioStats,
+	} {
+		if err := getStats(cgroupPath, pid, stats); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	// 全部失败
+	if len(errs) == 4 {
+		return nil, fmt.Errorf("Get cgroup %v stats error: %v", cgroupPath, strings.Join(errs, "; "))
+	}
+
+	return stats, nil
+}
+
+// getCPUStats cgroup v1 cpuacct.usage, cgroup v2 cpu.stat usage_usec
+func getCPUStats(cgroupPath string, pid int, stats *Stats) error {
+
+	statsPath, v2, err := getStatsPath(cgroupPath, CPUAcctSubsystem, pid)
+	if err != nil {
+		return err
+	}
+
+	if v2 {
+		values, err := readKeyValueFile(path.Join(statsPath, "cpu.stat"))
+		if err != nil {
+			return err
+		}
+		stats.CPUUsage = uint64(values["usage_usec"]) * 1000
+		return nil
+	}
+
+	stats.CPUUsage, err = readUintFile(path.Join(statsPath, "cpuacct.usage"))
+	return err
+}
+
+// getMemoryStats cgroup v1 memory.usage_in_bytes memory.stat memory.limit_in_bytes
+// cgroup v2 memory.current memory.stat memory.max
+func getMemoryStats(cgroupPath string, pid int, stats *Stats) error {
+
+	statsPath, v2, err := getStatsPath(cgroupPath, MemorySubsystem, pid)
+	if err != nil {
+		return err
+	}
+
+	usageFile, limitFile, cacheKey := "memory.usage_in_bytes", "memory.limit_in_bytes", "cache"
+	if v2 {
+		usageFile, limitFile, cacheKey = "memory.current", "memory.max", "file"
+	}
+
+	usage, err := readUintFile(path.Join(statsPath, usageFile))
+	if err != nil {
+		return err
+	}
+
+	if values, err := readKeyValueFile(path.Join(statsPath, "memory.stat")); err == nil {
+		stats.MemoryCache = uint64(values[cacheKey])
+	}
+
+	if usage > stats.MemoryCache {
+		usage -= stats.MemoryCache
+	}
+	stats.MemoryUsage = usage
+
+	// 未设置限制时 v1 为一个极大值, v2 为 max, 均以宿主机内存为准
+	hostMemory := getHostMemory()
+	limit, err := readUintFile(path.Join(statsPath, limitFile))
+	if err != nil || limit == 0 || (hostMemory > 0 && limit > hostMemory) {
+		limit = hostMemory
+	}
+	stats.MemoryLimit = limit
+
+	return nil
+}
+
+// getPidsStats pids.current pids.max, v1 v2 相同
+func getPidsStats(cgroupPath string, pid int, stats *Stats) error {
+
+	statsPath, _, err := getStatsPath(cgroupPath, PidsSubsystem, pid)
+	if err != nil {
+		return err
+	}
+
+	if stats.PidsCurrent, err = readUintFile(path.Join(statsPath, "pids.current")); err != nil {
+		return err
+	}
+
+	// max 为不限制
+	stats.PidsLimit, _ = readUintFile(path.Join(statsPath, "pids.max"))
+
+	return nil
+}
+
+// getBlkioStats cgroup v1 blkio.throttle.io_service_bytes, cgroup v2 io.stat
+func getBlkioStats(cgroupPath string, pid int, stats *Stats) error {
+
+	statsPath, v2, err := getStatsPath(cgroupPath, BlkioSubsystem, pid)
+	if err != nil {
+		return err
+	}
+
+	if v2 {
+		// 8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0
+		content, err := ioutil.ReadFile(path.Join(statsPath, "io.stat"))
+		if err != nil {
+			return fmt.Errorf("Read cgroup io.stat fail %v", err)
+		}
+
+		for _, line := range strings.Split(string(content), "\n") {
+			for _, field := range strings.Fields(line) {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				value, _ := strconv.ParseUint(kv[1], 10, 64)
+				switch kv[0] {
+				case "rbytes":
+					stats.BlkioRead += value
+				case "wbytes":
+					stats.BlkioWrite += value
+				}
+			}
+		}
+		return nil
+	}
+
+	// 8:0 Read 1459200
+	// 8:0 Write 314773504
+	// Total 316232704
+	content, err := ioutil.ReadFile(path.Join(statsPath, "blkio.throttle.io_service_bytes"))
+	if err != nil {
+		return fmt.Errorf("Read cgroup blkio.throttle.io_service_bytes fail %v", err)
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		value, _ := strconv.ParseUint(fields[2], 10, 64)
+		switch fields[1] {
+		case "Read":
+			stats.BlkioRead += value
+		case "Write":
+			stats.BlkioWrite += value
+		}
+	}
+
+	return nil
+}
+
+// getStatsPath 获取 subsystem 中 cgroupPath 的绝对路径
+// 未挂载该 cgroup v1 subsystem 时返回容器独立的 cgroup v2 路径
+func getStatsPath(cgroupPath, subsystem string, pid int) (string, bool, error) {
+
+	if FindCgroupMountpoint(subsystem) == "" {
+		cgroupV2Path, err := getContainerCgroupV2Path(cgroupPath, pid)
+		return cgroupV2Path, true, err
+	}
+
+	subsysCgroupPath, err := GetCgroupPath(subsystem, cgroupPath, false)
+	if err != nil {
+		return "", false, fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+
+	return subsysCgroupPath, false, nil
+}
+
+// readUintFile 读取只有一个数字的 cgroup 文件, max 返回 0
+func readUintFile(filePath string) (uint64, error) {
+
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("Read cgroup file %s fail %v", filePath, err)
+	}
+
+	valueStr := strings.TrimSpace(string(content))
+	if valueStr == "max" {
+		return 0, nil
+	}
+
+	return strconv.ParseUint(valueStr, 10, 64)
+}
+
+// getHostMemory 读取 /proc/meminfo 中的 MemTotal, 单位 byte
+func getHostMemory() uint64 {
+
+	content, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+
+	// MemTotal:       16330564 kB
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			memTotal, _ := strconv.ParseUint(fields[1], 10, 64)
+			return memTotal * 1024
+		}
+	}
+
+	return 0
+}
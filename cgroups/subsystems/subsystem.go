@@ -15,6 +15,11 @@ import (
 // Init 初始化 cgroup /sys/fs/[subsystem]/qsrdocker
 func Init(subsystem, subsystemFile string) error {
 
+	// 未挂载该 cgroup v1 subsystem 时使用 cgroup v2, 由 InitCgroupV2 创建 qsrdocker
+	if FindCgroupMountpoint(subsystem) == "" {
+		return nil
+	}
+
 	// cgroupRoot 初始化根目录
 	cgroupRoot := FindCgroupMountpoint(subsystem)
 	cgroupRoot = path.Join(cgroupRoot, "qsrdocker")
@@ -79,6 +84,16 @@ func Init(subsystem, subsystemFile string) error {
 // Set 设置CgroupPath对应的 cgroup 的内存资源限制
 func Set(cgroupPath, subsystem, subsystemFile, cgroupConf string) error {
 
+	// devices 需要逐条写入规则, 空值表示不限制而不是使用父节点配置
+	if subsystem == DevicesSubsystem {
+		return setDevices(cgroupPath, cgroupConf)
+	}
+
+	// 未挂载该 cgroup v1 subsystem 时写入容器独立 cgroup v2 中对应的文件
+	if FindCgroupMountpoint(subsystem) == "" {
+		return setCgroupV2(cgroupPath, subsystem, subsystemFile, cgroupConf)
+	}
+
 	// GetCgroupPath 是获取当前VFS中 cgroup 的路径
 	subsysCgroupPath, err := GetCgroupPath(subsystem, cgroupPath, true)
 	if err == nil {
@@ -93,7 +108,8 @@ func Set(cgroupPath, subsystem, subsystemFile, cgroupConf string) error {
 			cgroupConf = strings.ReplaceAll(string(cgroupConfByte), " ", "")
 
 			// 若父节点无配置，直接返回
-			if cgroupConf == "" {
+			// memory.oom_control 等多行的状态文件无法直接写回，同样直接返回
+			if cgroupConf == "" || strings.Contains(strings.TrimSpace(cgroupConf), "\n") {
 				return nil
 			}
 
@@ -116,8 +132,15 @@ func Set(cgroupPath, subsystem, subsystemFile, cgroupConf string) error {
 
 // Apply 将进程加入到cgroupPath对应的cgroup中
 func Apply(cgroupPath, subsystem, subsystemFile string, pid int) error {
+
+	// 容器进程由 ApplyCgroupV2 移动到独立的 cgroup v2 中
+	if FindCgroupMountpoint(subsystem) == "" {
+		return nil
+	}
+
 	// GetCgroupPath 获取 cgroup 在虚拟文件系统的虚拟路径
-	subsysCgroupPath, err := GetCgroupPath(subsystem, cgroupPath, false)
+	// freezer 等没有资源限制的 subsystem 不会经过 Set, 在此创建目录
+	subsysCgroupPath, err := GetCgroupPath(subsystem, cgroupPath, true)
 	if err == nil {
 		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
 			// 将进程PID加入到对应目录下的 task 文件中
@@ -133,6 +156,11 @@ func Apply(cgroupPath, subsystem, subsystemFile string, pid int) error {
 
 // Remove 删除 cgroupPath 对应的 cgroup
 func Remove(cgroupPath, subsystem, subsystemFile string) error {
+	// 独立的 cgroup v2 由 RemoveCgroupV2 删除
+	if FindCgroupMountpoint(subsystem) == "" {
+		return nil
+	}
+
 	subsysCgroupPath, err := GetCgroupPath(subsystem, cgroupPath, false)
 	// 存在 err ，则已经被删除了
 	if err != nil {
@@ -146,3 +174,38 @@ func Remove(cgroupPath, subsystem, subsystemFile string) error {
 	log.Debugf("Remove cgroup %v-%s", subsystem, subsysCgroupPath)
 	return nil
 }
+
+// GetPids 获取 cgroupPath 中所有进程的 PID
+// 读取 Apply 写入的 cgroup 中的 cgroup.procs, 未挂载该 subsystem 时读取容器独立 cgroup v2 的 cgroup.procs
+func GetPids(cgroupPath, subsystem string, pid int) ([]int, error) {
+
+	var procsPath string
+
+	if FindCgroupMountpoint(subsystem) == "" {
+		cgroupV2Path, err := getContainerCgroupV2Path(cgroupPath, pid)
+		if err != nil {
+			return nil, err
+		}
+		procsPath = path.Join(cgroupV2Path, cgroupV2ProcsFile)
+	} else {
+		subsysCgroupPath, err := GetCgroupPath(subsystem, cgroupPath, false)
+		if err != nil {
+			return nil, fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+		}
+		procsPath = path.Join(subsysCgroupPath, "cgroup.procs")
+	}
+
+	procsBytes, err := ioutil.ReadFile(procsPath)
+	if err != nil {
+		return nil, fmt.Errorf("Read cgroup procs %v fail %v", procsPath, err)
+	}
+
+	var pids []int
+	for _, pidStr := range strings.Fields(string(procsBytes)) {
+		if pid, err := strconv.Atoi(pidStr); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids, nil
+}
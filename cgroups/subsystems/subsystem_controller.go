@@ -1,5 +1,12 @@
 package subsystems
 
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+)
+
 // ResourceConfig is cgroup 限制资源的配置结构体
 type ResourceConfig struct {
 	MemoryLimit string 		`json:"MemoryLimit" file:"memory.limit_in_bytes" subsystem:"memory"`	// 内存限制
@@ -7,4 +14,58 @@ type ResourceConfig struct {
 	CPUSet      string 		`json:"CPUSet" file:"cpuset.cpus" subsystem:"cpuset"`	// CPU核心数
 	CPUMem		string 		`json:"CPUMem" file:"cpuset.mems" subsystem:"cpuset"`	// NUMA 模式下cpu
 	OOMKillDisable string 	`json:"OOMKillDisable" file:"memory.oom_control" subsystem:"memory"` // 设置/读取内存超限控制信息
-}
\ No newline at end of file
+	Devices     string 		`json:"Devices" file:"devices.allow" subsystem:"devices"` // 允许访问的设备, 以 , 分隔的 type major:minor access
+}
+
+var (
+	// memoryLimitRegexp 内存限制 如 100m 1g 1048576, -1 为不限制
+	memoryLimitRegexp = regexp.MustCompile(`^(-1|[0-9]+[kKmMgG]?)$`)
+	// cpuListRegexp cpuset 格式 如 0-3,5
+	cpuListRegexp = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$`)
+)
+
+// Validate 检查资源限制的格式, 空值表示不设置
+func (r *ResourceConfig) Validate() error {
+
+	if r.MemoryLimit != "" && !memoryLimitRegexp.MatchString(r.MemoryLimit) {
+		return fmt.Errorf("Invalid memory limit %v, use a number with optional unit k, m or g", r.MemoryLimit)
+	}
+
+	if r.CPUShare != "" {
+		// 内核要求 cpu.shares 不小于 2
+		if share, err := strconv.Atoi(r.CPUShare); err != nil || share < 2 {
+			return fmt.Errorf("Invalid cpushare %v, must be an integer not less than 2", r.CPUShare)
+		}
+	}
+
+	if r.CPUSet != "" && !cpuListRegexp.MatchString(r.CPUSet) {
+		return fmt.Errorf("Invalid cpuset %v, use a list like 0-3,5", r.CPUSet)
+	}
+
+	if r.CPUMem != "" && !cpuListRegexp.MatchString(r.CPUMem) {
+		return fmt.Errorf("Invalid cpumem %v, use a list like 0-1", r.CPUMem)
+	}
+
+	if r.OOMKillDisable != "" && r.OOMKillDisable != "0" && r.OOMKillDisable != "1" {
+		return fmt.Errorf("Invalid oom_kill_disable %v, must be 0 or 1", r.OOMKillDisable)
+	}
+
+	if r.Devices != "" {
+		if _, err := ParseDeviceRules(r.Devices); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Update 用 resConfig 中非空的值覆盖当前配置
+func (r *ResourceConfig) Update(resConfig *ResourceConfig) {
+	v := reflect.ValueOf(r).Elem()
+	newV := reflect.ValueOf(resConfig).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if val := newV.Field(i).Interface().(string); val != "" {
+			v.Field(i).SetString(val)
+		}
+	}
+}
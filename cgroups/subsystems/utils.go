@@ -77,4 +77,32 @@ func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string
 	// 无法获取目标目录
 	return "", fmt.Errorf("Cgroup path error %v", err)
 
-}
\ No newline at end of file
+}
+// FindCgroupV2Mountpoint 在 /proc/self/mountinfo 中找到 cgroup2 的挂载点
+// 如：
+// 30 23 0:26 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime shared:4 - cgroup2 cgroup2 rw
+func FindCgroupV2Mountpoint() string {
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// " - " 之后第一个字段为文件系统类型
+		mountInfo := strings.SplitN(scanner.Text(), " - ", 2)
+		if len(mountInfo) != 2 {
+			continue
+		}
+
+		fields := strings.Split(mountInfo[0], " ")
+		if strings.HasPrefix(mountInfo[1], "cgroup2 ") && len(fields) > 4 {
+			log.Debugf("Find cgroup2 Root: %v", fields[4])
+			return fields[4]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// daemonClient 通过 unix socket 访问 qsrdockerd 的 REST API
+var daemonClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+			return net.DialTimeout("unix", DaemonSocket, 5*time.Second)
+		},
+	},
+}
+
+// daemonRequest 请求 qsrdockerd
+// apiPath 不包含版本号，如 /containers/[name]/start
+// body 不为 nil 时序列化为 json 请求体, result 不为 nil 时反序列化返回数据
+func daemonRequest(method, apiPath string, query url.Values, body, result interface{}) error {
+
+	resp, err := doDaemonRequest(method, apiPath, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// daemonStream 请求 qsrdockerd 持续输出的 API, 如 logs -f / events
+// 返回的数据由调用者读取并关闭
+func daemonStream(method, apiPath string, query url.Values, body interface{}) (io.ReadCloser, error) {
+
+	resp, err := doDaemonRequest(method, apiPath, query, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// doDaemonRequest 发送请求, 非 2xx 状态码时返回 daemon 的错误信息
+func doDaemonRequest(method, apiPath string, query url.Values, body interface{}) (*http.Response, error) {
+
+	var reqBody io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("Marshal request body error : %v", err)
+		}
+		reqBody = bytes.NewReader(bodyBytes)
+	}
+
+	// host 无实际意义, 连接由 DialContext 决定
+	reqURL := strings.Join([]string{"http://qsrdockerd/", APIVersion, apiPath}, "")
+	if len(query) > 0 {
+		reqURL = strings.Join([]string{reqURL, query.Encode()}, "?")
+	}
+
+	req, err := http.NewRequest(method, reqURL, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := daemonClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot connect to the qsrdocker daemon at unix://%v, is %v running? %v", DaemonSocket, DaemonName, err)
+	}
+
+	// 非 2xx 状态码，返回 daemon 的错误信息
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+
+		apiErr := &apiError{}
+		if err := json.NewDecoder(resp.Body).Decode(apiErr); err != nil || apiErr.Message == "" {
+			return nil, fmt.Errorf("%v %v : %v", method, apiPath, resp.Status)
+		}
+		return nil, fmt.Errorf("%v", apiErr.Message)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,239 @@
+package container
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// capabilityList capability 名称, 下标为编号
+var capabilityList = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN",
+	"CAP_NET_RAW",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_MKNOD",
+	"CAP_LEASE",
+	"CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MAC_ADMIN",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ",
+	"CAP_PERFMON",
+	"CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// DefaultCapabilities 容器默认保留的 capability, 与 docker 相同
+var DefaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// prctl 参数
+const (
+	prSetKeepCaps     = 8
+	prCapBSetDrop     = 24
+	prSetNoNewPrivs   = 38
+	linuxCapVersion3  = 0x20080522
+	capabilityAllName = "ALL"
+)
+
+// normalizeCapability 转换为 CAP_XXX 的形式, ALL 保持不变
+func normalizeCapability(name string) (string, error) {
+
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == capabilityAllName {
+		return name, nil
+	}
+
+	if !strings.HasPrefix(name, "CAP_") {
+		name = "CAP_" + name
+	}
+
+	for _, capability := range capabilityList {
+		if capability == name {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("Unknown capability %v", name)
+}
+
+// TweakCapabilities 在 base 的基础上删除 --cap-drop 添加 --cap-add
+// --cap-drop ALL 时从空集合开始, --cap-add ALL 时为全部 capability
+// privileged 时为全部 capability
+func TweakCapabilities(base, capAdd, capDrop []string, privileged bool) ([]string, error) {
+
+	if privileged {
+		return append([]string{}, capabilityList...), nil
+	}
+
+	caps := map[string]bool{}
+	for _, capability := range base {
+		caps[capability] = true
+	}
+
+	for _, name := range capDrop {
+		capability, err := normalizeCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		if capability == capabilityAllName {
+			caps = map[string]bool{}
+			continue
+		}
+		delete(caps, capability)
+	}
+
+	for _, name := range capAdd {
+		capability, err := normalizeCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		if capability == capabilityAllName {
+			for _, c := range capabilityList {
+				caps[c] = true
+			}
+			continue
+		}
+		caps[capability] = true
+	}
+
+	result := []string{}
+	for capability := range caps {
+		result = append(result, capability)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// GetCapabilities 获取容器进程的 capability
+// 旧版本创建的容器没有记录, 使用默认的 capability
+func (containerInfo *ContainerInfo) GetCapabilities() []string {
+	if containerInfo.Capabilities == nil {
+		return DefaultCapabilities
+	}
+	return containerInfo.Capabilities
+}
+
+// CapabilityBits capability 名称转换为位图
+func CapabilityBits(caps []string) uint64 {
+	var bits uint64
+	for _, name := range caps {
+		for i, capability := range capabilityList {
+			if capability == name {
+				bits |= 1 << uint(i)
+			}
+		}
+	}
+	return bits
+}
+
+// dropBoundingSet 从 bounding set 中删除不在 caps 中的 capability
+// bounding set 属于线程, 调用前需要 runtime.LockOSThread
+func dropBoundingSet(caps []string) error {
+
+	bits := CapabilityBits(caps)
+
+	for i := uint(0); i < 64; i++ {
+		if bits&(1<<i) != 0 {
+			continue
+		}
+
+		// 内核不支持的 capability 返回 EINVAL
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapBSetDrop, uintptr(i), 0, 0, 0, 0); errno != 0 && errno != syscall.EINVAL {
+			return fmt.Errorf("Drop capability %v from bounding set error %v", i, errno)
+		}
+	}
+
+	return nil
+}
+
+// setKeepCaps 切换到非 root 用户时保留 permitted capability
+func setKeepCaps() error {
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetKeepCaps, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("Set keep capabilities error %v", errno)
+	}
+	return nil
+}
+
+// setCapabilities 设置当前线程的 effective permitted inheritable capability
+func setCapabilities(caps []string) error {
+
+	bits := CapabilityBits(caps)
+
+	header := struct {
+		version uint32
+		pid     int32
+	}{version: linuxCapVersion3}
+
+	data := [2]struct {
+		effective   uint32
+		permitted   uint32
+		inheritable uint32
+	}{}
+
+	for i := range data {
+		value := uint32(bits >> (32 * uint(i)))
+		data[i].effective = value
+		data[i].permitted = value
+		data[i].inheritable = value
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("Set capabilities error %v", errno)
+	}
+
+	return nil
+}
+
+// setNoNewPrivileges 禁止 exec 时通过 setuid 文件或文件 capability 获得权限
+func setNoNewPrivileges() error {
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("Set no new privileges error %v", errno)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// AttachSocketFile 容器 monitor 持有 pty 时监听的 unix socket, qsrdocker attach 连接
+var AttachSocketFile string = "attach.sock"
+
+// DefaultDetachKeys 默认的 detach 按键 ctrl-p ctrl-q
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+// Winsize 终端窗口大小
+type Winsize struct {
+	Rows uint16
+	Cols uint16
+	X    uint16
+	Y    uint16
+}
+
+// NewConsole 创建 pty, 返回 master 与 slave
+// slave 作为容器进程的标准输入输出, master 由 monitor 持有
+func NewConsole() (*os.File, *os.File, error) {
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Open /dev/ptmx error %v", err)
+	}
+
+	// 解锁 slave
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("Unlock pty error %v", err)
+	}
+
+	// 获取 slave 编号 /dev/pts/[n]
+	var ptyNum uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&ptyNum))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("Get pty number error %v", err)
+	}
+
+	slavePath := fmt.Sprintf("/dev/pts/%d", ptyNum)
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("Open %v error %v", slavePath, err)
+	}
+
+	// 默认窗口大小, attach 时以客户端终端为准
+	SetWinsize(master, &Winsize{Rows: 24, Cols: 80})
+
+	return master, slave, nil
+}
+
+// GetWinsize 获取终端窗口大小
+func GetWinsize(f *os.File) (*Winsize, error) {
+	ws := &Winsize{}
+	if err := ioctl(f.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(ws))); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// SetWinsize 设置终端窗口大小, 前台进程组会收到 SIGWINCH
+func SetWinsize(f *os.File, ws *Winsize) error {
+	return ioctl(f.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(ws)))
+}
+
+// IsTerminal 判断 f 是否为终端
+func IsTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	return ioctl(f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios))) == nil
+}
+
+// SetRawTerminal 将终端设置为 raw 模式, 按键原样发送给容器
+// 返回的函数恢复终端原有的设置
+func SetRawTerminal(f *os.File) (func(), error) {
+
+	var oldState syscall.Termios
+	if err := ioctl(f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&oldState))); err != nil {
+		return nil, fmt.Errorf("Get terminal state error %v", err)
+	}
+
+	// 与 cfmakeraw 相同
+	newState := oldState
+	newState.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	newState.Oflag &^= syscall.OPOST
+	newState.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	newState.Cflag &^= syscall.CSIZE | syscall.PARENB
+	newState.Cflag |= syscall.CS8
+	newState.Cc[syscall.VMIN] = 1
+	newState.Cc[syscall.VTIME] = 0
+
+	if err := ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&newState))); err != nil {
+		return nil, fmt.Errorf("Set terminal raw mode error %v", err)
+	}
+
+	return func() {
+		ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&oldState)))
+	}, nil
+}
+
+// ParseDetachKeys 解析 detach 按键, 如 ctrl-p,ctrl-q 或 ctrl-a,d
+func ParseDetachKeys(keys string) ([]byte, error) {
+
+	var detachKeys []byte
+
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+
+		switch {
+		case len(key) == 1:
+			detachKeys = append(detachKeys, key[0])
+
+		case strings.HasPrefix(strings.ToLower(key), "ctrl-") && len(key) == 6:
+			// ctrl-@ 为 0, ctrl-a ~ ctrl-z 为 1 ~ 26, ctrl-[ \ ] ^ _ 为 27 ~ 31
+			c := key[5]
+			switch {
+			case c >= 'a' && c <= 'z':
+				detachKeys = append(detachKeys, c-'a'+1)
+			case c >= 'A' && c <= 'Z':
+				detachKeys = append(detachKeys, c-'A'+1)
+			case c >= '@' && c <= '_':
+				detachKeys = append(detachKeys, c-'@')
+			default:
+				return nil, fmt.Errorf("Invalid detach key %v", key)
+			}
+
+		default:
+			return nil, fmt.Errorf("Invalid detach keys %v, use a comma separated list like ctrl-p,ctrl-q", keys)
+		}
+	}
+
+	return detachKeys, nil
+}
+
+// ioctl 系统调用
+func ioctl(fd, request, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"net"
 	"path"
 	"qsrdocker/cgroups"
+	"qsrdocker/cgroups/subsystems"
 
 	"github.com/vishvananda/netlink"
 )
@@ -26,16 +27,23 @@ var (
 	NetFileDir string = path.Join(NetWorkDir, "netfile")
 	// IPFileDir
 	NetIPadminDir string = path.Join(NetWorkDir, "ipam")
+	// VolumeDir 命名数据卷, 数据保存在 VolumeDir/[name]/_data
+	VolumeDir string = path.Join(RootDir, "volumes")
+	// EventsFile 事件日志, 每行一个 json 格式的 Event
+	EventsFile string = path.Join(RootDir, "events.json")
 )
 
 // 文件相关信息
 var (
 	ConfigName        string = "config.json"
+	ConfigLockName    string = "config.lock"
 	ContainerLogFile  string = "container.log"
+	MonitorLogFile    string = "monitor.log"
 	ImageInfoFile     string = "repositories.json"
 	ContainerNameFile string = "containernames.json"
 	IPamConfigFile    string = "subnet.json"
 	IPamLockFile      string = "_ipam.lock"
+	VolumeInfoFile    string = "volume.json"
 )
 
 // 默认参数
@@ -43,29 +51,85 @@ var (
 	DefaultNetworkDriver string = "bridge"
 	DefaultNetworkSubnet string = "172.20.0.0/24"
 	DefaultNetworkID     string = "qsrdocker0"
+	DefaultVolumeDriver  string = "local"
 	// 默认引擎为 overlay2
 	Driver string = "overlay2"
-	// 默认 bind mount 方式
-	MountType    string = "bind"
-	DefaultHosts string = "127.0.0.1 localhost\n::1 localhost ip6-localhost ip6-loopback\nfe00::0 ip6-localnet\nff00::0 ip6-mcastprefix\nff02::1 ip6-allnodes\nff02::2 ip6-allrouters\n"
+	// 默认 bind mount 方式, --tmpfs 为 tmpfs, -v name:/path 与 --mount type=volume 为命名数据卷
+	MountType       string = "bind"
+	TmpfsMountType  string = "tmpfs"
+	VolumeMountType string = "volume"
+	DefaultHosts    string = "127.0.0.1 localhost\n::1 localhost ip6-localhost ip6-loopback\nfe00::0 ip6-localnet\nff00::0 ip6-mcastprefix\nff02::1 ip6-allnodes\nff02::2 ip6-allrouters\n"
 )
 
 // ContainerInfo 容器基本信息描述
 type ContainerInfo struct {
-	ID          string                 `json:"ID"`            //容器Id
-	Name        string                 `json:"Name"`          //容器名
-	CreatedTime string                 `json:"CreateTime"`    //创建时间
-	Status      *StatusInfo            `json:"Status"`        //容器的状态
-	Driver      string                 `json:"Driver"`        // 容器存储引擎
-	GraphDriver *DriverInfo            `json:"GraphDriver"`   // 镜像挂载信息
-	Mount       []*MountInfo           `json:"Mount"`         // 数据卷数据
-	Cgroup      *cgroups.CgroupManager `json:"Cgroup"`        // Cgroup 信息
-	TTy         bool                   `json:"Tty"`           // 是否开启对接终端
-	Image       string                 `json:"Image"`         // Image 镜像信息
-	Path        string                 `json:"Path"`          // cmd 运行absPath
-	Args        []string               `json:"Args"`          // cmdlsit
-	Env         []string               `json:"Env"`           // 运行的环境变量
-	NetWorks    *Endpoint              `json:"NetWorkConfig"` // 网络配置
+	ID           string                 `json:"ID"`            //容器Id
+	Name         string                 `json:"Name"`          //容器名
+	CreatedTime  string                 `json:"CreateTime"`    //创建时间
+	Status       *StatusInfo            `json:"Status"`        //容器的状态
+	Driver       string                 `json:"Driver"`        // 容器存储引擎
+	GraphDriver  *DriverInfo            `json:"GraphDriver"`   // 镜像挂载信息
+	Mount        []*MountInfo           `json:"Mount"`         // 数据卷数据
+	Cgroup       *cgroups.CgroupManager `json:"Cgroup"`        // Cgroup 信息
+	TTy          bool                   `json:"Tty"`           // 是否开启对接终端
+	Image        string                 `json:"Image"`         // Image 镜像信息
+	Path         string                 `json:"Path"`          // cmd 运行absPath
+	Args         []string               `json:"Args"`          // cmdlsit
+	Env          []string               `json:"Env"`           // 运行的环境变量
+	NetWorks     *Endpoint              `json:"NetWorkConfig"` // 网络配置
+	Restart      *RestartPolicy         `json:"RestartPolicy"` // 重启策略
+	RestartCount int                    `json:"RestartCount"`  // monitor 自动重启的次数, qsrdocker start 时清零
+	StopSignal   string                 `json:"StopSignal"`    // qsrdocker stop 发送的信号
+	HealthCheck  *HealthConfig          `json:"HealthCheck"`   // 健康检查配置
+	Init         bool                   `json:"Init"`          // 容器内由 qsrdocker init 作为 PID 1
+	WorkingDir   string                 `json:"WorkingDir"`    // 容器内的工作目录, 为空时为 /
+	User         string                 `json:"User"`          // 容器内运行用户 user[:group], 为空时为 root
+	Hostname     string                 `json:"Hostname"`      // 容器的主机名, 为空时为容器 ID
+	UsernsMode   string                 `json:"UsernsMode"`    // --userns 用户命名空间模式
+	UidMappings  []IDMap                `json:"UidMappings"`   // 创建时确定的 uid 映射, 重启时保持不变
+	GidMappings  []IDMap                `json:"GidMappings"`   // 创建时确定的 gid 映射
+	Privileged   bool                   `json:"Privileged"`    // --privileged 保留全部 capability
+	CapAdd       []string               `json:"CapAdd"`        // --cap-add 添加的 capability
+	CapDrop      []string               `json:"CapDrop"`       // --cap-drop 删除的 capability
+	Capabilities []string               `json:"Capabilities"`  // 容器进程最终的 capability
+	NoNewPrivs   bool                   `json:"NoNewPrivs"`    // 设置 PR_SET_NO_NEW_PRIVS
+	Seccomp      string                 `json:"Seccomp"`       // seccomp profile, default unconfined 或 profile 文件路径
+	ShmSize      string                 `json:"ShmSize"`       // /dev/shm 大小, 为空时为 64m
+	Devices      []*Device              `json:"Devices"`       // --device 宿主机设备
+	ReadOnly     bool                   `json:"ReadOnly"`      // --read-only 根文件系统只读
+}
+
+// RunConfig qsrdocker run 的运行参数，同时作为 qsrdockerd API 的请求体
+type RunConfig struct {
+	Tty              bool                       `json:"Tty"`              // 是否开启对接终端
+	Detach           bool                       `json:"Detach"`           // -d 后台运行, 与 -it 同时使用时由 monitor 持有 pty
+	Cmd              []string                   `json:"Cmd"`              // 用户命令
+	Volumes          []string                   `json:"Volumes"`          // -v 数据卷
+	Env              []string                   `json:"Env"`              // -e 环境变量
+	PortMapping      []string                   `json:"PortMapping"`      // -p 端口映射
+	Resource         *subsystems.ResourceConfig `json:"Resource"`         // cgroup 资源限制
+	Image            string                     `json:"Image"`            // 镜像名
+	Name             string                     `json:"Name"`             // 容器名
+	NetworkID        string                     `json:"NetworkID"`        // 网络ID
+	NetworkDriver    string                     `json:"NetworkDriver"`    // 网络驱动
+	ContainerNetwork string                     `json:"ContainerNetwork"` // container 网络模式的目标容器
+	Restart          *RestartPolicy             `json:"RestartPolicy"`    // --restart 重启策略
+	StopSignal       string                     `json:"StopSignal"`       // --signal 停止信号
+	HealthCheck      *HealthConfig              `json:"HealthCheck"`      // --health-cmd 健康检查
+	Init             bool                       `json:"Init"`             // --init 运行 init 进程
+	WorkingDir       string                     `json:"WorkingDir"`       // -w 工作目录
+	User             string                     `json:"User"`             // -u 运行用户
+	Hostname         string                     `json:"Hostname"`         // -h 主机名
+	UsernsMode       string                     `json:"UsernsMode"`       // --userns 用户命名空间模式
+	Privileged       bool                       `json:"Privileged"`       // --privileged 保留全部 capability
+	CapAdd           []string                   `json:"CapAdd"`           // --cap-add 添加 capability
+	CapDrop          []string                   `json:"CapDrop"`          // --cap-drop 删除 capability
+	SeccompProfile   string                     `json:"SeccompProfile"`   // --security-opt seccomp, unconfined 或 profile 文件内容
+	ShmSize          string                     `json:"ShmSize"`          // --shm-size /dev/shm 大小
+	Devices          []string                   `json:"Devices"`          // --device 宿主机设备 host[:container][:rwm]
+	ReadOnly         bool                       `json:"ReadOnly"`         // --read-only 根文件系统只读
+	Tmpfs            []string                   `json:"Tmpfs"`            // --tmpfs 挂载 tmpfs /path[:options]
+	Mounts           []string                   `json:"Mounts"`           // --mount type=bind|volume|tmpfs,source=..,target=..
 }
 
 // DriverInfo 镜像挂载信息
@@ -76,21 +140,36 @@ type DriverInfo struct {
 
 // StatusInfo 容器状态信息
 type StatusInfo struct {
-	Pid       int    `json:"Pid"` //容器的init进程在宿主机上的 PID
-	Status    string `json:"Status"`
-	Running   bool   `json:"Running"` // qsrdocker run/start
-	Paused    bool   `json:"Paused"`  // qsrdocker stop
-	OOMKilled bool   `json:"OOMKilled"`
-	Dead      bool   `json:"Dead"` // 异常退出，不是由 stop 退出
-	StartTime string `json:"StartTime"`
+	Pid           int     `json:"Pid"` //容器的init进程在宿主机上的 PID
+	Status        string  `json:"Status"`
+	Created       bool    `json:"Created"`       // qsrdocker create, 尚未启动
+	Running       bool    `json:"Running"`       // qsrdocker run/start
+	Paused        bool    `json:"Paused"`        // qsrdocker pause, 容器进程被 freezer 冻结
+	Stopped       bool    `json:"Stopped"`       // qsrdocker stop
+	StopRequested bool    `json:"StopRequested"` // qsrdocker stop 已发送停止信号, monitor 回收容器进程后设置为 Stopped
+	Restarting    bool    `json:"Restarting"`    // 等待 monitor 按重启策略重新启动
+	OOMKilled     bool    `json:"OOMKilled"`
+	Dead          bool    `json:"Dead"` // 异常退出，不是由 stop 退出
+	StartTime     string  `json:"StartTime"`
+	MonitorPid    int     `json:"MonitorPid"` // monitor 进程在宿主机上的 PID
+	ExitCode      int     `json:"ExitCode"`   // 容器 init 进程退出码, 被信号杀死时为 128 + signal
+	Signal        string  `json:"Signal"`     // 杀死容器 init 进程的信号
+	FinishedAt    string  `json:"FinishedAt"` // 容器退出时间
+	Health        *Health `json:"Health"`     // 健康检查状态, 未设置健康检查时为 nil
+	OOMTime       string  `json:"OOMTime"`    // 最近一次 OOM 的时间
+	OOMStalled    bool    `json:"OOMStalled"` // oom_kill_disable 时容器处于 OOM 被挂起, 由 inspect 实时获取
 }
 
 // MountInfo 数据卷挂载信息
 type MountInfo struct {
-	Type        string // 默认为"bind"
+	Type        string // 默认为"bind", --tmpfs 为 "tmpfs", 命名数据卷为 "volume"
+	Name        string // 命名数据卷的名称, 匿名数据卷为随机名称
 	Source      string
 	Destination string
-	RW          bool // true
+	Mode        string // -v 的选项, 如 ro,rshared
+	RW          bool   // ro 时为 false
+	Propagation string // 挂载传播类型, 默认为 rprivate
+	Options     string // tmpfs 挂载参数, 如 size=64m,mode=1777
 }
 
 // ImageMateDataInfo  // 容器转化为镜像时 Path Args Env 等数据
@@ -98,6 +177,14 @@ type ImageMateDataInfo struct {
 	Path string   `json:"Path"` // cmd 运行absPath
 	Args []string `json:"Args"` // cmdlsit
 	Env  []string `json:"Env"`  // 运行的环境变量
+	// StopSignal 镜像的 STOPSIGNAL, qsrdocker run 未设置 --signal 时使用
+	StopSignal string `json:"StopSignal"`
+	// HealthCheck 镜像的 HEALTHCHECK, qsrdocker run 未设置 --health-cmd 时使用
+	HealthCheck *HealthConfig `json:"HealthCheck"`
+	// WorkingDir 镜像的 WORKDIR, qsrdocker run 未设置 -w 时使用
+	WorkingDir string `json:"WorkingDir"`
+	// User 镜像的 USER, qsrdocker run 未设置 -u 时使用
+	User string `json:"User"`
 }
 
 // Network 网络信息，包含了相关的 IP 信息，网络 Driver 信息，如 Host None Container Bridge
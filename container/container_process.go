@@ -16,7 +16,10 @@ import (
 )
 
 // NewParentProcess 创建 container 的启动进程
-func NewParentProcess(tty bool, containerName, containerID, imageName, networkDriver string, envSlice []string) (*exec.Cmd, *os.File, *DriverInfo) {
+// create 持久化的 containerInfo 为唯一的启动依据, run / start / monitor 重启共用
+// console 为 monitor 持有的 pty slave, 不为 nil 时作为容器的标准输入输出和控制终端
+// 返回的两个管道分别用于向 init 进程发送启动配置, 以及接收 init 进程的启动结果
+func NewParentProcess(containerInfo *ContainerInfo, console *os.File) (*exec.Cmd, *os.File, *os.File, error) {
 
 	/*
 		1. 第一个参数为初始化 init RunContainerInitProcess
@@ -27,84 +30,65 @@ func NewParentProcess(tty bool, containerName, containerID, imageName, networkDr
 			容器内部调用
 	*/
 
-	// 打印 command
-	//log.Debugf("Create Parent Process cmd: %v", command)
-
-	readCmdPipe, writeCmdPipe, err := NewPipe()
-
-	if err != nil {
-		log.Errorf("Create New Cmd pipe err: %v", err)
-		return nil, nil, nil
-	}
+	containerID := containerInfo.ID
 
 	// exec 方式直接运行 qsrdocker init
 	cmd := exec.Command("/proc/self/exe", "init") // 执行 initCmd
-	uid := syscall.Getuid()                       // 字符串转int
-	gid := syscall.Getgid()
 
-	log.Debugf("Get qsrdocker : %v uid : %v ; gid : %v", containerID, uid, gid)
+	uidMap, gidMap := containerInfo.GetIDMappings()
+	log.Debugf("Get qsrdocker : %v uid map : %v ; gid map : %v", containerID, uidMap, gidMap)
 
-	if err = InitUserNamespace(); err != nil {
-		log.Fatalf("UserNamespace err : %v", err)
+	// 设置namespace
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUTS |
+			syscall.CLONE_NEWIPC | // IPC 调用参数
+			syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWNS, // 史上第一个 Namespace
 	}
 
-	// 设置进程参数
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		UidMappings: []syscall.SysProcIDMap{
-			{
-				ContainerID: 0, // 映射为root
-				HostID:      uid,
-				Size:        1,
-			},
-		},
-		GidMappings: []syscall.SysProcIDMap{
-			{
-				ContainerID: 0, // 映射为root
-				HostID:      gid,
-				Size:        1,
-			},
-		},
-		GidMappingsEnableSetgroups: false,
+	// host 不需要隔离 netNS
+	if containerInfo.NetWorks.Network.Driver != "host" {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
 	}
 
-	// 设置namespace
-	if networkDriver == "host" {
-		// host 不需要隔离 netNS
-		cmd.SysProcAttr.Cloneflags = (syscall.CLONE_NEWUTS |
-			syscall.CLONE_NEWIPC | // IPC 调用参数
-			syscall.CLONE_NEWPID |
-			syscall.CLONE_NEWNS | // 史上第一个 Namespace
-			syscall.CLONE_NEWUSER)
-	} else {
-		cmd.SysProcAttr.Cloneflags = (syscall.CLONE_NEWUTS |
-			syscall.CLONE_NEWIPC | // IPC 调用参数
-			syscall.CLONE_NEWPID |
-			syscall.CLONE_NEWNS | // 史上第一个 Namespace
-			syscall.CLONE_NEWUSER |
-			syscall.CLONE_NEWNET)
+	// --userns=host 不隔离 userNS
+	if containerInfo.UsernsMode != UsernsModeHost {
+		if err := InitUserNamespace(); err != nil {
+			return nil, nil, nil, fmt.Errorf("UserNamespace err : %v", err)
+		}
+
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+
+		// 普通用户由 launchContainer 通过 newuidmap newgidmap 设置映射
+		if !containerInfo.UseIDMapHelper() {
+			cmd.SysProcAttr.UidMappings = toSysProcIDMap(uidMap)
+			cmd.SysProcAttr.GidMappings = toSysProcIDMap(gidMap)
+			// 普通用户写入 gid_map 前需要禁用 setgroups
+			cmd.SysProcAttr.GidMappingsEnableSetgroups = os.Geteuid() == 0
+		}
 	}
 
 	log.Debugf("Set NameSpace to qsrdocker : %v", containerID)
 
-	if tty {
+	if console != nil {
+		// 后台运行的 tty 容器, 容器进程成为新会话的首进程并以 pty 作为控制终端
+		cmd.Stdin = console
+		cmd.Stdout = console
+		cmd.Stderr = console
+		cmd.SysProcAttr.Setsid = true
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 0
+	} else if containerInfo.TTy {
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 	} else {
 
-		// 创建 /[containerDir]/[containerID]/ 目录
-		containerDir := path.Join(ContainerDir, containerID)
-
-		if err := os.MkdirAll(containerDir, 0622); err != nil {
-			log.Errorf("Mkdir container Dir %s fail error %v", containerDir, err)
-			return nil, nil, nil
-		}
-		// 创建 log 文件
-		containerLogFile := path.Join(containerDir, ContainerLogFile)
-		logFileFd, err := os.Create(containerLogFile)
+		// 以追加方式打开 log 文件, 重启后保留之前的日志
+		containerLogFile := path.Join(ContainerDir, containerID, ContainerLogFile)
+		logFileFd, err := os.OpenFile(containerLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 		if err != nil {
-			log.Errorf("NewParentProcess create log file %s error %v", containerLogFile, err)
-			return nil, nil, nil
+			return nil, nil, nil, fmt.Errorf("Get log file %s error %v", containerLogFile, err)
 		}
 
 		// 将标准输出 错误 重定向到 log 文件中
@@ -112,34 +96,54 @@ func NewParentProcess(tty bool, containerName, containerID, imageName, networkDr
 		cmd.Stderr = logFileFd
 	}
 
-	// 传入管道问价读端fld
-	cmd.ExtraFiles = []*os.File{readCmdPipe}
+	readConfigPipe, writeConfigPipe, err := NewPipe()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Create New Cmd pipe err: %v", err)
+	}
+
+	readStatusPipe, writeStatusPipe, err := NewPipe()
+	if err != nil {
+		readConfigPipe.Close()
+		writeConfigPipe.Close()
+		return nil, nil, nil, fmt.Errorf("Create New status pipe err: %v", err)
+	}
+
+	// 传入启动配置管道的读端, 启动结果管道的写端
+	cmd.ExtraFiles = []*os.File{readConfigPipe, writeStatusPipe}
 	// 一个进程的文件描d述符默认 0 1 2 代表 输入 输出 错误
-	// readCmdPipe 为外带的第四个文件描述符 下标为 3
+	// readConfigPipe 为外带的第四个文件描述符 下标为 3, writeStatusPipe 下标为 4
 
 	// 设置进程环境变量
-	cmd.Env = append([]string{}, envSlice...)
+	// 用户进程的环境变量由启动配置传入, --init 时 qsrdocker exec 通过 /proc/[pid]/environ 获取
+	cmd.Env = append([]string{}, containerInfo.Env...)
 	log.Debugf("Set container Env : %v", cmd.Env)
 
-	// 创建容器运行目录
-	// 创建容器映射数据卷
-	driverInfo, err := NewWorkSpace(imageName, containerID)
+	// 设置进程运行目录
+	cmd.Dir = GetMountPathFuncMap[containerInfo.GraphDriver.Driver](containerInfo.GraphDriver.Data)
+
+	log.Debugf("Set qsrdocker : %v run dir : %v", containerID, cmd.Dir)
 
-	if err != nil {
-		// 若存在问题则删除挂载点目录
-		mountPath := path.Join(MountDir, containerID)
-		os.RemoveAll(mountPath)
-		log.Warnf("Remove mount dir : %v", mountPath)
-		log.Errorf("Can't create docker workspace error : %v", err)
-		return nil, nil, nil
-	}
+	return cmd, writeConfigPipe, readStatusPipe, nil
+}
 
-	// 设置进程运行目录
-	cmd.Dir = GetMountPathFuncMap[driverInfo.Driver](driverInfo.Data)
+// CreateContainerLogFile 创建 /[containerDir]/[containerID]/container.log
+func CreateContainerLogFile(containerID string) (*os.File, error) {
 
-	log.Debugf("Set qsrdocker : %v run dir : %v", containerID, GetMountPathFuncMap[driverInfo.Driver](driverInfo.Data))
+	// 创建 /[containerDir]/[containerID]/ 目录
+	containerDir := path.Join(ContainerDir, containerID)
 
-	return cmd, writeCmdPipe, driverInfo // 返回给 Run 写端fd，用于接收用户参数
+	if err := os.MkdirAll(containerDir, 0622); err != nil {
+		return nil, fmt.Errorf("Mkdir container Dir %s fail error %v", containerDir, err)
+	}
+
+	// 创建 log 文件
+	containerLogFile := path.Join(containerDir, ContainerLogFile)
+	logFileFd, err := os.Create(containerLogFile)
+	if err != nil {
+		return nil, fmt.Errorf("Create log file %s error %v", containerLogFile, err)
+	}
+
+	return logFileFd, nil
 }
 
 // NewPipe 创建匿名管道实现 container 进程和 qsrdocker 进程通信
@@ -185,12 +189,32 @@ func InitUserNamespace() error {
 // StatusCheck 检测当前 container 状态
 func (s *StatusInfo) StatusCheck() {
 	if exist, err := PathExists(path.Join("/proc", strconv.Itoa(s.Pid))); !exist || err != nil {
-		// 不是 qsrdocker stop ，则设置 dead
-		if !s.Paused {
+		// monitor 正在等待重启容器
+		if s.Restarting && s.MonitorPid > 0 {
+			if exist, _ := PathExists(path.Join("/proc", strconv.Itoa(s.MonitorPid))); exist {
+				return
+			}
+		}
+
+		// 尚未启动
+		if s.Created {
+			return
+		}
+
+		// 旧版本 qsrdocker stop 记录为 Paused
+		// monitor 不存在时, 由 qsrdocker stop 停止的进程设置为 Stopped
+		if s.Paused || s.StopRequested {
+			s.StatusSet("Stopped")
+			s.StopRequested = false
+		}
+
+		// 不是 qsrdocker stop 也不是 OOM ，则设置 dead
+		if !s.Stopped && !s.OOMKilled {
 			s.StatusSet("Dead")
 		}
 
-	} else {
+	} else if !s.Paused && !s.Stopped {
+		// 被冻结的进程依然存在，保持 Paused
 		s.StatusSet("Running")
 	}
 }
@@ -201,27 +225,39 @@ func (s *StatusInfo) StatusSet(status string) {
 	// 设置所有的状态为 false
 	// true 状态唯一
 	switch s.Status {
+	case "Created":
+		s.Created = false
 	case "Running":
 		s.Running = false
 	case "Paused":
 		s.Paused = false
+	case "Stopped":
+		s.Stopped = false
 	case "OOMKilled":
 		s.OOMKilled = false
 	case "Dead":
 		s.Dead = false
+	case "Restarting":
+		s.Restarting = false
 	}
 
 	s.Status = status
 
 	switch {
+	case status == "Created":
+		s.Created = true
 	case status == "Running":
 		s.Running = true
 	case status == "Paused":
 		s.Paused = true
+	case status == "Stopped":
+		s.Stopped = true
 	case status == "OOMKilled":
 		s.OOMKilled = true
 	case status == "Dead":
 		s.Dead = true
+	case status == "Restarting":
+		s.Restarting = true
 	}
 
 }
@@ -229,36 +265,127 @@ func (s *StatusInfo) StatusSet(status string) {
 // GetContainerInfo 获取 container info
 func GetContainerInfo(file os.FileInfo) (*ContainerInfo, error) {
 	containerID := file.Name()
-	configFilePtah := path.Join(ContainerDir, containerID, ConfigName)
 
-	// 读取目标文件
-	content, err := ioutil.ReadFile(configFilePtah)
+	unlock, err := lockContainerInfo(containerID)
+	if err != nil {
+		log.Errorf("Lock container %v info error %v", containerID, err)
+		return nil, err
+	}
+	defer unlock()
+
+	// 读取目标文件并检测容器当前状态
+	containerInfo, err := readContainerInfo(containerID)
+	if err != nil {
+		log.Errorf("Read container %v info error %v", containerID, err)
+		return nil, err
+	}
+
+	// 持久化当前状态
+	recordContainerInfo(containerInfo, containerID)
+
+	// 返回结构体指针
+	return containerInfo, nil
+}
+
+// RecordContainerInfo 持久化存储 containerInfo 数据
+func RecordContainerInfo(containerInfo *ContainerInfo, containerID string) error {
+
+	// 创建 /[containerDir]/[containerID]/ 目录
+	containerDir := path.Join(ContainerDir, containerID)
+
+	if exists, _ := PathExists(containerDir); !exists {
+		if err := os.MkdirAll(containerDir, 0622); err != nil {
+			log.Errorf("Mkdir container Dir %s fail error %v", containerDir, err)
+			return err
+		}
+	}
+
+	unlock, err := lockContainerInfo(containerID)
+	if err != nil {
+		log.Errorf("Lock container %v info error %v", containerID, err)
+		return err
+	}
+	defer unlock()
+
+	return recordContainerInfo(containerInfo, containerID)
+}
+
+// UpdateContainerInfo 在文件锁内重新读取 config.json, 由 update 修改后写回
+// update 返回 false 或错误时不写回, 用于只修改部分字段, 避免覆盖其他进程写入的状态
+// update 在文件锁内执行, 不能再读写 config.json
+func UpdateContainerInfo(containerID string, update func(containerInfo *ContainerInfo) (bool, error)) error {
+
+	unlock, err := lockContainerInfo(containerID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	containerInfo, err := readContainerInfo(containerID)
+	if err != nil {
+		return err
+	}
+
+	changed, err := update(containerInfo)
+	if err != nil || !changed {
+		return err
+	}
+
+	return recordContainerInfo(containerInfo, containerID)
+}
+
+// lockContainerInfo 对 /[containerDir]/[containerID]/config.lock 加排他锁
+// config.json 通过 rename 替换, 不能直接对其加锁
+// flock 在同一进程内不可重入, 持有锁时只能调用 readContainerInfo / recordContainerInfo
+func lockContainerInfo(containerID string) (func(), error) {
+
+	lockFile := path.Join(ContainerDir, containerID, ConfigLockName)
+
+	lock, err := os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("Flock %v error %v", lockFile, err)
+	}
+
+	return func() {
+		syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+		lock.Close()
+	}, nil
+}
+
+// readContainerInfo 读取 config.json 并检测当前状态, 调用方需持有 lockContainerInfo
+func readContainerInfo(containerID string) (*ContainerInfo, error) {
+
+	// 配置目录
+	containerConfigFile := path.Join(ContainerDir, containerID, ConfigName)
+
+	// 获取容器配置信息
+	configBytes, err := ioutil.ReadFile(containerConfigFile)
 	if err != nil {
-		log.Errorf("Read file %s error %v", configFilePtah, err)
 		return nil, err
 	}
 
 	// 反序列化
 	var containerInfo ContainerInfo
-	if err := json.Unmarshal(content, &containerInfo); err != nil {
-		log.Errorf("Json unmarshal error %v", err)
+	if err := json.Unmarshal(configBytes, &containerInfo); err != nil {
 		return nil, err
 	}
 
 	// 检测网络结构体信息
 	checkNetwork(&containerInfo)
 
-	// 检测容器当前状态并持久化
+	// 检测当前状态
 	containerInfo.Status.StatusCheck()
 
-	RecordContainerInfo(&containerInfo, containerID)
-
-	// 返回结构体指针
 	return &containerInfo, nil
 }
 
-// RecordContainerInfo 持久化存储 containerInfo 数据
-func RecordContainerInfo(containerInfo *ContainerInfo, containerID string) error {
+// recordContainerInfo 写入 config.json, 调用方需持有 lockContainerInfo
+func recordContainerInfo(containerInfo *ContainerInfo, containerID string) error {
 
 	// 检测网络结构体信息
 	checkNetwork(containerInfo)
@@ -271,41 +398,33 @@ func RecordContainerInfo(containerInfo *ContainerInfo, containerID string) error
 	}
 	containerInfoStr := strings.Join([]string{string(containerInfoBytes), "\n"}, "")
 
-	// 创建 /[containerDir]/[containerID]/ 目录
-	containerDir := path.Join(ContainerDir, containerID)
-
-	if exists, _ := PathExists(containerDir); !exists {
-		if err := os.MkdirAll(containerDir, 0622); err != nil {
-			log.Errorf("Mkdir container Dir %s fail error %v", containerDir, err)
-			return err
-		}
-	}
-
 	// 创建 /[containerDir]/[containerID]/config.json
-	containerInfoFile := path.Join(containerDir, ConfigName)
-	InfoFileFd, err := os.Create(containerInfoFile)
-	defer InfoFileFd.Close()
-	if err != nil {
-		log.Errorf("Create container Info File %s error %v", containerInfoFile, err)
+	// monitor 进程与 qsrdocker 会同时读写 config.json
+	// 先写入临时文件再 rename，避免读取到写了一半的文件
+	containerInfoFile := path.Join(ContainerDir, containerID, ConfigName)
+	tmpInfoFile := strings.Join([]string{containerInfoFile, ".tmp"}, "")
+
+	if err := ioutil.WriteFile(tmpInfoFile, []byte(containerInfoStr), 0644); err != nil {
+		log.Errorf("Write container Info File %s error %v", tmpInfoFile, err)
 		return err
 	}
 
-	// 写入 containerInfo
-	if _, err := InfoFileFd.WriteString(containerInfoStr); err != nil {
-		log.Errorf("Write container Info error %v", err)
+	if err := os.Rename(tmpInfoFile, containerInfoFile); err != nil {
+		log.Errorf("Rename container Info File %s error %v", containerInfoFile, err)
 		return err
 	}
 
 	return nil
 }
 
-// GetContainerIDByName 通过 Name 获取 ID
-func GetContainerIDByName(containerName string) (string, error) {
+// GetContainerNameConfig 获取 containernames.json 中 name : id 的映射
+// 映射文件不存在时返回 nil
+func GetContainerNameConfig() (map[string]string, error) {
 	// 判断 container 目录是否存在
 	if exist, _ := PathExists(ContainerDir); !exist {
 		err := os.MkdirAll(ContainerDir, 0622)
 		if err != nil {
-			return "", fmt.Errorf("Mkdir container dir fail err : %v", err)
+			return nil, fmt.Errorf("Mkdir container dir fail err : %v", err)
 		}
 	}
 	// 创建反序列化载体  {"name":"id"}
@@ -318,20 +437,31 @@ func GetContainerIDByName(containerName string) (string, error) {
 	if exist, _ := PathExists(containerNamePath); !exist {
 
 		// 文件不存在直接返回
-		return "", nil
+		return nil, nil
 	}
 
 	// 映射文件存在
 	//ReadFile函数会读取文件的全部内容，并将结果以[]byte类型返回
 	data, err := ioutil.ReadFile(containerNamePath)
 	if err != nil {
-		return "", fmt.Errorf("Can't open containerNameConfig : %v", containerNamePath)
+		return nil, fmt.Errorf("Can't open containerNameConfig : %v", containerNamePath)
 	}
 
 	//读取的数据为json格式，需要进行解码
 	err = json.Unmarshal(data, &containerNameConfig)
 	if err != nil {
-		return "", fmt.Errorf("Can't Unmarshal : %v", containerNamePath)
+		return nil, fmt.Errorf("Can't Unmarshal : %v", containerNamePath)
+	}
+
+	return containerNameConfig, nil
+}
+
+// GetContainerIDByName 通过容器名 / 容器ID / 唯一的容器ID前缀 获取容器ID
+func GetContainerIDByName(containerName string) (string, error) {
+
+	containerNameConfig, err := GetContainerNameConfig()
+	if err != nil || containerNameConfig == nil {
+		return "", err
 	}
 
 	// 获取到容器ID
@@ -339,6 +469,24 @@ func GetContainerIDByName(containerName string) (string, error) {
 		return ID, nil
 	}
 
+	// 容器ID前缀，必须唯一
+	matchID := ""
+	if strings.Replace(containerName, " ", "", -1) != "" {
+		for _, ID := range containerNameConfig {
+			if !strings.HasPrefix(ID, containerName) || ID == matchID {
+				continue
+			}
+			if matchID != "" {
+				return "", fmt.Errorf("Multiple containers found with ID prefix %v", containerName)
+			}
+			matchID = ID
+		}
+	}
+
+	if matchID != "" {
+		return matchID, nil
+	}
+
 	// 未获取到容器ID
 	return "", fmt.Errorf("Container Name:ID %v not in config file", containerName)
 }
@@ -352,31 +500,21 @@ func GetContainerInfoByNameID(containerName string) (*ContainerInfo, error) {
 		return nil, fmt.Errorf("Get containerID fail : %v", err)
 	}
 
-	// 配置目录
-	containerConfigFile := path.Join(ContainerDir, containerID, ConfigName)
-
-	// 获取容器配置信息
-	configBytes, err := ioutil.ReadFile(containerConfigFile)
+	unlock, err := lockContainerInfo(containerID)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
 
-	// 反序列化
-	var containerInfo ContainerInfo
-	if err := json.Unmarshal(configBytes, &containerInfo); err != nil {
+	containerInfo, err := readContainerInfo(containerID)
+	if err != nil {
 		return nil, err
 	}
 
-	// 检测网络结构体信息
-	checkNetwork(&containerInfo)
-
-	// 检测当前状态
-	containerInfo.Status.StatusCheck()
-
 	// 持久化当前状态
-	RecordContainerInfo(&containerInfo, containerID)
+	recordContainerInfo(containerInfo, containerID)
 
-	return &containerInfo, nil
+	return containerInfo, nil
 }
 
 // GetImageMateDataInfoByName 通过镜像Name获取镜像runtime info
@@ -407,7 +545,7 @@ func GetImageMateDataInfoByName(imageName string) (*ImageMateDataInfo, error) {
 }
 
 // InitContainerHostConfig 初始化 hosts hostname resolv.conf 文件
-func InitContainerHostConfig(containerID string) {
+func InitContainerHostConfig(containerID, hostname string) {
 	// 创建 /[containerDir]/[containerID]/ 目录
 	containerDir := path.Join(ContainerDir, containerID)
 
@@ -429,7 +567,7 @@ func InitContainerHostConfig(containerID string) {
 	// 完成 hostname 文件
 	hostnameFilePath := path.Join(containerDir, "hostname")
 
-	if err := ioutil.WriteFile(hostnameFilePath, []byte(containerID), 0644); err != nil {
+	if err := ioutil.WriteFile(hostnameFilePath, []byte(hostname), 0644); err != nil {
 		log.Errorf("Create hostname err : %v", err)
 	} else {
 		log.Debugf("Create hostname success")
@@ -0,0 +1,260 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"qsrdocker/cgroups/subsystems"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultShmSize 默认 /dev/shm 大小
+const DefaultShmSize = "64m"
+
+// Device 容器 /dev 中的设备节点
+type Device struct {
+	Path        string      `json:"Path"`        // 容器内的路径
+	HostPath    string      `json:"HostPath"`    // --device 宿主机上的路径, 为空时与 Path 相同
+	Type        string      `json:"Type"`        // c 字符设备 b 块设备
+	Major       int64       `json:"Major"`       // 主设备号
+	Minor       int64       `json:"Minor"`       // 次设备号
+	Mode        os.FileMode `json:"Mode"`        // 权限
+	Permissions string      `json:"Permissions"` // --device 的 cgroup 访问权限 rwm
+}
+
+// devicePermissionsRegexp --device 的访问权限
+var devicePermissionsRegexp = regexp.MustCompile(`^[rwm]{1,3}$`)
+
+// DefaultDevices 容器默认的设备
+var DefaultDevices = []*Device{
+	{Path: "/dev/null", Type: "c", Major: 1, Minor: 3, Mode: 0666},
+	{Path: "/dev/zero", Type: "c", Major: 1, Minor: 5, Mode: 0666},
+	{Path: "/dev/full", Type: "c", Major: 1, Minor: 7, Mode: 0666},
+	{Path: "/dev/random", Type: "c", Major: 1, Minor: 8, Mode: 0666},
+	{Path: "/dev/urandom", Type: "c", Major: 1, Minor: 9, Mode: 0666},
+	{Path: "/dev/tty", Type: "c", Major: 5, Minor: 0, Mode: 0666},
+}
+
+// devSymlinks /dev 中的符号链接
+var devSymlinks = [][2]string{
+	{"/proc/self/fd", "/dev/fd"},
+	{"/proc/self/fd/0", "/dev/stdin"},
+	{"/proc/self/fd/1", "/dev/stdout"},
+	{"/proc/self/fd/2", "/dev/stderr"},
+	{"pts/ptmx", "/dev/ptmx"},
+}
+
+// ParseDevice 解析 --device host[:container][:rwm], 读取宿主机设备的类型与设备号
+// 未指定容器内路径时与宿主机相同, 未指定权限时为 rwm
+func ParseDevice(spec string) (*Device, error) {
+
+	device := &Device{Permissions: "rwm"}
+
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 1:
+		device.HostPath = parts[0]
+	case 2:
+		device.HostPath = parts[0]
+		if devicePermissionsRegexp.MatchString(parts[1]) {
+			device.Permissions = parts[1]
+		} else {
+			device.Path = parts[1]
+		}
+	case 3:
+		if !devicePermissionsRegexp.MatchString(parts[2]) {
+			return nil, fmt.Errorf("Invalid device permissions %v in %v, use a combination of r w m", parts[2], spec)
+		}
+		device.HostPath, device.Path, device.Permissions = parts[0], parts[1], parts[2]
+	default:
+		return nil, fmt.Errorf("Invalid device %v, use host[:container][:rwm]", spec)
+	}
+
+	if device.Path == "" {
+		device.Path = device.HostPath
+	}
+
+	if !filepath.IsAbs(device.HostPath) || !filepath.IsAbs(device.Path) {
+		return nil, fmt.Errorf("Invalid device %v, the paths need to be absolute", spec)
+	}
+	device.Path = filepath.Clean(device.Path)
+
+	stat := &syscall.Stat_t{}
+	if err := syscall.Stat(device.HostPath, stat); err != nil {
+		return nil, fmt.Errorf("Stat device %v error %v", device.HostPath, err)
+	}
+
+	switch stat.Mode & syscall.S_IFMT {
+	case syscall.S_IFCHR:
+		device.Type = "c"
+	case syscall.S_IFBLK:
+		device.Type = "b"
+	default:
+		return nil, fmt.Errorf("%v is not a device", device.HostPath)
+	}
+
+	rdev := uint64(stat.Rdev)
+	device.Major = int64(((rdev >> 8) & 0xfff) | ((rdev >> 32) &^ 0xfff))
+	device.Minor = int64((rdev & 0xff) | ((rdev >> 12) &^ 0xff))
+	device.Mode = os.FileMode(stat.Mode).Perm()
+
+	return device, nil
+}
+
+// ParseDevices 解析所有 --device
+func ParseDevices(specs []string) ([]*Device, error) {
+	var devices []*Device
+	for _, spec := range specs {
+		device, err := ParseDevice(spec)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// DeviceCgroupRules 生成容器 devices cgroup 的白名单
+// 默认设备加上 --device 的设备, privileged 时不限制
+func DeviceCgroupRules(devices []*Device, privileged bool) string {
+
+	if privileged {
+		return subsystems.DeviceAllowAll
+	}
+
+	rules := append([]string{}, subsystems.DefaultDeviceRules...)
+	for _, device := range devices {
+		rules = append(rules, fmt.Sprintf("%v %d:%d %v", device.Type, device.Major, device.Minor, device.Permissions))
+	}
+
+	return strings.Join(rules, ",")
+}
+
+// setupDev 在 rootfs/dev 的 tmpfs 中创建设备与文件系统, 在 pivotRoot 之前执行
+// 1. 创建默认设备与 --device 的设备, user namespace 中不能 mknod 时 bind mount 宿主机的设备
+// 2. 挂载独立的 devpts, /dev/ptmx 指向 /dev/pts/ptmx
+// 3. 挂载 --shm-size 大小的 /dev/shm 与 /dev/mqueue
+// 4. 创建 /dev/fd /dev/stdin /dev/stdout /dev/stderr 符号链接
+func setupDev(rootfs, shmSize string, devices []*Device) error {
+
+	// --device 可以替换默认设备
+	devicePaths := map[string]bool{}
+	for _, device := range devices {
+		devicePaths[device.Path] = true
+	}
+
+	for _, device := range DefaultDevices {
+		if devicePaths[device.Path] {
+			continue
+		}
+		if err := createDevice(rootfs, device); err != nil {
+			return err
+		}
+	}
+
+	for _, device := range devices {
+		if err := createDevice(rootfs, device); err != nil {
+			return err
+		}
+	}
+
+	// newinstance 与宿主机的 pty 隔离, 容器内 tty 组为 5
+	// user namespace 没有映射 gid 5 时不设置 gid
+	ptsDir := filepath.Join(rootfs, "/dev/pts")
+	if err := os.MkdirAll(ptsDir, 0755); err != nil {
+		return fmt.Errorf("Mkdir %v error %v", ptsDir, err)
+	}
+
+	ptsFlags := uintptr(syscall.MS_NOSUID | syscall.MS_NOEXEC)
+	if err := syscall.Mount("devpts", ptsDir, "devpts", ptsFlags, "newinstance,ptmxmode=0666,mode=0620,gid=5"); err != nil {
+		if err := syscall.Mount("devpts", ptsDir, "devpts", ptsFlags, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+			return fmt.Errorf("Mount devpts error %v", err)
+		}
+	}
+
+	if shmSize == "" {
+		shmSize = DefaultShmSize
+	}
+
+	shmDir := filepath.Join(rootfs, "/dev/shm")
+	if err := os.MkdirAll(shmDir, 0755); err != nil {
+		return fmt.Errorf("Mkdir %v error %v", shmDir, err)
+	}
+
+	defaultMountFlags := uintptr(syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV)
+	if err := syscall.Mount("shm", shmDir, "tmpfs", defaultMountFlags, "mode=1777,size="+shmSize); err != nil {
+		return fmt.Errorf("Mount /dev/shm error %v", err)
+	}
+
+	// 内核未开启 POSIX 消息队列时忽略
+	mqueueDir := filepath.Join(rootfs, "/dev/mqueue")
+	if err := os.MkdirAll(mqueueDir, 0755); err != nil {
+		return fmt.Errorf("Mkdir %v error %v", mqueueDir, err)
+	}
+
+	if err := syscall.Mount("mqueue", mqueueDir, "mqueue", defaultMountFlags, ""); err != nil {
+		log.Warnf("Mount /dev/mqueue fail : %v", err)
+	}
+
+	for _, link := range devSymlinks {
+		if err := os.Symlink(link[0], filepath.Join(rootfs, link[1])); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("Symlink %v to %v error %v", link[1], link[0], err)
+		}
+	}
+
+	log.Debugf("Setup /dev success")
+
+	return nil
+}
+
+// createDevice 在 rootfs 中创建设备节点
+// user namespace 中 mknod 返回 EPERM, 改为 bind mount 宿主机的设备
+func createDevice(rootfs string, device *Device) error {
+
+	dest := filepath.Join(rootfs, device.Path)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("Mkdir %v error %v", filepath.Dir(dest), err)
+	}
+
+	fileType := uint32(syscall.S_IFCHR)
+	if device.Type == "b" {
+		fileType = syscall.S_IFBLK
+	}
+
+	err := syscall.Mknod(dest, fileType|uint32(device.Mode.Perm()), mkdev(device.Major, device.Minor))
+	if err == nil {
+		// mknod 的权限受 umask 影响
+		return os.Chmod(dest, device.Mode.Perm())
+	}
+
+	if err != syscall.EPERM {
+		return fmt.Errorf("Mknod %v error %v", dest, err)
+	}
+
+	file, err := os.OpenFile(dest, os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Create %v error %v", dest, err)
+	}
+	file.Close()
+
+	source := device.HostPath
+	if source == "" {
+		source = device.Path
+	}
+
+	if err := syscall.Mount(source, dest, "bind", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("Bind mount %v error %v", source, err)
+	}
+
+	return nil
+}
+
+// mkdev 与 glibc makedev 相同
+func mkdev(major, minor int64) int {
+	return int(((major & 0xfffff000) << 32) | ((major & 0xfff) << 8) | ((minor & 0xffffff00) << 12) | (minor & 0xff))
+}
@@ -0,0 +1,67 @@
+package container
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"qsrdocker/cgroups/subsystems"
+)
+
+func TestParseDevice(t *testing.T) {
+	for _, c := range []struct {
+		spec string
+		want Device
+	}{
+		{"/dev/null", Device{Path: "/dev/null", HostPath: "/dev/null", Type: "c", Major: 1, Minor: 3, Mode: 0666, Permissions: "rwm"}},
+		{"/dev/null:r", Device{Path: "/dev/null", HostPath: "/dev/null", Type: "c", Major: 1, Minor: 3, Mode: 0666, Permissions: "r"}},
+		{"/dev/null:/dev/mynull", Device{Path: "/dev/mynull", HostPath: "/dev/null", Type: "c", Major: 1, Minor: 3, Mode: 0666, Permissions: "rwm"}},
+		{"/dev/zero:/dev/xvda/:rw", Device{Path: "/dev/xvda", HostPath: "/dev/zero", Type: "c", Major: 1, Minor: 5, Mode: 0666, Permissions: "rw"}},
+	} {
+		device, err := ParseDevice(c.spec)
+		if err != nil {
+			t.Fatalf("parse device %v error : %v", c.spec, err)
+		}
+		if !reflect.DeepEqual(*device, c.want) {
+			t.Fatalf("device %v => %+v, want %+v", c.spec, *device, c.want)
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"dev/null",
+		"/dev/null:mynull",
+		"/dev/null:/dev/mynull:rx",
+		"/dev/null:/dev/mynull:rwm:extra",
+		"/dev/nonexistent",
+		"/",
+	} {
+		if _, err := ParseDevice(spec); err == nil {
+			t.Fatalf("parse device %v should fail", spec)
+		}
+	}
+
+	if _, err := ParseDevices([]string{"/dev/null", "/dev/nonexistent"}); err == nil {
+		t.Fatalf("parse devices with invalid device should fail")
+	}
+}
+
+func TestDeviceCgroupRules(t *testing.T) {
+	devices, err := ParseDevices([]string{"/dev/null:/dev/mynull:r"})
+	if err != nil {
+		t.Fatalf("parse devices error : %v", err)
+	}
+
+	want := strings.Join(append(append([]string{}, subsystems.DefaultDeviceRules...), "c 1:3 r"), ",")
+	if rules := DeviceCgroupRules(devices, false); rules != want {
+		t.Fatalf("device cgroup rules %v, want %v", rules, want)
+	}
+	if rules := DeviceCgroupRules(devices, true); rules != subsystems.DeviceAllowAll {
+		t.Fatalf("privileged device cgroup rules %v, want %v", rules, subsystems.DeviceAllowAll)
+	}
+
+	// 生成的规则必须能被 devices subsystem 解析
+	if _, err := subsystems.ParseDeviceRules(want); err != nil {
+		t.Fatalf("parse device cgroup rules error : %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 事件类型
+const (
+	EventTypeContainer = "container"
+	EventTypeNetwork   = "network"
+	EventTypeImage     = "image"
+	EventTypeVolume    = "volume"
+)
+
+// Event 容器 网络 镜像 数据卷 的生命周期事件
+type Event struct {
+	Type       string            `json:"Type"`       // container network image volume
+	Action     string            `json:"Action"`     // create start die stop ...
+	ID         string            `json:"ID"`         // 容器ID / 网络ID / 镜像ID / 数据卷名称
+	Attributes map[string]string `json:"Attributes"` // name image exitCode 等
+	Time       int64             `json:"Time"`       // unix 秒
+	TimeNano   int64             `json:"TimeNano"`   // unix 纳秒
+}
+
+// RecordEvent 追加一条事件到 EventsFile
+// 事件记录失败不影响正常操作，只打印日志
+func RecordEvent(eventType, action, id string, attributes map[string]string) {
+
+	now := time.Now()
+
+	event := &Event{
+		Type:       eventType,
+		Action:     action,
+		ID:         id,
+		Attributes: attributes,
+		Time:       now.Unix(),
+		TimeNano:   now.UnixNano(),
+	}
+
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		log.Warnf("Marshal event error %v", err)
+		return
+	}
+
+	if exist, _ := PathExists(RootDir); !exist {
+		if err := os.MkdirAll(RootDir, 0622); err != nil {
+			log.Warnf("Mkdir %v error %v", RootDir, err)
+			return
+		}
+	}
+
+	// O_APPEND 单次 write 写入整行，多个进程同时写入不会交错
+	eventsFd, err := os.OpenFile(EventsFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Warnf("Open events file %v error %v", EventsFile, err)
+		return
+	}
+	defer eventsFd.Close()
+
+	if _, err := eventsFd.Write(append(eventBytes, '\n')); err != nil {
+		log.Warnf("Record event error %v", err)
+	}
+}
+
+// RecordContainerEvent 记录容器事件, 附带容器名和镜像名
+func RecordContainerEvent(action string, containerInfo *ContainerInfo, attributes map[string]string) {
+
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	attributes["name"] = containerInfo.Name
+	attributes["image"] = containerInfo.Image
+
+	RecordEvent(EventTypeContainer, action, containerInfo.ID, attributes)
+}
+
+// String 事件的终端输出格式
+// 2020-03-14T19:03:51.000000000+08:00 container start 3lsx66n203 (image=nginx:v1, name=heroyf)
+func (event *Event) String() string {
+
+	eventStr := strings.Join([]string{
+		time.Unix(0, event.TimeNano).Format(time.RFC3339Nano),
+		event.Type,
+		event.Action,
+		event.ID,
+	}, " ")
+
+	if len(event.Attributes) == 0 {
+		return eventStr
+	}
+
+	keys := make([]string, 0, len(event.Attributes))
+	for key := range event.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]string, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, fmt.Sprintf("%s=%s", key, event.Attributes[key]))
+	}
+
+	return fmt.Sprintf("%s (%s)", eventStr, strings.Join(attributes, ", "))
+}
+
+// EventFilter --filter key=value, 相同 key 的多个值为或，不同 key 之间为与
+type EventFilter map[string][]string
+
+// ParseEventFilter 解析 --filter type=container --filter name=xxx
+func ParseEventFilter(filters []string) (EventFilter, error) {
+
+	eventFilter := EventFilter{}
+
+	// --filter type=container,name=xxx 与多个 --filter 等价
+	var pairs []string
+	for _, filter := range filters {
+		pairs = append(pairs, strings.Split(filter, ",")...)
+	}
+
+	for _, filter := range pairs {
+		kv := strings.SplitN(filter, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("Invalid filter %v, use key=value", filter)
+		}
+
+		key := strings.ToLower(kv[0])
+		switch key {
+		case "type", "event", "container", "name", "image", "network", "volume":
+		default:
+			return nil, fmt.Errorf("Invalid filter key %v, use type|event|container|name|image|network|volume", kv[0])
+		}
+
+		eventFilter[key] = append(eventFilter[key], kv[1])
+	}
+
+	return eventFilter, nil
+}
+
+// Match 事件是否满足过滤条件
+func (eventFilter EventFilter) Match(event *Event) bool {
+
+	for key, values := range eventFilter {
+		matched := false
+
+		for _, value := range values {
+			switch key {
+			case "type":
+				matched = event.Type == value
+			case "event":
+				matched = event.Action == value
+			case "container":
+				// 容器ID前缀 或 容器名
+				matched = event.Type == EventTypeContainer &&
+					(strings.HasPrefix(event.ID, value) || event.Attributes["name"] == value)
+			case "name":
+				matched = event.Attributes["name"] == value
+			case "image":
+				matched = event.Attributes["image"] == value ||
+					(event.Type == EventTypeImage && event.ID == value)
+			case "network":
+				matched = (event.Type == EventTypeNetwork && event.ID == value) ||
+					event.Attributes["network"] == value
+			case "volume":
+				matched = event.Type == EventTypeVolume && event.ID == value
+			}
+
+			if matched {
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseEventTime 解析 --since --until
+// 支持 unix 时间戳, RFC3339, 2006-01-02 15:04:05, 以及相对时间 10m 1h30m
+func ParseEventTime(timeStr string, now time.Time) (time.Time, error) {
+
+	timeStr = strings.TrimSpace(timeStr)
+
+	if seconds, err := strconv.ParseInt(timeStr, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	if duration, err := time.ParseDuration(timeStr); err == nil {
+		return now.Add(-duration), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, timeStr); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, timeStr, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid time %v", timeStr)
+}
@@ -0,0 +1,108 @@
+package container
+
+import (
+	"strings"
+	"time"
+)
+
+// 健康状态
+const (
+	HealthNone      = "none"
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+
+	// HealthCheckDisable --no-healthcheck, 禁用镜像中的健康检查
+	HealthCheckDisable = "NONE"
+
+	// HealthLogMax 保留最近的检查结果数量
+	HealthLogMax = 5
+	// HealthOutputMax 每次检查保留的输出长度
+	HealthOutputMax = 4096
+)
+
+// 健康检查默认参数
+var (
+	DefaultHealthInterval = 30 * time.Second
+	DefaultHealthTimeout  = 30 * time.Second
+	DefaultHealthRetries  = 3
+)
+
+// HealthConfig 健康检查配置 --health-cmd --health-interval ...
+type HealthConfig struct {
+	Test        string        `json:"Test"`        // 在容器中执行的命令, 退出码 0 为健康
+	Interval    time.Duration `json:"Interval"`    // 两次检查的间隔
+	Timeout     time.Duration `json:"Timeout"`     // 单次检查超时时间
+	Retries     int           `json:"Retries"`     // 连续失败次数达到 Retries 后为 unhealthy
+	StartPeriod time.Duration `json:"StartPeriod"` // 容器启动后的初始化时间, 期间的失败不计入 Retries
+}
+
+// Health 容器健康状态
+type Health struct {
+	Status        string          `json:"Status"`        // starting healthy unhealthy
+	FailingStreak int             `json:"FailingStreak"` // 连续失败次数
+	Log           []*HealthResult `json:"Log"`           // 最近 HealthLogMax 次检查结果
+}
+
+// HealthResult 单次健康检查结果
+type HealthResult struct {
+	Start    string `json:"Start"`
+	End      string `json:"End"`
+	ExitCode int    `json:"ExitCode"`
+	Output   string `json:"Output"`
+}
+
+// Enabled 是否需要进行健康检查
+func (hc *HealthConfig) Enabled() bool {
+	return hc != nil && strings.TrimSpace(hc.Test) != "" && hc.Test != HealthCheckDisable
+}
+
+// SetDefault 未设置的参数使用默认值
+func (hc *HealthConfig) SetDefault() {
+	if hc.Interval <= 0 {
+		hc.Interval = DefaultHealthInterval
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = DefaultHealthTimeout
+	}
+	if hc.Retries <= 0 {
+		hc.Retries = DefaultHealthRetries
+	}
+	if hc.StartPeriod < 0 {
+		hc.StartPeriod = 0
+	}
+}
+
+// NewHealth 容器启动时的健康状态
+func NewHealth() *Health {
+	return &Health{
+		Status: HealthStarting,
+		Log:    []*HealthResult{},
+	}
+}
+
+// Update 记录一次检查结果并更新健康状态
+// inStartPeriod 为 true 时失败不计入连续失败次数
+func (h *Health) Update(result *HealthResult, retries int, inStartPeriod bool) {
+
+	h.Log = append(h.Log, result)
+	if len(h.Log) > HealthLogMax {
+		h.Log = h.Log[len(h.Log)-HealthLogMax:]
+	}
+
+	if result.ExitCode == 0 {
+		h.Status = HealthHealthy
+		h.FailingStreak = 0
+		return
+	}
+
+	// 初始化期间的失败忽略
+	if inStartPeriod && h.Status == HealthStarting {
+		return
+	}
+
+	h.FailingStreak++
+	if h.FailingStreak >= retries {
+		h.Status = HealthUnhealthy
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 
@@ -13,98 +14,172 @@ import (
 )
 
 // RunContainerInitProcess 创建真正的容器进程
+// 启动失败时通过 fd 4 管道将错误返回给父进程
 func RunContainerInitProcess() error {
-	// 获取用户输入
-	cmdList := readUserCmd()
-	// 去除空白字符
-	cmdList = RemoveNullSliceString(cmdList)
-	log.Debugf("Get cmdList %v from user", cmdList)
 
-	if len(cmdList) == 0 || (len(cmdList) == 1 && strings.Replace(cmdList[0], " ", "", -1) == "") {
-		return fmt.Errorf("Run container get command is nil")
+	statusPipe := os.NewFile(uintptr(initStatusFd), "status")
+
+	if err := startContainerProcess(statusPipe); err != nil {
+		sendInitStatus(statusPipe, err)
+		return err
 	}
 
-	// 设置根目录挂载点
-	setUpMount()
+	return nil
+}
 
-	// 调用 exec.LookPath 在系统的 PATH 中寻找命令的绝对路径
-	absPath, err := exec.LookPath(cmdList[0])
+// startContainerProcess 按照父进程发送的启动配置初始化容器, 并运行用户命令
+// exec 成功后不会返回
+func startContainerProcess(statusPipe *os.File) error {
 
+	// 获取启动配置
+	initConfig, err := readInitConfig()
 	if err != nil {
-		log.Errorf("Exec Loop Path error : %v", err)
-	} else {
-		log.Debugf("Find command absPATH : %s", absPath)
+		return err
 	}
 
-	// exec 创建真正的容器种需要运行的进程
-	if err := syscall.Exec(absPath, cmdList[0:], os.Environ()); err != nil {
-		log.Errorf("Init container process error %v", err.Error())
+	log.Debugf("Get cmdList %q from user", initConfig.Args)
+
+	// newuidmap 在 init 进程 exec 之后才设置映射, exec 时不是容器 root, 没有 capability
+	// 映射完成后重新 exec 获得容器 root 的 capability
+	if !hasEffectiveCapabilities() && os.Args[0] != initReexecName {
+		return reexecInit(initConfig)
 	}
 
-	return nil
+	// 用户命令 exec 成功后关闭管道, 父进程读到 EOF
+	syscall.CloseOnExec(initStatusFd)
 
-}
+	// 设置根目录挂载点
+	if err := setUpMount(initConfig); err != nil {
+		return err
+	}
 
-// readUserCmd 获取用户参数
-func readUserCmd() []string {
+	if initConfig.Hostname != "" {
+		if err := syscall.Sethostname([]byte(initConfig.Hostname)); err != nil {
+			return fmt.Errorf("Set hostname %v error %v", initConfig.Hostname, err)
+		}
+	}
 
-	// readPipe是下标为 3 的文件描述符
-	readPipe := os.NewFile(uintptr(3), "pipe")
-	defer readPipe.Close()
-	cmdByte, err := ioutil.ReadAll(readPipe)
+	// 工作目录不存在时创建
+	if initConfig.Cwd != "" {
+		if err := os.MkdirAll(initConfig.Cwd, 0755); err != nil {
+			return fmt.Errorf("Mkdir work dir %v error %v", initConfig.Cwd, err)
+		}
+		if err := os.Chdir(initConfig.Cwd); err != nil {
+			return fmt.Errorf("Change work dir %v error %v", initConfig.Cwd, err)
+		}
+	}
 
+	// 根据镜像的 /etc/passwd /etc/group 解析运行用户
+	execUser, err := GetExecUser(initConfig.User, "/etc/passwd", "/etc/group")
 	if err != nil {
-		log.Errorf("Get user's cmd error : %v", err)
-		return nil
+		return err
+	}
+
+	// LookPath 使用容器的 PATH
+	os.Clearenv()
+	for _, env := range initConfig.Env {
+		if kv := strings.SplitN(env, "=", 2); len(kv) == 2 {
+			os.Setenv(kv[0], kv[1])
+		}
 	}
 
-	// 传过来的是字节
-	cmdString := string(cmdByte)
+	if _, ok := os.LookupEnv("HOME"); !ok {
+		initConfig.Env = append(initConfig.Env, "HOME="+execUser.Home)
+	}
 
-	// 替换转义字符
-	cmdString = strings.ReplaceAll(cmdString, "\\", "")
+	// 调用 exec.LookPath 在系统的 PATH 中寻找命令的绝对路径
+	absPath, err := exec.LookPath(initConfig.Args[0])
+	if err != nil {
+		return fmt.Errorf("Exec %v error : %v", initConfig.Args[0], err)
+	}
 
-	cmdListInit := strings.Split(cmdString, " ")
+	log.Debugf("Find command absPATH : %s", absPath)
 
-	// 存在 参数 “xx  yy zz” cmdtmpList 用于临时保存
-	cmdtmpList := []string{}
+	// 生成 seccomp 过滤程序, 在 exec 前安装
+	var seccompProgram []syscall.SockFilter
+	if initConfig.Seccomp != nil {
+		if seccompProgram, err = CompileSeccompProfile(initConfig.Seccomp, initConfig.Capabilities); err != nil {
+			return err
+		}
+	}
 
-	// cmdList 整理完成的 cmdlist
-	cmdList := []string{}
+	// capability 与 no_new_privs 属于线程, 之后的设置与 exec 需要在同一线程中执行
+	runtime.LockOSThread()
 
-	// 存在参数 “xx  yy”
-	for _, list := range cmdListInit {
-		if strings.HasPrefix(list, "\"") || strings.HasPrefix(list, "'") {
+	// 容器 root 的 capability 由 bounding set 限制
+	if err := dropBoundingSet(initConfig.Capabilities); err != nil {
+		return err
+	}
 
-			// 清空 cmdtmpList
-			cmdtmpList = []string{}
-			cmdtmpList = append(cmdtmpList, list)
+	if initConfig.NoNewPrivs {
+		if err := setNoNewPrivileges(); err != nil {
+			return err
+		}
+	}
 
-			// 跳过本次循环
-			continue
+	// init 进程保留 capability 用于转发信号与回收进程, 用户进程继承 bounding set 与 seccomp
+	if initConfig.Init {
+		if seccompProgram != nil {
+			if err := setSeccomp(seccompProgram); err != nil {
+				return err
+			}
 		}
+		return runInitProcess(absPath, initConfig.Args, initConfig.Env, execUser, statusPipe)
+	}
+
+	// 切换到运行用户, 保留 permitted capability 再重新设置
+	if err := setKeepCaps(); err != nil {
+		return err
+	}
 
-		// “xx  yy” 的结尾
-		if strings.HasSuffix(list, "\"") || strings.HasPrefix(list, "'") {
+	if err := setupUser(execUser); err != nil {
+		return err
+	}
 
-			// 清空 cmdtmpList
-			cmdtmpList = []string{}
-			cmdtmpList = append(cmdtmpList, list)
+	if err := setCapabilities(initConfig.Capabilities); err != nil {
+		return err
+	}
 
-			// 根据空格拼接 cmdtmpList
-			list = strings.Join(cmdtmpList, " ")
+	// seccomp 最后安装, 之后只调用 exec
+	if seccompProgram != nil {
+		if err := setSeccomp(seccompProgram); err != nil {
+			return err
 		}
+	}
+
+	// exec 创建真正的容器种需要运行的进程
+	if err := syscall.Exec(absPath, initConfig.Args, initConfig.Env); err != nil {
+		return fmt.Errorf("Exec %v error : %v", absPath, err)
+	}
+
+	return nil
+}
 
-		if len(cmdtmpList) >= 1 {
-			cmdtmpList = append(cmdtmpList, list)
-			continue
+// setupUser 设置当前进程的附加组 gid uid
+func setupUser(execUser *ExecUser) error {
+
+	// user namespace 禁用 setgroups 时保留原有的附加组
+	if !setgroupsDenied() {
+		if err := syscall.Setgroups(execUser.Sgids); err != nil {
+			return fmt.Errorf("Set groups %v error %v", execUser.Sgids, err)
 		}
+	}
+
+	if err := syscall.Setgid(execUser.Gid); err != nil {
+		return fmt.Errorf("Set gid %v error %v", execUser.Gid, err)
+	}
 
-		// 将命令加入到cmdList中
-		cmdList = append(cmdList, list)
+	if err := syscall.Setuid(execUser.Uid); err != nil {
+		return fmt.Errorf("Set uid %v error %v", execUser.Uid, err)
 	}
 
-	return cmdList
+	return nil
+}
+
+// setgroupsDenied 判断当前 user namespace 是否禁用了 setgroups
+func setgroupsDenied() bool {
+	content, err := ioutil.ReadFile("/proc/self/setgroups")
+	return err == nil && strings.TrimSpace(string(content)) == "deny"
 }
 
 // pivot_root 系统调用，改变当前的root文件系统
@@ -156,7 +231,7 @@ func pivotRoot(root string) error {
 
 // init 挂载点
 // setUpMount 在 RunContainerInitProcess 中执行
-func setUpMount() {
+func setUpMount(initConfig *InitConfig) error {
 
 	// 获取当前路径
 	pwd, err := os.Getwd()
@@ -166,6 +241,12 @@ func setUpMount() {
 
 	log.Debugf("Current dir is : %v", pwd)
 
+	// 容器内的挂载不传播到宿主机, 宿主机的挂载仍然传播到容器
+	// 父 mount namespace 为 shared 时 pivot_root 会失败
+	if err := syscall.Mount("", "/", "", syscall.MS_SLAVE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("Make / rslave error %v", err)
+	}
+
 	/*
 		MS_ONEXC 本文件系统允许允许其他程序
 		MS_NOSUID 本文件系统运行时，不允许 set_uid 和 set_gid
@@ -180,6 +261,11 @@ func setUpMount() {
 		log.Debugf("Mount proc system success")
 	}
 
+	// mount -t sysfs sysfs /sys
+	if err := mountSysfs(pwd, initConfig.Privileged); err != nil {
+		return err
+	}
+
 	// 挂载内存文件系统 tmpfs
 	if err := syscall.Mount("tmpfs", filepath.Join(pwd, "/dev"), "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755"); err != nil {
 		log.Warnf("Mount tmpfs system fail : %v", err)
@@ -187,9 +273,36 @@ func setUpMount() {
 		log.Debugf("Mount tmpfs system success")
 	}
 
+	// 创建 /dev 下的设备 devpts shm mqueue
+	if err := setupDev(pwd, initConfig.ShmSize, initConfig.Devices); err != nil {
+		return err
+	}
+
 	// 挂载数据卷
-	InitVolume(pwd)
+	if err := InitVolume(pwd, initConfig.Mounts); err != nil {
+		return err
+	}
 
 	// 修改当前目录为 根目录
-	pivotRoot(pwd)
+	if err := pivotRoot(pwd); err != nil {
+		return err
+	}
+
+	// --read-only 只影响根目录, /dev /proc 数据卷与 --tmpfs 仍然可写
+	if initConfig.ReadOnly {
+		if err := remountReadOnly("/"); err != nil {
+			return err
+		}
+	}
+
+	if initConfig.Privileged {
+		return nil
+	}
+
+	// 屏蔽 /proc /sys 中泄露宿主机信息或可以修改内核的路径
+	if err := maskPaths(DefaultMaskedPaths); err != nil {
+		return err
+	}
+
+	return readonlyPaths(DefaultReadonlyPaths)
 }
@@ -0,0 +1,262 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// InitConfigVersion 父进程与 init 进程之间启动配置的版本, 修改 InitConfig 时递增
+// 升级 qsrdocker 后, 旧版本 monitor 重启容器时可以发现不兼容
+const InitConfigVersion = 1
+
+// init 进程的管道, 父进程通过 cmd.ExtraFiles 传入
+const (
+	initConfigFd = 3 // 父进程写入启动配置
+	initStatusFd = 4 // init 进程返回启动结果
+)
+
+// InitConfig 父进程通过管道以 json 发送给 init 进程的启动配置
+type InitConfig struct {
+	Version  int          `json:"Version"`
+	Args     []string     `json:"Args"`     // 用户命令, 每个参数原样保留
+	Env      []string     `json:"Env"`      // 用户进程的环境变量
+	Cwd      string       `json:"Cwd"`      // 容器内的工作目录, 为空时为 /
+	User     string       `json:"User"`     // 容器内运行用户, 为空时为 root
+	Hostname string       `json:"Hostname"` // 容器的主机名
+	Mounts   []*MountInfo `json:"Mounts"`   // 数据卷
+	Init     bool         `json:"Init"`     // --init 时 init 进程保持为 PID 1
+	ShmSize  string       `json:"ShmSize"`  // /dev/shm 大小
+	Devices  []*Device    `json:"Devices"`  // --device 的设备
+	ReadOnly bool         `json:"ReadOnly"` // pivotRoot 后将根目录重新挂载为只读
+
+	Privileged   bool            `json:"Privileged"`   // 不屏蔽 /proc 中的路径, /sys 可写
+	Capabilities []string        `json:"Capabilities"` // 用户进程保留的 capability
+	NoNewPrivs   bool            `json:"NoNewPrivs"`   // 设置 PR_SET_NO_NEW_PRIVS
+	Seccomp      *SeccompProfile `json:"Seccomp"`      // exec 前安装的 seccomp profile, 为空时不限制
+}
+
+// InitStatus init 进程返回的启动结果
+// 用户命令 exec 成功时管道随 O_CLOEXEC 关闭, 父进程读到空内容
+type InitStatus struct {
+	Error string `json:"Error"`
+}
+
+// NewInitConfig 根据 containerInfo 生成 init 进程的启动配置
+func NewInitConfig(containerInfo *ContainerInfo) (*InitConfig, error) {
+
+	seccompProfile, err := containerInfo.GetSeccompProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &InitConfig{
+		Version:  InitConfigVersion,
+		Args:     append([]string{containerInfo.Path}, containerInfo.Args...),
+		Env:      append([]string{}, containerInfo.Env...),
+		Cwd:      containerInfo.WorkingDir,
+		User:     containerInfo.User,
+		Hostname: containerInfo.GetHostname(),
+		Mounts:   containerInfo.Mount,
+		Init:     containerInfo.Init,
+		ShmSize:  containerInfo.ShmSize,
+		Devices:  containerInfo.Devices,
+		ReadOnly: containerInfo.ReadOnly,
+
+		Privileged:   containerInfo.Privileged,
+		Capabilities: containerInfo.GetCapabilities(),
+		NoNewPrivs:   containerInfo.NoNewPrivs,
+		Seccomp:      seccompProfile,
+	}, nil
+}
+
+// GetHostname 获取容器的主机名, 未设置 -h 时为容器 ID
+func (containerInfo *ContainerInfo) GetHostname() string {
+	if containerInfo.Hostname != "" {
+		return containerInfo.Hostname
+	}
+	return containerInfo.ID
+}
+
+// SendInitConfig 将启动配置写入管道并关闭写端
+func SendInitConfig(configPipe *os.File, initConfig *InitConfig) error {
+	defer configPipe.Close()
+
+	configBytes, err := json.Marshal(initConfig)
+	if err != nil {
+		return fmt.Errorf("Json marshal init config error %v", err)
+	}
+
+	log.Debugf("Send init config : %s", configBytes)
+
+	if _, err := configPipe.Write(configBytes); err != nil {
+		return fmt.Errorf("Send init config error %v", err)
+	}
+
+	return nil
+}
+
+// WaitInitStatus 等待 init 进程返回启动结果
+// init 进程 exec 用户命令后返回 nil, 启动失败时返回 init 进程的错误
+func WaitInitStatus(statusPipe *os.File) error {
+	defer statusPipe.Close()
+
+	statusBytes, err := ioutil.ReadAll(statusPipe)
+	if err != nil {
+		return fmt.Errorf("Read init status error %v", err)
+	}
+
+	if len(statusBytes) == 0 {
+		return nil
+	}
+
+	status := &InitStatus{}
+	if err := json.Unmarshal(statusBytes, status); err != nil {
+		return fmt.Errorf("Json unmarshal init status %s error %v", statusBytes, err)
+	}
+
+	if status.Error != "" {
+		return fmt.Errorf("%v", status.Error)
+	}
+
+	return nil
+}
+
+// readInitConfig init 进程读取父进程发送的启动配置
+func readInitConfig() (*InitConfig, error) {
+
+	configPipe := os.NewFile(uintptr(initConfigFd), "config")
+	defer configPipe.Close()
+
+	configBytes, err := ioutil.ReadAll(configPipe)
+	if err != nil {
+		return nil, fmt.Errorf("Read init config error %v", err)
+	}
+
+	initConfig := &InitConfig{}
+	if err := json.Unmarshal(configBytes, initConfig); err != nil {
+		return nil, fmt.Errorf("Json unmarshal init config error %v", err)
+	}
+
+	if initConfig.Version != InitConfigVersion {
+		return nil, fmt.Errorf("Init config version %v is not supported, expect %v", initConfig.Version, InitConfigVersion)
+	}
+
+	if len(initConfig.Args) == 0 || initConfig.Args[0] == "" {
+		return nil, fmt.Errorf("Run container get command is nil")
+	}
+
+	return initConfig, nil
+}
+
+// initReexecName init 进程重新 exec 时的 argv[0], 避免重复 exec
+const initReexecName = "qsrdocker-init"
+
+// reexecInit 将启动配置写入匿名文件作为 fd 3, 重新 exec init 进程
+// exec 后没有进程写入管道, 启动配置超过管道缓冲区时会阻塞, 因此不使用管道
+// 启动结果管道 fd 4 保持不变
+func reexecInit(initConfig *InitConfig) error {
+
+	configBytes, err := json.Marshal(initConfig)
+	if err != nil {
+		return fmt.Errorf("Json marshal init config error %v", err)
+	}
+
+	configFile, err := newInitConfigFile(configBytes)
+	if err != nil {
+		return err
+	}
+
+	if configFile.Fd() != initConfigFd {
+		if err := syscall.Dup3(int(configFile.Fd()), initConfigFd, 0); err != nil {
+			return fmt.Errorf("Dup init config file error %v", err)
+		}
+	} else if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, initConfigFd, syscall.F_SETFD, 0); errno != 0 {
+		return fmt.Errorf("Clear init config file close-on-exec error %v", errno)
+	}
+
+	log.Debugf("Reexec init process to get capabilities")
+
+	if err := syscall.Exec("/proc/self/exe", []string{initReexecName, "init"}, os.Environ()); err != nil {
+		return fmt.Errorf("Reexec init process error %v", err)
+	}
+
+	// exec 之前 configFile 不能被回收关闭
+	runtime.KeepAlive(configFile)
+
+	return nil
+}
+
+// newInitConfigFile 写入启动配置的匿名文件, 读写位置设置为文件开头
+// 优先使用 memfd_create, 不支持时使用删除后的临时文件
+func newInitConfigFile(configBytes []byte) (*os.File, error) {
+
+	var configFile *os.File
+
+	if nr, ok := syscallTable["memfd_create"]; ok {
+		name := []byte("qsrdocker-init-config\x00")
+		fd, _, errno := syscall.Syscall(uintptr(nr), uintptr(unsafe.Pointer(&name[0])), 0, 0)
+		if errno == 0 {
+			configFile = os.NewFile(fd, "init-config")
+		} else {
+			log.Debugf("Memfd create init config error %v, use temp file", errno)
+		}
+	}
+
+	if configFile == nil {
+		tmpFile, err := ioutil.TempFile("", "qsrdocker-init-config")
+		if err != nil {
+			return nil, fmt.Errorf("Create init config file error %v", err)
+		}
+		os.Remove(tmpFile.Name())
+		configFile = tmpFile
+	}
+
+	if _, err := configFile.Write(configBytes); err != nil {
+		configFile.Close()
+		return nil, fmt.Errorf("Write init config error %v", err)
+	}
+
+	// exec 后的 init 进程与当前进程共享读写位置
+	if _, err := configFile.Seek(0, 0); err != nil {
+		configFile.Close()
+		return nil, fmt.Errorf("Seek init config file error %v", err)
+	}
+
+	return configFile, nil
+}
+
+// hasEffectiveCapabilities 判断当前进程是否有 capability
+func hasEffectiveCapabilities() bool {
+
+	content, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return true
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "CapEff:") {
+			capEff, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+			return err != nil || capEff != 0
+		}
+	}
+
+	return true
+}
+
+// sendInitStatus init 进程启动失败时将错误返回给父进程
+func sendInitStatus(statusPipe *os.File, err error) {
+
+	statusBytes, _ := json.Marshal(&InitStatus{Error: err.Error()})
+	if _, err := statusPipe.Write(statusBytes); err != nil {
+		log.Errorf("Send init status error %v", err)
+	}
+}
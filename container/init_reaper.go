@@ -0,0 +1,120 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runInitProcess qsrdocker run --init 时 init 进程保持为容器的 PID 1
+// 1. 以子进程运行用户命令, 子进程位于独立的进程组, 终端产生的信号只发送给子进程
+// 2. 除 SIGCHLD 外所有可以捕获的信号转发给子进程, 与 tini docker-init 相同
+// 3. 回收容器内所有退出的进程, 包括被托管给 PID 1 的孤儿进程
+// 4. 用户进程退出后以相同的退出码退出, 被信号杀死时为 128 + signal
+func runInitProcess(absPath string, cmdList []string, env []string, execUser *ExecUser, statusPipe *os.File) error {
+
+	// 在启动子进程前接收信号, 避免遗漏子进程的 SIGCHLD
+	// 接收所有信号, kill 与 stop 的自定义信号 (SIGRTMIN+3 SIGALRM SIGCONT ...) 都需要转发
+	// 程序错误产生的 SIGSEGV 等同步信号仍由 go runtime 处理
+	sigCh := make(chan os.Signal, 64)
+	signal.Notify(sigCh)
+
+	procAttr := &syscall.ProcAttr{
+		Env:   env,
+		Files: []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()},
+		Sys: &syscall.SysProcAttr{
+			Setpgid: true,
+			// 用户进程以 -u 指定的用户运行, init 进程保持为 root
+			Credential: &syscall.Credential{
+				Uid:         uint32(execUser.Uid),
+				Gid:         uint32(execUser.Gid),
+				Groups:      intsToUint32s(execUser.Sgids),
+				NoSetGroups: setgroupsDenied(),
+			},
+		},
+	}
+
+	// 标准输入为控制终端时, 子进程的进程组成为终端的前台进程组
+	if isControllingTerminal(os.Stdin) {
+		procAttr.Sys.Foreground = true
+		procAttr.Sys.Ctty = int(os.Stdin.Fd())
+	}
+
+	pid, err := syscall.ForkExec(absPath, cmdList, procAttr)
+	if err != nil {
+		signal.Stop(sigCh)
+		return fmt.Errorf("Exec %v error : %v", absPath, err)
+	}
+
+	log.Debugf("Init start user's process pid %v", pid)
+
+	// 用户进程已启动, 通知父进程
+	statusPipe.Close()
+
+	for sig := range sigCh {
+		switch sig {
+		case syscall.SIGCHLD:
+			if exitCode, exited := reapChildren(pid); exited {
+				os.Exit(exitCode)
+			}
+		case syscall.SIGURG:
+			// go runtime 抢占调度使用的信号, 无法区分来源, 不转发
+		default:
+			if err := syscall.Kill(pid, sig.(syscall.Signal)); err != nil && err != syscall.ESRCH {
+				log.Warnf("Forward signal %v to pid %v error %v", sig, pid, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// reapChildren 回收所有已退出的子进程
+// 用户进程退出时返回其退出码与 true
+func reapChildren(pid int) (int, bool) {
+
+	exitCode, exited := 0, false
+
+	for {
+		var waitStatus syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &waitStatus, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || wpid <= 0 {
+			// ECHILD 没有子进程, 或没有已退出的子进程
+			return exitCode, exited
+		}
+
+		if wpid != pid {
+			log.Debugf("Init reap zombie process pid %v", wpid)
+			continue
+		}
+
+		exited = true
+		if waitStatus.Signaled() {
+			exitCode = 128 + int(waitStatus.Signal())
+		} else {
+			exitCode = waitStatus.ExitStatus()
+		}
+	}
+}
+
+// intsToUint32s 转换附加组 gid
+func intsToUint32s(ids []int) []uint32 {
+	result := make([]uint32, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, uint32(id))
+	}
+	return result
+}
+
+// isControllingTerminal 判断 f 是否为当前进程的控制终端
+func isControllingTerminal(f *os.File) bool {
+	var pgrp int32
+	return ioctl(f.Fd(), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp))) == nil
+}
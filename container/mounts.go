@@ -0,0 +1,270 @@
+package container
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultPropagation 数据卷默认的挂载传播类型, 与 docker 相同
+const DefaultPropagation = "rprivate"
+
+// propagationFlags 挂载传播类型对应的挂载标志
+var propagationFlags = map[string]uintptr{
+	"private":  syscall.MS_PRIVATE,
+	"rprivate": syscall.MS_PRIVATE | syscall.MS_REC,
+	"shared":   syscall.MS_SHARED,
+	"rshared":  syscall.MS_SHARED | syscall.MS_REC,
+	"slave":    syscall.MS_SLAVE,
+	"rslave":   syscall.MS_SLAVE | syscall.MS_REC,
+}
+
+// volumeNameRegexp 命名数据卷的名称
+var volumeNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ParseVolume 解析 -v src:dst[:options] 与 -v dst
+// src 为名称时使用命名数据卷, 为路径时 bind mount 宿主机目录, 只有 dst 时创建匿名数据卷
+// options 以 , 分隔, 支持 ro rw z Z 与挂载传播类型, z Z 为 SELinux 标签, 不做处理
+func ParseVolume(spec string) (*MountInfo, error) {
+
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 || strings.TrimSpace(parts[0]) == "" || (len(parts) > 1 && strings.TrimSpace(parts[1]) == "") {
+		return nil, fmt.Errorf("Invalid volume %v, use src:dst[:options] or dst", spec)
+	}
+
+	mount := &MountInfo{
+		RW:          true,
+		Propagation: DefaultPropagation,
+	}
+
+	switch {
+	case len(parts) == 1:
+		// 匿名数据卷, 名称在容器创建时生成
+		mount.Type = VolumeMountType
+		mount.Destination = parts[0]
+	case volumeNameRegexp.MatchString(parts[0]):
+		mount.Type = VolumeMountType
+		mount.Name = parts[0]
+		mount.Source = VolumeMountpoint(mount.Name)
+		mount.Destination = parts[1]
+	default:
+		source, err := filepath.Abs(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("Get volume %v abs path error %v", parts[0], err)
+		}
+		mount.Type = MountType
+		mount.Source = source
+		mount.Destination = parts[1]
+	}
+
+	if len(parts) == 3 {
+		mount.Mode = parts[2]
+		for _, option := range strings.Split(parts[2], ",") {
+			switch option {
+			case "ro":
+				mount.RW = false
+			case "rw":
+				mount.RW = true
+			case "z", "Z":
+				log.Debugf("SELinux label %v of volume %v is ignored", option, spec)
+			default:
+				if _, ok := propagationFlags[option]; !ok {
+					return nil, fmt.Errorf("Invalid volume option %v in %v, use ro rw z Z or a propagation like rprivate", option, spec)
+				}
+				if mount.Type != MountType {
+					return nil, fmt.Errorf("Propagation %v is only supported by bind mount %v", option, spec)
+				}
+				mount.Propagation = option
+			}
+		}
+	}
+
+	if err := checkMountDestination(mount, spec); err != nil {
+		return nil, err
+	}
+
+	return mount, nil
+}
+
+// ParseMount 解析 --mount type=bind|volume|tmpfs,source=..,target=..,readonly,bind-propagation=..
+// 未指定 type 时为 volume, tmpfs 支持 tmpfs-size tmpfs-mode
+func ParseMount(spec string) (*MountInfo, error) {
+
+	mount := &MountInfo{Type: VolumeMountType, RW: true}
+	var propagation, tmpfsSize, tmpfsMode string
+
+	for _, field := range strings.Split(spec, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+
+		switch key {
+		case "type":
+			mount.Type = value
+		case "source", "src":
+			mount.Source = value
+		case "target", "destination", "dst":
+			mount.Destination = value
+		case "readonly", "ro":
+			// readonly 或 readonly=true|false
+			readonly := true
+			if len(kv) == 2 {
+				var err error
+				if readonly, err = strconv.ParseBool(value); err != nil {
+					return nil, fmt.Errorf("Invalid readonly value %v in mount %v", value, spec)
+				}
+			}
+			mount.RW = !readonly
+		case "bind-propagation":
+			propagation = value
+		case "tmpfs-size":
+			tmpfsSize = value
+		case "tmpfs-mode":
+			tmpfsMode = value
+		default:
+			return nil, fmt.Errorf("Unknown mount option %v in %v", key, spec)
+		}
+	}
+
+	if propagation != "" && mount.Type != MountType {
+		return nil, fmt.Errorf("bind-propagation is only supported by bind mount %v", spec)
+	}
+
+	if (tmpfsSize != "" || tmpfsMode != "") && mount.Type != TmpfsMountType {
+		return nil, fmt.Errorf("tmpfs options are only supported by tmpfs mount %v", spec)
+	}
+
+	switch mount.Type {
+	case MountType:
+		if !filepath.IsAbs(mount.Source) {
+			return nil, fmt.Errorf("Invalid mount %v, bind source needs to be an absolute path", spec)
+		}
+		mount.Source = filepath.Clean(mount.Source)
+
+		mount.Propagation = DefaultPropagation
+		if propagation != "" {
+			if _, ok := propagationFlags[propagation]; !ok {
+				return nil, fmt.Errorf("Invalid bind-propagation %v in %v", propagation, spec)
+			}
+			mount.Propagation = propagation
+		}
+
+	case VolumeMountType:
+		// 未指定 source 时为匿名数据卷
+		if mount.Source != "" {
+			if !volumeNameRegexp.MatchString(mount.Source) {
+				return nil, fmt.Errorf("Invalid mount %v, volume source needs to be a name like [a-zA-Z0-9][a-zA-Z0-9_.-]*", spec)
+			}
+			mount.Name = mount.Source
+			mount.Source = VolumeMountpoint(mount.Name)
+		}
+		mount.Propagation = DefaultPropagation
+
+	case TmpfsMountType:
+		if mount.Source != "" {
+			return nil, fmt.Errorf("Invalid mount %v, tmpfs doesn't support source", spec)
+		}
+		mount.Source = TmpfsMountType
+
+		var options []string
+		if tmpfsSize != "" {
+			options = append(options, "size="+tmpfsSize)
+		}
+		if tmpfsMode != "" {
+			options = append(options, "mode="+tmpfsMode)
+		}
+		if !mount.RW {
+			options = append(options, "ro")
+		}
+		mount.Options = strings.Join(options, ",")
+
+		if _, _, err := tmpfsMountOptions(mount.Options); err != nil {
+			return nil, fmt.Errorf("Invalid mount %v, %v", spec, err)
+		}
+
+	default:
+		return nil, fmt.Errorf("Invalid mount type %v in %v, use bind volume or tmpfs", mount.Type, spec)
+	}
+
+	if err := checkMountDestination(mount, spec); err != nil {
+		return nil, err
+	}
+
+	return mount, nil
+}
+
+// ParseVolumes 解析所有 -v 与 --mount
+func ParseVolumes(volumes, mounts []string) ([]*MountInfo, error) {
+
+	var mountInfos []*MountInfo
+
+	for _, volume := range volumes {
+		if strings.TrimSpace(volume) == "" {
+			continue
+		}
+		mount, err := ParseVolume(volume)
+		if err != nil {
+			return nil, err
+		}
+		mountInfos = append(mountInfos, mount)
+	}
+
+	for _, spec := range mounts {
+		mount, err := ParseMount(spec)
+		if err != nil {
+			return nil, err
+		}
+		mountInfos = append(mountInfos, mount)
+	}
+
+	return mountInfos, nil
+}
+
+// checkMountDestination 容器内的路径需要为绝对路径且不能为 /
+func checkMountDestination(mount *MountInfo, spec string) error {
+	if !filepath.IsAbs(mount.Destination) || filepath.Clean(mount.Destination) == "/" {
+		return fmt.Errorf("Invalid mount %v, the target needs to be an absolute path other than /", spec)
+	}
+	mount.Destination = filepath.Clean(mount.Destination)
+	return nil
+}
+
+// mountBind 将 source bind mount 到 dest, 设置只读与挂载传播类型
+// 只读只作用于 dest 本身, source 下的子挂载点仍然可写
+func mountBind(source, dest string, mount *MountInfo) error {
+
+	if err := syscall.Mount(source, dest, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("Bind mount %v to %v error %v", source, mount.Destination, err)
+	}
+
+	if !mount.RW {
+		if err := remountReadOnly(dest); err != nil {
+			return err
+		}
+	}
+
+	// 旧版本创建的容器没有记录挂载传播类型
+	propagation := mount.Propagation
+	if propagation == "" {
+		propagation = DefaultPropagation
+	}
+
+	flags, ok := propagationFlags[propagation]
+	if !ok {
+		return fmt.Errorf("Invalid propagation %v of volume %v", propagation, mount.Destination)
+	}
+
+	if err := syscall.Mount("", dest, "", flags, ""); err != nil {
+		return fmt.Errorf("Set propagation %v of volume %v error %v", propagation, mount.Destination, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseVolume(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		spec string
+		want MountInfo
+	}{
+		{"/data", MountInfo{Type: VolumeMountType, Destination: "/data", RW: true, Propagation: DefaultPropagation}},
+		{"/data/", MountInfo{Type: VolumeMountType, Destination: "/data", RW: true, Propagation: DefaultPropagation}},
+		{"db:/var/lib/db", MountInfo{Type: VolumeMountType, Name: "db", Source: VolumeMountpoint("db"), Destination: "/var/lib/db", RW: true, Propagation: DefaultPropagation}},
+		{"db:/var/lib/db:ro", MountInfo{Type: VolumeMountType, Name: "db", Source: VolumeMountpoint("db"), Destination: "/var/lib/db", Mode: "ro", Propagation: DefaultPropagation}},
+		{"/host:/data", MountInfo{Type: MountType, Source: "/host", Destination: "/data", RW: true, Propagation: DefaultPropagation}},
+		{"/host:/data:ro", MountInfo{Type: MountType, Source: "/host", Destination: "/data", Mode: "ro", Propagation: DefaultPropagation}},
+		{"/host:/data:ro,rw", MountInfo{Type: MountType, Source: "/host", Destination: "/data", Mode: "ro,rw", RW: true, Propagation: DefaultPropagation}},
+		{"/host:/data:rw,z", MountInfo{Type: MountType, Source: "/host", Destination: "/data", Mode: "rw,z", RW: true, Propagation: DefaultPropagation}},
+		{"/host:/data:ro,rshared", MountInfo{Type: MountType, Source: "/host", Destination: "/data", Mode: "ro,rshared", Propagation: "rshared"}},
+		{"./host:/data", MountInfo{Type: MountType, Source: filepath.Join(cwd, "host"), Destination: "/data", RW: true, Propagation: DefaultPropagation}},
+		{"../host/:/data", MountInfo{Type: MountType, Source: filepath.Join(filepath.Dir(cwd), "host"), Destination: "/data", RW: true, Propagation: DefaultPropagation}},
+	} {
+		mount, err := ParseVolume(c.spec)
+		if err != nil {
+			t.Fatalf("parse volume %v error : %v", c.spec, err)
+		}
+		if !reflect.DeepEqual(*mount, c.want) {
+			t.Fatalf("volume %v => %+v, want %+v", c.spec, *mount, c.want)
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		":/data",
+		"/host:",
+		"/host:/data:ro:extra",
+		"/host:/data:rx",
+		"db:/data:rshared",
+		"/data:ro",
+		"/host:data",
+		"/host:/",
+		"relative",
+	} {
+		if _, err := ParseVolume(spec); err == nil {
+			t.Fatalf("parse volume %v should fail", spec)
+		}
+	}
+}
+
+func TestParseMount(t *testing.T) {
+	for _, c := range []struct {
+		spec string
+		want MountInfo
+	}{
+		{"target=/data", MountInfo{Type: VolumeMountType, Destination: "/data", RW: true, Propagation: DefaultPropagation}},
+		{"type=volume,source=db,target=/var/lib/db", MountInfo{Type: VolumeMountType, Name: "db", Source: VolumeMountpoint("db"), Destination: "/var/lib/db", RW: true, Propagation: DefaultPropagation}},
+		{"src=db,dst=/var/lib/db,readonly", MountInfo{Type: VolumeMountType, Name: "db", Source: VolumeMountpoint("db"), Destination: "/var/lib/db", Propagation: DefaultPropagation}},
+		{"type=bind,source=/host/,destination=/data", MountInfo{Type: MountType, Source: "/host", Destination: "/data", RW: true, Propagation: DefaultPropagation}},
+		{"type=bind,src=/host,dst=/data,ro=true,bind-propagation=rslave", MountInfo{Type: MountType, Source: "/host", Destination: "/data", Propagation: "rslave"}},
+		{"type=bind,src=/host,dst=/data,readonly=false", MountInfo{Type: MountType, Source: "/host", Destination: "/data", RW: true, Propagation: DefaultPropagation}},
+		{"type=tmpfs,target=/run", MountInfo{Type: TmpfsMountType, Source: TmpfsMountType, Destination: "/run", RW: true}},
+		{"type=tmpfs,target=/run,tmpfs-size=64m,tmpfs-mode=1777,readonly", MountInfo{Type: TmpfsMountType, Source: TmpfsMountType, Destination: "/run", Options: "size=64m,mode=1777,ro"}},
+	} {
+		mount, err := ParseMount(c.spec)
+		if err != nil {
+			t.Fatalf("parse mount %v error : %v", c.spec, err)
+		}
+		if !reflect.DeepEqual(*mount, c.want) {
+			t.Fatalf("mount %v => %+v, want %+v", c.spec, *mount, c.want)
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"type=nfs,target=/data",
+		"type=bind,source=host,target=/data",
+		"type=bind,target=/data",
+		"type=bind,source=/host,target=/data,bind-propagation=bad",
+		"type=volume,source=/host,target=/data",
+		"type=volume,source=db,target=/data,bind-propagation=rshared",
+		"type=bind,source=/host,target=/data,tmpfs-size=64m",
+		"type=tmpfs,source=tmp,target=/run",
+		"type=tmpfs,target=/run,tmpfs-mode=999",
+		"type=bind,source=/host,target=/data,readonly=maybe",
+		"type=bind,source=/host,target=data",
+		"type=volume,source=db,target=/",
+		"type=bind,source=/host,target=/data,consistency=cached",
+	} {
+		if _, err := ParseMount(spec); err == nil {
+			t.Fatalf("parse mount %v should fail", spec)
+		}
+	}
+}
+
+func TestParseVolumes(t *testing.T) {
+	mounts, err := ParseVolumes([]string{"/data", " ", "/host:/host"}, []string{"type=tmpfs,target=/run"})
+	if err != nil {
+		t.Fatalf("parse volumes error : %v", err)
+	}
+
+	var destinations []string
+	for _, mount := range mounts {
+		destinations = append(destinations, mount.Destination)
+	}
+	if !reflect.DeepEqual(destinations, []string{"/data", "/host", "/run"}) {
+		t.Fatalf("volumes destinations %v, want [/data /host /run]", destinations)
+	}
+
+	if _, err := ParseVolumes([]string{"/data"}, []string{"type=nfs,target=/nfs"}); err == nil {
+		t.Fatalf("parse volumes with invalid mount should fail")
+	}
+}
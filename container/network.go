@@ -27,7 +27,7 @@ func (nw *Network) Dump() error {
 	// os.O_CREATE 不存在则自动创建
 	nwFile, err := os.OpenFile(nwFilePath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
-		log.Errorf("Open network %v file in %v error：", nw.ID, NetFileDir, err)
+		log.Errorf("Open network %v file in %v error：%v", nw.ID, NetFileDir, err)
 		return err
 	}
 	defer nwFile.Close()
@@ -38,14 +38,14 @@ func (nw *Network) Dump() error {
 	nwInfoByte, err := json.MarshalIndent(nw, " ", "    ")
 
 	if err != nil {
-		log.Errorf("Marshal network info error：", err)
+		log.Errorf("Marshal network info error：%v", err)
 		return err
 	}
 
 	// 持久化数据
 	_, err = nwFile.Write(nwInfoByte)
 	if err != nil {
-		log.Errorf("Write network info in  error：", NetFileDir, err)
+		log.Errorf("Write network info in %v error：%v", NetFileDir, err)
 		return err
 	}
 	return nil
@@ -93,7 +93,7 @@ func (nw *Network) Load() error {
 	// 反序列化
 	err = json.Unmarshal(nwInfoByte[:n], nw)
 	if err != nil {
-		log.Errorf("Error load network %v info", nw.ID, err)
+		log.Errorf("Error load network %v info : %v", nw.ID, err)
 		return err
 	}
 
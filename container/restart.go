@@ -0,0 +1,111 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 重启策略
+const (
+	RestartPolicyNo            = "no"
+	RestartPolicyAlways        = "always"
+	RestartPolicyOnFailure     = "on-failure"
+	RestartPolicyUnlessStopped = "unless-stopped"
+)
+
+// 重启退避参数
+var (
+	// RestartBackoffMin 第一次重启前的等待时间, 之后每次翻倍
+	RestartBackoffMin = 100 * time.Millisecond
+	// RestartBackoffMax 最大等待时间
+	RestartBackoffMax = time.Minute
+	// RestartBackoffReset 容器运行超过该时间后，重新从 RestartBackoffMin 开始退避
+	RestartBackoffReset = 10 * time.Second
+)
+
+// RestartPolicy 容器重启策略 --restart no|always|on-failure[:N]|unless-stopped
+type RestartPolicy struct {
+	Name              string `json:"Name"`
+	MaximumRetryCount int    `json:"MaximumRetryCount"` // 仅 on-failure 有效, 0 为不限制
+}
+
+// ParseRestartPolicy 解析 --restart 参数
+func ParseRestartPolicy(policy string) (*RestartPolicy, error) {
+
+	policy = strings.TrimSpace(policy)
+
+	// 默认不重启
+	if policy == "" {
+		return &RestartPolicy{Name: RestartPolicyNo}, nil
+	}
+
+	// on-failure:N
+	policySlice := strings.SplitN(policy, ":", 2)
+	restartPolicy := &RestartPolicy{Name: policySlice[0]}
+
+	switch restartPolicy.Name {
+	case RestartPolicyNo, RestartPolicyAlways, RestartPolicyUnlessStopped:
+		if len(policySlice) == 2 {
+			return nil, fmt.Errorf("Maximum retry count can't be used with restart policy %v", restartPolicy.Name)
+		}
+	case RestartPolicyOnFailure:
+		if len(policySlice) == 2 {
+			count, err := strconv.Atoi(policySlice[1])
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("Invalid maximum retry count %v", policySlice[1])
+			}
+			restartPolicy.MaximumRetryCount = count
+		}
+	default:
+		return nil, fmt.Errorf("Invalid restart policy %v, use no|always|on-failure[:N]|unless-stopped", policy)
+	}
+
+	return restartPolicy, nil
+}
+
+// String 转化为 --restart 参数格式
+func (rp *RestartPolicy) String() string {
+	if rp == nil || rp.Name == "" {
+		return RestartPolicyNo
+	}
+	if rp.Name == RestartPolicyOnFailure && rp.MaximumRetryCount > 0 {
+		return fmt.Sprintf("%v:%v", rp.Name, rp.MaximumRetryCount)
+	}
+	return rp.Name
+}
+
+// ShouldRestart 容器退出后是否需要由 monitor 重启
+// stopped 为 true 表示容器由 qsrdocker stop 停止，任何策略都不重启
+func (rp *RestartPolicy) ShouldRestart(exitCode, restartCount int, stopped bool) bool {
+	if rp == nil || stopped {
+		return false
+	}
+
+	switch rp.Name {
+	case RestartPolicyAlways, RestartPolicyUnlessStopped:
+		return true
+	case RestartPolicyOnFailure:
+		if exitCode == 0 {
+			return false
+		}
+		return rp.MaximumRetryCount == 0 || restartCount < rp.MaximumRetryCount
+	}
+
+	return false
+}
+
+// RestartBackoff 计算下一次重启前的等待时间
+// 上一次等待时间翻倍, 容器运行超过 RestartBackoffReset 则重置
+func RestartBackoff(lastDelay, uptime time.Duration) time.Duration {
+	if lastDelay == 0 || uptime >= RestartBackoffReset {
+		return RestartBackoffMin
+	}
+
+	delay := lastDelay * 2
+	if delay > RestartBackoffMax {
+		delay = RestartBackoffMax
+	}
+	return delay
+}
@@ -0,0 +1,67 @@
+package container
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		wantName string
+		wantMax  int
+	}{
+		{"", RestartPolicyNo, 0},
+		{"no", RestartPolicyNo, 0},
+		{"always", RestartPolicyAlways, 0},
+		{"unless-stopped", RestartPolicyUnlessStopped, 0},
+		{"on-failure", RestartPolicyOnFailure, 0},
+		{"on-failure:3", RestartPolicyOnFailure, 3},
+	} {
+		rp, err := ParseRestartPolicy(c.input)
+		if err != nil {
+			t.Fatalf("parse restart policy %v error : %v", c.input, err)
+		}
+		if rp.Name != c.wantName || rp.MaximumRetryCount != c.wantMax {
+			t.Fatalf("restart policy %v => %v:%v, want %v:%v", c.input, rp.Name, rp.MaximumRetryCount, c.wantName, c.wantMax)
+		}
+	}
+
+	for _, policy := range []string{"sometimes", "always:3", "on-failure:-1", "on-failure:x"} {
+		if _, err := ParseRestartPolicy(policy); err == nil {
+			t.Fatalf("parse restart policy %v should fail", policy)
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	onFailure, _ := ParseRestartPolicy("on-failure:2")
+
+	if onFailure.ShouldRestart(0, 0, false) {
+		t.Fatalf("on-failure should not restart with exit code 0")
+	}
+	if !onFailure.ShouldRestart(1, 1, false) {
+		t.Fatalf("on-failure:2 should restart at count 1")
+	}
+	if onFailure.ShouldRestart(1, 2, false) {
+		t.Fatalf("on-failure:2 should not restart at count 2")
+	}
+
+	always, _ := ParseRestartPolicy("always")
+	if always.ShouldRestart(0, 100, true) {
+		t.Fatalf("stopped container should not restart")
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	delay := RestartBackoff(0, 0)
+	for i := 0; i < 20; i++ {
+		delay = RestartBackoff(delay, time.Second)
+	}
+	if delay != RestartBackoffMax {
+		t.Fatalf("backoff %v should be limit to %v", delay, RestartBackoffMax)
+	}
+	if RestartBackoff(delay, RestartBackoffReset) != RestartBackoffMin {
+		t.Fatalf("backoff should be reset")
+	}
+}
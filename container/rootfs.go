@@ -0,0 +1,271 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultMaskedPaths 容器内默认屏蔽的路径, 与 docker 相同
+// 文件 bind mount /dev/null, 目录挂载只读的空 tmpfs
+var DefaultMaskedPaths = []string{
+	"/proc/asound",
+	"/proc/acpi",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// DefaultReadonlyPaths 容器内默认只读的路径
+var DefaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// statfs 返回的挂载标志, 与 MS_XXX 不完全相同
+const (
+	stNoSuid     = 0x2
+	stNoDev      = 0x4
+	stNoExec     = 0x8
+	stNoAtime    = 0x400
+	stNoDirAtime = 0x800
+	stRelAtime   = 0x1000
+)
+
+// tmpfsDataOptions --tmpfs 支持的 tmpfs 参数
+var tmpfsDataOptions = []string{"size", "mode", "uid", "gid", "nr_inodes", "nr_blocks"}
+
+// tmpfsFlagOptions --tmpfs 支持的挂载标志, clear 为 true 时清除该标志
+var tmpfsFlagOptions = map[string]struct {
+	clear bool
+	flag  uintptr
+}{
+	"ro":     {false, syscall.MS_RDONLY},
+	"rw":     {true, syscall.MS_RDONLY},
+	"noexec": {false, syscall.MS_NOEXEC},
+	"exec":   {true, syscall.MS_NOEXEC},
+	"nosuid": {false, syscall.MS_NOSUID},
+	"suid":   {true, syscall.MS_NOSUID},
+	"nodev":  {false, syscall.MS_NODEV},
+	"dev":    {true, syscall.MS_NODEV},
+}
+
+// ParseTmpfs 解析 --tmpfs /path[:options], 记录为 tmpfs 类型的 MountInfo
+// options 如 size=64m,mode=1777, 默认 noexec nosuid nodev
+func ParseTmpfs(specs []string) ([]*MountInfo, error) {
+
+	var mounts []*MountInfo
+
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		destination := parts[0]
+		if !filepath.IsAbs(destination) || filepath.Clean(destination) == "/" {
+			return nil, fmt.Errorf("Invalid tmpfs %v, the path needs to be an absolute path other than /", spec)
+		}
+
+		options := ""
+		if len(parts) == 2 {
+			options = parts[1]
+		}
+
+		flags, _, err := tmpfsMountOptions(options)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid tmpfs %v, %v", spec, err)
+		}
+
+		mounts = append(mounts, &MountInfo{
+			Type:        TmpfsMountType,
+			Source:      TmpfsMountType,
+			Destination: filepath.Clean(destination),
+			RW:          flags&syscall.MS_RDONLY == 0,
+			Options:     options,
+		})
+	}
+
+	return mounts, nil
+}
+
+// tmpfsMountOptions 将 --tmpfs 的参数转换为挂载标志与 tmpfs 参数
+func tmpfsMountOptions(options string) (uintptr, string, error) {
+
+	flags := uintptr(syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV)
+	var data []string
+
+	for _, option := range strings.Split(options, ",") {
+		if option == "" {
+			continue
+		}
+
+		if flagOption, ok := tmpfsFlagOptions[option]; ok {
+			if flagOption.clear {
+				flags &^= flagOption.flag
+			} else {
+				flags |= flagOption.flag
+			}
+			continue
+		}
+
+		kv := strings.SplitN(option, "=", 2)
+		if len(kv) != 2 || kv[1] == "" || !containsString(tmpfsDataOptions, kv[0]) {
+			return 0, "", fmt.Errorf("unknown tmpfs option %v", option)
+		}
+
+		// mode 为八进制
+		if kv[0] == "mode" {
+			if _, err := strconv.ParseUint(kv[1], 8, 32); err != nil {
+				return 0, "", fmt.Errorf("invalid tmpfs mode %v", kv[1])
+			}
+		}
+
+		data = append(data, option)
+	}
+
+	return flags, strings.Join(data, ","), nil
+}
+
+// mountTmpfs 在 rootfs 中挂载 --tmpfs, 在 pivotRoot 之前执行
+func mountTmpfs(rootfs string, mount *MountInfo) error {
+
+	flags, data, err := tmpfsMountOptions(mount.Options)
+	if err != nil {
+		return err
+	}
+
+	dest := filepath.Join(rootfs, mount.Destination)
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("Mkdir %v error %v", dest, err)
+	}
+
+	if err := syscall.Mount("tmpfs", dest, "tmpfs", flags, data); err != nil {
+		return fmt.Errorf("Mount tmpfs %v error %v", mount.Destination, err)
+	}
+
+	log.Debugf("Mount tmpfs %v with %v success", mount.Destination, mount.Options)
+
+	return nil
+}
+
+// mountSysfs 挂载容器的 /sys, 在 pivotRoot 之前执行
+// 非 privileged 时只读; 不拥有 network namespace 时不能挂载 sysfs, 改为 bind mount 宿主机的 /sys
+func mountSysfs(rootfs string, privileged bool) error {
+
+	sysDir := filepath.Join(rootfs, "/sys")
+	if err := os.MkdirAll(sysDir, 0755); err != nil {
+		return fmt.Errorf("Mkdir %v error %v", sysDir, err)
+	}
+
+	flags := uintptr(syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV)
+	if !privileged {
+		flags |= syscall.MS_RDONLY
+	}
+
+	err := syscall.Mount("sysfs", sysDir, "sysfs", flags, "")
+	if err == nil {
+		return nil
+	}
+
+	if err != syscall.EPERM {
+		return fmt.Errorf("Mount sysfs error %v", err)
+	}
+
+	log.Debugf("Mount sysfs fail : %v, bind mount /sys from host", err)
+
+	if err := syscall.Mount("/sys", sysDir, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("Bind mount /sys error %v", err)
+	}
+
+	if privileged {
+		return nil
+	}
+
+	return remountReadOnly(sysDir)
+}
+
+// remountReadOnly 将 path 的挂载点重新挂载为只读
+// user namespace 中 nosuid nodev 等标志被锁定, 重新挂载时需要保留
+func remountReadOnly(path string) error {
+
+	stat := &syscall.Statfs_t{}
+	if err := syscall.Statfs(path, stat); err != nil {
+		return fmt.Errorf("Statfs %v error %v", path, err)
+	}
+
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	for stFlag, msFlag := range map[int64]uintptr{
+		stNoSuid:     syscall.MS_NOSUID,
+		stNoDev:      syscall.MS_NODEV,
+		stNoExec:     syscall.MS_NOEXEC,
+		stNoAtime:    syscall.MS_NOATIME,
+		stNoDirAtime: syscall.MS_NODIRATIME,
+		stRelAtime:   syscall.MS_RELATIME,
+	} {
+		if int64(stat.Flags)&stFlag != 0 {
+			flags |= msFlag
+		}
+	}
+
+	if err := syscall.Mount("", path, "", flags, ""); err != nil {
+		return fmt.Errorf("Remount %v read-only error %v", path, err)
+	}
+
+	return nil
+}
+
+// maskPaths 屏蔽容器内的路径, 在 pivotRoot 之后执行, 不存在的路径忽略
+func maskPaths(paths []string) error {
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Stat %v error %v", path, err)
+		}
+
+		if info.IsDir() {
+			err = syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_RDONLY, "")
+		} else {
+			err = syscall.Mount("/dev/null", path, "bind", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("Mask %v error %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+// readonlyPaths 将容器内的路径 bind mount 到自身后重新挂载为只读, 在 pivotRoot 之后执行
+func readonlyPaths(paths []string) error {
+
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := syscall.Mount(path, path, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("Bind mount %v error %v", path, err)
+		}
+
+		if err := remountReadOnly(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package container
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestParseTmpfs(t *testing.T) {
+	for _, c := range []struct {
+		spec string
+		want MountInfo
+	}{
+		{"/run", MountInfo{Type: TmpfsMountType, Source: TmpfsMountType, Destination: "/run", RW: true}},
+		{"/run/", MountInfo{Type: TmpfsMountType, Source: TmpfsMountType, Destination: "/run", RW: true}},
+		{"/run:size=64m,mode=1777", MountInfo{Type: TmpfsMountType, Source: TmpfsMountType, Destination: "/run", RW: true, Options: "size=64m,mode=1777"}},
+		{"/run:ro,size=64m", MountInfo{Type: TmpfsMountType, Source: TmpfsMountType, Destination: "/run", Options: "ro,size=64m"}},
+		{"/run:ro,rw", MountInfo{Type: TmpfsMountType, Source: TmpfsMountType, Destination: "/run", RW: true, Options: "ro,rw"}},
+	} {
+		mounts, err := ParseTmpfs([]string{c.spec})
+		if err != nil {
+			t.Fatalf("parse tmpfs %v error : %v", c.spec, err)
+		}
+		if len(mounts) != 1 || !reflect.DeepEqual(*mounts[0], c.want) {
+			t.Fatalf("tmpfs %v => %+v, want %+v", c.spec, mounts, c.want)
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"run",
+		"/",
+		"/run:bogus",
+		"/run:mode=999",
+		"/run:size=",
+	} {
+		if _, err := ParseTmpfs([]string{spec}); err == nil {
+			t.Fatalf("parse tmpfs %v should fail", spec)
+		}
+	}
+}
+
+func TestTmpfsMountOptions(t *testing.T) {
+	defaultFlags := uintptr(syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV)
+
+	for _, c := range []struct {
+		options string
+		flags   uintptr
+		data    string
+	}{
+		{"", defaultFlags, ""},
+		{"size=64m,mode=1777", defaultFlags, "size=64m,mode=1777"},
+		{"ro", defaultFlags | syscall.MS_RDONLY, ""},
+		{"exec,suid,dev", 0, ""},
+		{"exec,noexec", defaultFlags, ""},
+		{"uid=1000,gid=1000,,nr_inodes=1k,nr_blocks=10", defaultFlags, "uid=1000,gid=1000,nr_inodes=1k,nr_blocks=10"},
+	} {
+		flags, data, err := tmpfsMountOptions(c.options)
+		if err != nil {
+			t.Fatalf("tmpfs mount options %v error : %v", c.options, err)
+		}
+		if flags != c.flags || data != c.data {
+			t.Fatalf("tmpfs mount options %v => %#x %v, want %#x %v", c.options, flags, data, c.flags, c.data)
+		}
+	}
+
+	for _, options := range []string{"bogus", "size", "mode=", "mode=0x1ff", "mode=8", "nr_pages=10"} {
+		if _, _, err := tmpfsMountOptions(options); err == nil {
+			t.Fatalf("tmpfs mount options %v should fail", options)
+		}
+	}
+}
@@ -0,0 +1,537 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// --security-opt seccomp 的取值, 其他值为 profile 文件
+const (
+	SeccompProfileDefault    = "default"    // 内置的默认 profile
+	SeccompProfileUnconfined = "unconfined" // 不限制系统调用
+)
+
+// SeccompProfileFile 自定义 profile 保存在容器目录中
+var SeccompProfileFile string = "seccomp.json"
+
+// seccomp profile 中的动作, 与 docker 相同
+const (
+	SeccompActKill        = "SCMP_ACT_KILL"
+	SeccompActKillThread  = "SCMP_ACT_KILL_THREAD"
+	SeccompActKillProcess = "SCMP_ACT_KILL_PROCESS"
+	SeccompActTrap        = "SCMP_ACT_TRAP"
+	SeccompActErrno       = "SCMP_ACT_ERRNO"
+	SeccompActTrace       = "SCMP_ACT_TRACE"
+	SeccompActLog         = "SCMP_ACT_LOG"
+	SeccompActAllow       = "SCMP_ACT_ALLOW"
+)
+
+// seccomp profile 中参数的比较方式
+const (
+	SeccompCmpNotEqual     = "SCMP_CMP_NE"
+	SeccompCmpLessThan     = "SCMP_CMP_LT"
+	SeccompCmpLessEqual    = "SCMP_CMP_LE"
+	SeccompCmpEqualTo      = "SCMP_CMP_EQ"
+	SeccompCmpGreaterEqual = "SCMP_CMP_GE"
+	SeccompCmpGreaterThan  = "SCMP_CMP_GT"
+	SeccompCmpMaskedEqual  = "SCMP_CMP_MASKED_EQ"
+)
+
+// SeccompProfile docker 格式的 seccomp profile
+type SeccompProfile struct {
+	DefaultAction   string            `json:"defaultAction"`
+	DefaultErrnoRet *uint             `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string          `json:"architectures,omitempty"`
+	ArchMap         []*SeccompArchMap `json:"archMap,omitempty"`
+	Syscalls        []*SeccompSyscall `json:"syscalls"`
+}
+
+// SeccompArchMap 架构与其子架构
+type SeccompArchMap struct {
+	Architecture     string   `json:"architecture"`
+	SubArchitectures []string `json:"subArchitectures"`
+}
+
+// SeccompSyscall 一组系统调用的规则, 参数条件之间为与的关系
+type SeccompSyscall struct {
+	Name     string         `json:"name,omitempty"`
+	Names    []string       `json:"names,omitempty"`
+	Action   string         `json:"action"`
+	ErrnoRet *uint          `json:"errnoRet,omitempty"`
+	Args     []*SeccompArg  `json:"args,omitempty"`
+	Includes *SeccompFilter `json:"includes,omitempty"`
+	Excludes *SeccompFilter `json:"excludes,omitempty"`
+}
+
+// SeccompArg 系统调用参数条件
+// SCMP_CMP_MASKED_EQ 时 value 为掩码, valueTwo 为比较的值
+type SeccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo"`
+	Op       string `json:"op"`
+}
+
+// SeccompFilter 规则生效的条件, includes 需要全部满足, excludes 满足任意一个时不生效
+type SeccompFilter struct {
+	Arches    []string `json:"arches,omitempty"`
+	Caps      []string `json:"caps,omitempty"`
+	MinKernel string   `json:"minKernel,omitempty"`
+}
+
+// seccomp 返回值与 prctl 参数
+const (
+	seccompRetKillThread  = 0x00000000
+	seccompRetKillProcess = 0x80000000
+	seccompRetTrap        = 0x00030000
+	seccompRetErrno       = 0x00050000
+	seccompRetTrace       = 0x7ff00000
+	seccompRetLog         = 0x7ffc0000
+	seccompRetAllow       = 0x7fff0000
+	seccompRetDataMask    = 0x0000ffff
+
+	prSetSeccomp      = 22
+	seccompModeFilter = 2
+
+	// struct seccomp_data 中的偏移
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArgs = 16
+
+	// BPF_MAXINSNS
+	seccompMaxInsns = 4096
+)
+
+// ParseSecurityOpt 解析 --security-opt, 目前只支持 seccomp=unconfined|<profile 文件>
+// 返回 unconfined 或 profile 文件的内容, profile 由客户端读取后发送给 qsrdockerd
+func ParseSecurityOpt(securityOpts []string) (string, error) {
+
+	seccompProfile := ""
+
+	for _, opt := range securityOpts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[0] != "seccomp" || kv[1] == "" {
+			return "", fmt.Errorf("Invalid --security-opt %v, use seccomp=unconfined|<profile.json>", opt)
+		}
+
+		if kv[1] == SeccompProfileUnconfined {
+			seccompProfile = SeccompProfileUnconfined
+			continue
+		}
+
+		content, err := ioutil.ReadFile(kv[1])
+		if err != nil {
+			return "", fmt.Errorf("Read seccomp profile %v error %v", kv[1], err)
+		}
+		seccompProfile = string(content)
+	}
+
+	return seccompProfile, nil
+}
+
+// CheckSeccompProfile 创建容器前检查自定义 profile 能否生成过滤程序
+func CheckSeccompProfile(seccompProfile string, caps []string) error {
+
+	if seccompProfile == "" || seccompProfile == SeccompProfileUnconfined {
+		return nil
+	}
+
+	profile := &SeccompProfile{}
+	if err := json.Unmarshal([]byte(seccompProfile), profile); err != nil {
+		return fmt.Errorf("Json unmarshal seccomp profile error %v", err)
+	}
+
+	_, err := CompileSeccompProfile(profile, caps)
+	return err
+}
+
+// SaveSeccompProfile 保存容器的 seccomp 配置
+// 自定义 profile 写入容器目录, 返回 default unconfined 或 profile 文件路径
+func SaveSeccompProfile(containerID, seccompProfile string) (string, error) {
+
+	if seccompProfile == "" {
+		return SeccompProfileDefault, nil
+	}
+
+	if seccompProfile == SeccompProfileUnconfined {
+		return SeccompProfileUnconfined, nil
+	}
+
+	profilePath := path.Join(ContainerDir, containerID, SeccompProfileFile)
+	if err := ioutil.WriteFile(profilePath, []byte(seccompProfile), 0644); err != nil {
+		return "", fmt.Errorf("Write seccomp profile %v error %v", profilePath, err)
+	}
+
+	return profilePath, nil
+}
+
+// GetSeccompProfile 获取容器的 seccomp profile, unconfined 时返回 nil
+// 旧版本创建的容器没有记录, 使用默认的 profile
+func (containerInfo *ContainerInfo) GetSeccompProfile() (*SeccompProfile, error) {
+
+	switch containerInfo.Seccomp {
+	case SeccompProfileUnconfined:
+		return nil, nil
+	case "", SeccompProfileDefault:
+		if seccompNativeArch == 0 {
+			log.Warnf("Seccomp is not supported on this architecture, run container %v unconfined", containerInfo.ID)
+			return nil, nil
+		}
+		return DefaultSeccompProfile(), nil
+	}
+
+	content, err := ioutil.ReadFile(containerInfo.Seccomp)
+	if err != nil {
+		return nil, fmt.Errorf("Read seccomp profile %v error %v", containerInfo.Seccomp, err)
+	}
+
+	profile := &SeccompProfile{}
+	if err := json.Unmarshal(content, profile); err != nil {
+		return nil, fmt.Errorf("Json unmarshal seccomp profile %v error %v", containerInfo.Seccomp, err)
+	}
+
+	return profile, nil
+}
+
+// CompileSeccompProfile 根据 profile 与容器的 capability 生成 BPF 过滤程序
+// 1. 非本机架构与 x32 ABI 的系统调用直接杀死进程
+// 2. 规则按顺序匹配, 第一个匹配的规则决定返回值, 都不匹配时为 defaultAction
+// 3. 不认识的系统调用名称忽略, profile 中通常包含其他架构的系统调用
+func CompileSeccompProfile(profile *SeccompProfile, caps []string) ([]syscall.SockFilter, error) {
+
+	if seccompNativeArch == 0 {
+		return nil, fmt.Errorf("Seccomp is not supported on this architecture")
+	}
+
+	if arches := profileArches(profile); len(arches) > 0 && !containsString(arches, seccompArchName) {
+		return nil, fmt.Errorf("Seccomp profile doesn't support architecture %v", seccompArchName)
+	}
+
+	defaultRet, err := seccompActionRet(profile.DefaultAction, profile.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+
+	program := []syscall.SockFilter{
+		bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArch),
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, seccompNativeArch, 1, 0),
+		bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProcess),
+	}
+
+	if seccompX32SyscallBit != 0 {
+		program = append(program,
+			bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataNr),
+			bpfJump(syscall.BPF_JMP|syscall.BPF_JGE|syscall.BPF_K, seccompX32SyscallBit, 0, 1),
+			bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProcess),
+		)
+	}
+
+	for _, rule := range profile.Syscalls {
+
+		if !seccompRuleEnabled(rule, caps) {
+			continue
+		}
+
+		ret, err := seccompActionRet(rule.Action, rule.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+
+		names := rule.Names
+		if rule.Name != "" {
+			names = append([]string{rule.Name}, names...)
+		}
+
+		for _, name := range names {
+			nr, ok := syscallTable[name]
+			if !ok {
+				log.Debugf("Seccomp skip unknown syscall %v", name)
+				continue
+			}
+
+			block, err := seccompRuleBlock(nr, rule.Args, ret)
+			if err != nil {
+				return nil, fmt.Errorf("Seccomp syscall %v error %v", name, err)
+			}
+			program = append(program, block...)
+		}
+	}
+
+	program = append(program, bpfStmt(syscall.BPF_RET|syscall.BPF_K, defaultRet))
+
+	if len(program) > seccompMaxInsns {
+		return nil, fmt.Errorf("Seccomp program has %v instructions, more than %v", len(program), seccompMaxInsns)
+	}
+
+	return program, nil
+}
+
+// 规则块中的跳转目标
+const (
+	jumpNext = iota // 下一条指令
+	jumpPass        // 当前参数条件满足, 检查下一个条件
+	jumpFail        // 规则不匹配, 跳到下一个规则块
+)
+
+// bpfInsn 跳转目标未确定的指令
+type bpfInsn struct {
+	code   uint16
+	k      uint32
+	jt, jf int
+}
+
+// seccompRuleBlock 生成一个系统调用规则的指令块
+// 规则不匹配时跳到块的末尾, 即下一个规则块, 跳转距离不会超过 255
+func seccompRuleBlock(nr uint32, args []*SeccompArg, ret uint32) ([]syscall.SockFilter, error) {
+
+	insns := []bpfInsn{
+		{code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, k: seccompDataNr},
+		{code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, k: nr, jt: jumpNext, jf: jumpFail},
+	}
+	// 每个参数条件的结束位置
+	passTargets := []int{0, 0}
+
+	for _, arg := range args {
+		cond, err := seccompArgInsns(arg)
+		if err != nil {
+			return nil, err
+		}
+		end := len(insns) + len(cond)
+		for range cond {
+			passTargets = append(passTargets, end)
+		}
+		insns = append(insns, cond...)
+	}
+
+	insns = append(insns, bpfInsn{code: syscall.BPF_RET | syscall.BPF_K, k: ret})
+	passTargets = append(passTargets, 0)
+
+	blockEnd := len(insns)
+	block := make([]syscall.SockFilter, 0, blockEnd)
+
+	for i, insn := range insns {
+		target := func(jump int) uint8 {
+			switch jump {
+			case jumpPass:
+				return uint8(passTargets[i] - i - 1)
+			case jumpFail:
+				return uint8(blockEnd - i - 1)
+			}
+			return 0
+		}
+		block = append(block, syscall.SockFilter{Code: insn.code, Jt: target(insn.jt), Jf: target(insn.jf), K: insn.k})
+	}
+
+	return block, nil
+}
+
+// seccompArgInsns 比较 64 位参数, 分别比较高 32 位与低 32 位
+func seccompArgInsns(arg *SeccompArg) ([]bpfInsn, error) {
+
+	if arg.Index > 5 {
+		return nil, fmt.Errorf("invalid argument index %v", arg.Index)
+	}
+
+	// 小端序, 低 32 位在前
+	lowOffset := uint32(seccompDataArgs + 8*arg.Index)
+	highOffset := lowOffset + 4
+	loadHigh := bpfInsn{code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, k: highOffset}
+	loadLow := bpfInsn{code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, k: lowOffset}
+
+	high, low := uint32(arg.Value>>32), uint32(arg.Value)
+
+	jeq := uint16(syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K)
+	jgt := uint16(syscall.BPF_JMP | syscall.BPF_JGT | syscall.BPF_K)
+	jge := uint16(syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K)
+
+	switch arg.Op {
+	case SeccompCmpEqualTo:
+		return []bpfInsn{
+			loadHigh, {code: jeq, k: high, jt: jumpNext, jf: jumpFail},
+			loadLow, {code: jeq, k: low, jt: jumpNext, jf: jumpFail},
+		}, nil
+	case SeccompCmpNotEqual:
+		return []bpfInsn{
+			loadHigh, {code: jeq, k: high, jt: jumpNext, jf: jumpPass},
+			loadLow, {code: jeq, k: low, jt: jumpFail, jf: jumpNext},
+		}, nil
+	case SeccompCmpMaskedEqual:
+		and := uint16(syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K)
+		return []bpfInsn{
+			loadHigh, {code: and, k: high}, {code: jeq, k: uint32(arg.ValueTwo >> 32), jt: jumpNext, jf: jumpFail},
+			loadLow, {code: and, k: low}, {code: jeq, k: uint32(arg.ValueTwo), jt: jumpNext, jf: jumpFail},
+		}, nil
+	case SeccompCmpGreaterThan, SeccompCmpGreaterEqual:
+		lowJump := jgt
+		if arg.Op == SeccompCmpGreaterEqual {
+			lowJump = jge
+		}
+		return []bpfInsn{
+			loadHigh, {code: jgt, k: high, jt: jumpPass, jf: jumpNext}, {code: jeq, k: high, jt: jumpNext, jf: jumpFail},
+			loadLow, {code: lowJump, k: low, jt: jumpNext, jf: jumpFail},
+		}, nil
+	case SeccompCmpLessThan, SeccompCmpLessEqual:
+		lowJump := jge
+		if arg.Op == SeccompCmpLessEqual {
+			lowJump = jgt
+		}
+		return []bpfInsn{
+			loadHigh, {code: jgt, k: high, jt: jumpFail, jf: jumpNext}, {code: jeq, k: high, jt: jumpNext, jf: jumpPass},
+			loadLow, {code: lowJump, k: low, jt: jumpFail, jf: jumpNext},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("invalid argument op %v", arg.Op)
+}
+
+// seccompActionRet profile 中的动作转换为 seccomp 返回值
+func seccompActionRet(action string, errnoRet *uint) (uint32, error) {
+
+	data := func(defaultData uint) uint32 {
+		if errnoRet != nil {
+			return uint32(*errnoRet) & seccompRetDataMask
+		}
+		return uint32(defaultData)
+	}
+
+	switch action {
+	case SeccompActKill, SeccompActKillThread:
+		return seccompRetKillThread, nil
+	case SeccompActKillProcess:
+		return seccompRetKillProcess, nil
+	case SeccompActTrap:
+		return seccompRetTrap, nil
+	case SeccompActErrno:
+		return seccompRetErrno | data(uint(syscall.EPERM)), nil
+	case SeccompActTrace:
+		return seccompRetTrace | data(0), nil
+	case SeccompActLog:
+		return seccompRetLog, nil
+	case SeccompActAllow:
+		return seccompRetAllow, nil
+	}
+
+	return 0, fmt.Errorf("Seccomp action %v is not supported", action)
+}
+
+// seccompRuleEnabled 根据 includes excludes 判断规则是否生效
+func seccompRuleEnabled(rule *SeccompSyscall, caps []string) bool {
+
+	if includes := rule.Includes; includes != nil {
+		if len(includes.Arches) > 0 && !containsString(includes.Arches, seccompArchName) {
+			return false
+		}
+		for _, capability := range includes.Caps {
+			if !containsString(caps, capability) {
+				return false
+			}
+		}
+		if includes.MinKernel != "" && !kernelAtLeast(includes.MinKernel) {
+			return false
+		}
+	}
+
+	if excludes := rule.Excludes; excludes != nil {
+		if containsString(excludes.Arches, seccompArchName) {
+			return false
+		}
+		for _, capability := range excludes.Caps {
+			if containsString(caps, capability) {
+				return false
+			}
+		}
+		if excludes.MinKernel != "" && kernelAtLeast(excludes.MinKernel) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// profileArches profile 支持的架构, 包括 archMap 中的架构
+func profileArches(profile *SeccompProfile) []string {
+	arches := append([]string{}, profile.Architectures...)
+	for _, archMap := range profile.ArchMap {
+		arches = append(arches, archMap.Architecture)
+		arches = append(arches, archMap.SubArchitectures...)
+	}
+	return arches
+}
+
+// kernelAtLeast 当前内核版本是否不低于 major.minor
+func kernelAtLeast(minKernel string) bool {
+
+	var uname syscall.Utsname
+	if err := syscall.Uname(&uname); err != nil {
+		return false
+	}
+
+	var release strings.Builder
+	for _, c := range uname.Release {
+		if c == 0 {
+			break
+		}
+		release.WriteByte(byte(c))
+	}
+
+	current, want := parseKernelVersion(release.String()), parseKernelVersion(minKernel)
+	if current[0] != want[0] {
+		return current[0] > want[0]
+	}
+	return current[1] >= want[1]
+}
+
+// parseKernelVersion 解析 5.10.0-xxx 中的主版本号与次版本号
+func parseKernelVersion(version string) [2]int {
+	var result [2]int
+	for i, field := range strings.SplitN(version, ".", 3) {
+		if i > 1 {
+			break
+		}
+		digits := strings.TrimRightFunc(field, func(r rune) bool { return r < '0' || r > '9' })
+		result[i], _ = strconv.Atoi(digits)
+	}
+	return result
+}
+
+// containsString 判断 slice 中是否存在 s
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func bpfStmt(code uint16, k uint32) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// setSeccomp 为当前线程安装过滤程序, exec 后的进程继承
+// 需要 no_new_privs 或 CAP_SYS_ADMIN, 调用前需要 runtime.LockOSThread
+func setSeccomp(program []syscall.SockFilter) error {
+
+	prog := syscall.SockFprog{
+		Len:    uint16(len(program)),
+		Filter: &program[0],
+	}
+
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&prog)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("Set seccomp filter error %v", errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package container
+
+import (
+	"syscall"
+)
+
+// cloneNamespaceFlags clone 创建 namespace 的标志
+// CLONE_NEWNS CLONE_NEWCGROUP CLONE_NEWUTS CLONE_NEWIPC CLONE_NEWUSER CLONE_NEWPID CLONE_NEWNET
+const cloneNamespaceFlags = 0x7e020000
+
+// DefaultSeccompProfile 内置的默认 profile
+// 默认允许所有系统调用, 禁止修改内核与宿主机状态的系统调用, 返回 EPERM
+// 容器拥有对应的 capability 时不禁止, 例如 --cap-add SYS_ADMIN 后可以 mount
+func DefaultSeccompProfile() *SeccompProfile {
+
+	errnoNoSys := uint(syscall.ENOSYS)
+
+	return &SeccompProfile{
+		DefaultAction: SeccompActAllow,
+		Syscalls: []*SeccompSyscall{
+			// 任何情况下都禁止
+			{
+				Names: []string{
+					"add_key", "keyctl", "request_key",
+					"create_module", "get_kernel_syms", "query_module",
+					"nfsservctl", "_sysctl", "sysfs", "uselib", "ustat",
+					"vm86", "vm86old",
+				},
+				Action: SeccompActErrno,
+			},
+			// 创建 namespace
+			{
+				Names:    []string{"clone"},
+				Action:   SeccompActAllow,
+				Args:     []*SeccompArg{{Index: 0, Value: cloneNamespaceFlags, ValueTwo: 0, Op: SeccompCmpMaskedEqual}},
+				Excludes: &SeccompFilter{Caps: []string{"CAP_SYS_ADMIN"}},
+			},
+			{
+				Names:    []string{"clone"},
+				Action:   SeccompActErrno,
+				Excludes: &SeccompFilter{Caps: []string{"CAP_SYS_ADMIN"}},
+			},
+			// clone3 的参数在内存中无法检查, 返回 ENOSYS 使 glibc 回退到 clone
+			{
+				Names:    []string{"clone3"},
+				Action:   SeccompActErrno,
+				ErrnoRet: &errnoNoSys,
+				Excludes: &SeccompFilter{Caps: []string{"CAP_SYS_ADMIN"}},
+			},
+			// 挂载与 namespace
+			{
+				Names: []string{
+					"mount", "umount", "umount2", "pivot_root",
+					"fsopen", "fsconfig", "fsmount", "fspick", "move_mount", "open_tree", "mount_setattr",
+					"setns", "unshare",
+					"bpf", "perf_event_open", "fanotify_init", "lookup_dcookie", "quotactl", "quotactl_fd",
+					"name_to_handle_at", "swapon", "swapoff",
+				},
+				Action:   SeccompActErrno,
+				Excludes: &SeccompFilter{Caps: []string{"CAP_SYS_ADMIN"}},
+			},
+			{
+				Names:    []string{"open_by_handle_at"},
+				Action:   SeccompActErrno,
+				Excludes: &SeccompFilter{Caps: []string{"CAP_DAC_READ_SEARCH"}},
+			},
+			// 内核模块与重启
+			{
+				Names:    []string{"init_module", "finit_module", "delete_module"},
+				Action:   SeccompActErrno,
+				Excludes: &SeccompFilter{Caps: []string{"CAP_SYS_MODULE"}},
+			},
+			{
+				Names:    []string{"kexec_load", "kexec_file_load", "reboot"},
+				Action:   SeccompActErrno,
+				Excludes: &SeccompFilter{Caps: []string{"CAP_SYS_BOOT"}},
+			},
+			// 调试其他进程
+			{
+				Names:    []string{"ptrace", "process_vm_readv", "process_vm_writev", "kcmp", "pidfd_getfd", "userfaultfd"},
+				Action:   SeccompActErrno,
+				Excludes: &SeccompFilter{Caps: []string{"CAP_SYS_PTRACE"}},
+			},
+			// 系统时间
+			{
+				Names:    []string{"settimeofday", "stime", "clock_settime", "clock_settime64", "clock_adjtime", "clock_adjtime64"},
+				Action:   SeccompActErrno,
+				Excludes: &SeccompFilter{Caps: []string{"CAP_SYS_TIME"}},
+			},
+			{
+				Names:    []string{"acct"},
+				Action:   SeccompActErrno,
+				Excludes: &SeccompFilter{Caps: []string{"CAP_SYS_PACCT"}},
+			},
+			{
+				Names:    []string{"iopl", "ioperm"},
+				Action:   SeccompActErrno,
+				Excludes: &SeccompFilter{Caps: []string{"CAP_SYS_RAWIO"}},
+			},
+			{
+				Names:    []string{"syslog"},
+				Action:   SeccompActErrno,
+				Excludes: &SeccompFilter{Caps: []string{"CAP_SYSLOG"}},
+			},
+			// NUMA 内存策略
+			{
+				Names:    []string{"get_mempolicy", "set_mempolicy", "mbind", "move_pages", "set_mempolicy_home_node"},
+				Action:   SeccompActErrno,
+				Excludes: &SeccompFilter{Caps: []string{"CAP_SYS_NICE"}},
+			},
+		},
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package container
+
+// 其他架构没有系统调用编号表, 不支持 seccomp
+const (
+	seccompNativeArch    = 0
+	seccompArchName      = ""
+	seccompX32SyscallBit = 0
+)
+
+// syscallTable 系统调用编号
+var syscallTable = map[string]uint32{}
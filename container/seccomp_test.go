@@ -0,0 +1,135 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+// 安装过滤程序后无法恢复, 在子进程中运行 seccompChildTests
+const seccompChildEnv = "QSRDOCKER_SECCOMP_TEST"
+
+var seccompChildTests = map[string]func() error{
+	"default": func() error {
+		if err := installSeccompProfile(DefaultSeccompProfile(), DefaultCapabilities); err != nil {
+			return err
+		}
+
+		for name, nr := range map[string]uintptr{"keyctl": syscall.SYS_KEYCTL, "unshare": syscall.SYS_UNSHARE, "ptrace": syscall.SYS_PTRACE} {
+			if _, _, errno := syscall.RawSyscall(nr, 0, 0, 0); errno != syscall.EPERM {
+				return fmt.Errorf("%v should return EPERM, got %v", name, errno)
+			}
+		}
+
+		// clone 创建 namespace 被禁止
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_CLONE, syscall.CLONE_NEWUSER, 0, 0); errno != syscall.EPERM {
+			return fmt.Errorf("clone with CLONE_NEWUSER should return EPERM, got %v", errno)
+		}
+
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_GETPID, 0, 0, 0); errno != 0 {
+			return fmt.Errorf("getpid should be allowed, got %v", errno)
+		}
+		return nil
+	},
+	"args": func() error {
+		profile := &SeccompProfile{}
+		if err := json.Unmarshal([]byte(`{
+			"defaultAction": "SCMP_ACT_ALLOW",
+			"architectures": ["SCMP_ARCH_X86_64", "SCMP_ARCH_AARCH64"],
+			"syscalls": [
+				{"names": ["getpriority"], "action": "SCMP_ACT_ERRNO", "errnoRet": 13,
+				 "args": [{"index": 1, "value": 100, "op": "SCMP_CMP_GT"}]},
+				{"names": ["getpriority"], "action": "SCMP_ACT_ERRNO",
+				 "args": [{"index": 1, "value": 4294967295, "op": "SCMP_CMP_LE"}, {"index": 1, "value": 50, "op": "SCMP_CMP_NE"}]}
+			]
+		}`), profile); err != nil {
+			return err
+		}
+
+		if err := installSeccompProfile(profile, nil); err != nil {
+			return err
+		}
+
+		for _, c := range []struct {
+			who   uint64
+			errno syscall.Errno
+		}{{101, syscall.EACCES}, {1 << 33, syscall.EACCES}, {100, syscall.EPERM}, {0, syscall.EPERM}} {
+			if _, _, errno := syscall.RawSyscall(syscall.SYS_GETPRIORITY, syscall.PRIO_PROCESS, uintptr(c.who), 0); errno != c.errno {
+				return fmt.Errorf("getpriority who %v should return %v, got %v", c.who, c.errno, errno)
+			}
+		}
+
+		// who 为 50 时两条规则都不匹配
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_GETPRIORITY, syscall.PRIO_PROCESS, 50, 0); errno == syscall.EPERM || errno == syscall.EACCES {
+			return fmt.Errorf("getpriority who 50 should be allowed, got %v", errno)
+		}
+		return nil
+	},
+}
+
+// installSeccompProfile 与 init 进程相同, 设置 no_new_privs 后安装过滤程序
+func installSeccompProfile(profile *SeccompProfile, caps []string) error {
+	program, err := CompileSeccompProfile(profile, caps)
+	if err != nil {
+		return err
+	}
+
+	runtime.LockOSThread()
+	if err := setNoNewPrivileges(); err != nil {
+		return err
+	}
+	return setSeccomp(program)
+}
+
+func TestMain(m *testing.M) {
+	if name := os.Getenv(seccompChildEnv); name != "" {
+		if err := seccompChildTests[name](); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestSeccompFilter(t *testing.T) {
+	if seccompNativeArch == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+
+	for name := range seccompChildTests {
+		cmd := exec.Command(os.Args[0])
+		cmd.Env = append(os.Environ(), seccompChildEnv+"="+name)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("seccomp test %v error %v : %s", name, err, output)
+		}
+	}
+}
+
+func TestCompileSeccompProfile(t *testing.T) {
+	if seccompNativeArch == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+
+	for _, profile := range []*SeccompProfile{
+		{DefaultAction: "SCMP_ACT_NOTIFY"},
+		{DefaultAction: SeccompActAllow, Architectures: []string{"SCMP_ARCH_S390X"}},
+		{DefaultAction: SeccompActAllow, Syscalls: []*SeccompSyscall{{Names: []string{"read"}, Action: SeccompActErrno, Args: []*SeccompArg{{Index: 6, Op: SeccompCmpEqualTo}}}}},
+		{DefaultAction: SeccompActAllow, Syscalls: []*SeccompSyscall{{Names: []string{"read"}, Action: SeccompActErrno, Args: []*SeccompArg{{Index: 0, Op: "SCMP_CMP_XX"}}}}},
+	} {
+		if _, err := CompileSeccompProfile(profile, nil); err == nil {
+			t.Fatalf("compile seccomp profile %+v should fail", profile)
+		}
+	}
+
+	// 拥有 CAP_SYS_ADMIN 时不禁止 mount
+	withoutAdmin, _ := CompileSeccompProfile(DefaultSeccompProfile(), DefaultCapabilities)
+	withAdmin, _ := CompileSeccompProfile(DefaultSeccompProfile(), append([]string{"CAP_SYS_ADMIN"}, DefaultCapabilities...))
+	if len(withAdmin) >= len(withoutAdmin) {
+		t.Fatalf("CAP_SYS_ADMIN should exclude rules, %v >= %v instructions", len(withAdmin), len(withoutAdmin))
+	}
+}
@@ -0,0 +1,86 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DefaultStopSignal qsrdocker stop 默认发送的信号
+const DefaultStopSignal = "SIGTERM"
+
+// signalMap 信号名称与信号的映射
+var signalMap = map[string]syscall.Signal{
+	"ABRT":   syscall.SIGABRT,
+	"ALRM":   syscall.SIGALRM,
+	"BUS":    syscall.SIGBUS,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"FPE":    syscall.SIGFPE,
+	"HUP":    syscall.SIGHUP,
+	"ILL":    syscall.SIGILL,
+	"INT":    syscall.SIGINT,
+	"IO":     syscall.SIGIO,
+	"KILL":   syscall.SIGKILL,
+	"PIPE":   syscall.SIGPIPE,
+	"PROF":   syscall.SIGPROF,
+	"PWR":    syscall.SIGPWR,
+	"QUIT":   syscall.SIGQUIT,
+	"SEGV":   syscall.SIGSEGV,
+	"STKFLT": syscall.SIGSTKFLT,
+	"STOP":   syscall.SIGSTOP,
+	"SYS":    syscall.SIGSYS,
+	"TERM":   syscall.SIGTERM,
+	"TRAP":   syscall.SIGTRAP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"USR1":   syscall.SIGUSR1,
+	"USR2":   syscall.SIGUSR2,
+	"VTALRM": syscall.SIGVTALRM,
+	"WINCH":  syscall.SIGWINCH,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+}
+
+// ParseSignal 解析信号，支持 SIGTERM TERM 15 三种格式
+func ParseSignal(signal string) (syscall.Signal, error) {
+
+	signal = strings.ToUpper(strings.TrimSpace(signal))
+
+	// 数字格式
+	if signalNum, err := strconv.Atoi(signal); err == nil {
+		if signalNum <= 0 || signalNum > 64 {
+			return 0, fmt.Errorf("Invalid signal %v", signal)
+		}
+		return syscall.Signal(signalNum), nil
+	}
+
+	if sig, ok := signalMap[strings.TrimPrefix(signal, "SIG")]; ok {
+		return sig, nil
+	}
+
+	return 0, fmt.Errorf("Invalid signal %v", signal)
+}
+
+// SignalName 获取信号名称，如 SIGTERM, 不在 signalMap 中的信号返回数字
+func SignalName(sig syscall.Signal) string {
+	for name, s := range signalMap {
+		if s == sig {
+			return strings.Join([]string{"SIG", name}, "")
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// GetStopSignal 获取容器的停止信号，未设置时为 SIGTERM
+func (containerInfo *ContainerInfo) GetStopSignal() syscall.Signal {
+	if containerInfo.StopSignal != "" {
+		if sig, err := ParseSignal(containerInfo.StopSignal); err == nil {
+			return sig
+		}
+	}
+	return syscall.SIGTERM
+}
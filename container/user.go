@@ -0,0 +1,198 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ExecUser 容器内运行用户, 由 -u/--user 根据镜像的 /etc/passwd 与 /etc/group 解析
+type ExecUser struct {
+	Uid   int
+	Gid   int
+	Sgids []int  // 附加组
+	Home  string // 用户主目录, 未设置 HOME 时使用
+}
+
+// passwdEntry /etc/passwd 中的一行 name:password:uid:gid:gecos:home:shell
+type passwdEntry struct {
+	name string
+	uid  int
+	gid  int
+	home string
+}
+
+// groupEntry /etc/group 中的一行 name:password:gid:user1,user2
+type groupEntry struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// GetExecUser 解析 user[:group], user 与 group 可以是名称或数字 ID
+// 名称必须存在于 passwd / group 文件中, 数字 ID 不存在时 gid 为 0 , HOME 为 /
+// 未指定 group 时附加组为 group 文件中包含该用户的组
+func GetExecUser(userSpec, passwdPath, groupPath string) (*ExecUser, error) {
+
+	execUser := &ExecUser{Home: "/"}
+
+	if userSpec == "" {
+		userSpec = "0"
+	}
+
+	userArg, groupArg := userSpec, ""
+	if idx := strings.Index(userSpec, ":"); idx >= 0 {
+		userArg, groupArg = userSpec[:idx], userSpec[idx+1:]
+	}
+
+	if userArg == "" {
+		return nil, fmt.Errorf("Invalid user %v", userSpec)
+	}
+
+	passwdEntries, err := parsePasswdFile(passwdPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// 用户
+	uid, uidErr := strconv.Atoi(userArg)
+	found := false
+	for _, entry := range passwdEntries {
+		if (uidErr == nil && entry.uid == uid) || (uidErr != nil && entry.name == userArg) {
+			execUser.Uid = entry.uid
+			execUser.Gid = entry.gid
+			execUser.Home = entry.home
+			userArg = entry.name
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		if uidErr != nil {
+			return nil, fmt.Errorf("Unable to find user %v: no matching entries in passwd file", userArg)
+		}
+		if uid < 0 {
+			return nil, fmt.Errorf("Invalid user %v", userSpec)
+		}
+		execUser.Uid = uid
+	}
+
+	groupEntries, err := parseGroupFile(groupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// 指定 group 时只使用该组, 不设置附加组
+	if groupArg != "" {
+		gid, gidErr := strconv.Atoi(groupArg)
+		if gidErr == nil {
+			if gid < 0 {
+				return nil, fmt.Errorf("Invalid group %v", groupArg)
+			}
+			execUser.Gid = gid
+			return execUser, nil
+		}
+
+		for _, entry := range groupEntries {
+			if entry.name == groupArg {
+				execUser.Gid = entry.gid
+				return execUser, nil
+			}
+		}
+
+		return nil, fmt.Errorf("Unable to find group %v: no matching entries in group file", groupArg)
+	}
+
+	// 附加组
+	for _, entry := range groupEntries {
+		if entry.gid == execUser.Gid {
+			continue
+		}
+		for _, member := range entry.members {
+			if member == userArg {
+				execUser.Sgids = append(execUser.Sgids, entry.gid)
+				break
+			}
+		}
+	}
+
+	return execUser, nil
+}
+
+// parsePasswdFile 解析 passwd 文件, 文件不存在时返回空
+func parsePasswdFile(passwdPath string) ([]*passwdEntry, error) {
+
+	var entries []*passwdEntry
+
+	err := scanColonFile(passwdPath, func(fields []string) {
+		if len(fields) < 6 {
+			return
+		}
+
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return
+		}
+
+		entries = append(entries, &passwdEntry{name: fields[0], uid: uid, gid: gid, home: fields[5]})
+	})
+
+	return entries, err
+}
+
+// parseGroupFile 解析 group 文件, 文件不存在时返回空
+func parseGroupFile(groupPath string) ([]*groupEntry, error) {
+
+	var entries []*groupEntry
+
+	err := scanColonFile(groupPath, func(fields []string) {
+		if len(fields) < 3 {
+			return
+		}
+
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+
+		entry := &groupEntry{name: fields[0], gid: gid}
+		if len(fields) > 3 && fields[3] != "" {
+			entry.members = strings.Split(fields[3], ",")
+		}
+
+		entries = append(entries, entry)
+	})
+
+	return entries, err
+}
+
+// scanColonFile 按行读取以 : 分隔的文件, 跳过空行与注释
+func scanColonFile(filePath string, handle func(fields []string)) error {
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("Open %v error %v", filePath, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		handle(strings.Split(line, ":"))
+	}
+
+	return scanner.Err()
+}
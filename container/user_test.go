@@ -0,0 +1,86 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestGetExecUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	passwdFile := path.Join(dir, "passwd")
+	passwd := "# comment\n" +
+		"root:x:0:0:root:/root:/bin/sh\n" +
+		"\n" +
+		"alice:x:1000:1000::/home/alice:/bin/sh\n" +
+		"broken:x:abc:1000::/home/broken:/bin/sh\n" +
+		"short:x:1001\n"
+	if err := ioutil.WriteFile(passwdFile, []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	groupFile := path.Join(dir, "group")
+	group := "root:x:0:\n" +
+		"alice:x:1000:alice\n" +
+		"wheel:x:10:root,alice\n" +
+		"audio:x:29:alice\n" +
+		"video:x:44:bob\n"
+	if err := ioutil.WriteFile(groupFile, []byte(group), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		spec string
+		want ExecUser
+	}{
+		{"", ExecUser{Uid: 0, Gid: 0, Sgids: []int{10}, Home: "/root"}},
+		{"root", ExecUser{Uid: 0, Gid: 0, Sgids: []int{10}, Home: "/root"}},
+		{"alice", ExecUser{Uid: 1000, Gid: 1000, Sgids: []int{10, 29}, Home: "/home/alice"}},
+		{"1000", ExecUser{Uid: 1000, Gid: 1000, Sgids: []int{10, 29}, Home: "/home/alice"}},
+		{"alice:audio", ExecUser{Uid: 1000, Gid: 29, Home: "/home/alice"}},
+		{"alice:44", ExecUser{Uid: 1000, Gid: 44, Home: "/home/alice"}},
+		{"2000", ExecUser{Uid: 2000, Gid: 0, Home: "/"}},
+		{"2000:2000", ExecUser{Uid: 2000, Gid: 2000, Home: "/"}},
+		{"1001", ExecUser{Uid: 1001, Gid: 0, Home: "/"}},
+	} {
+		execUser, err := GetExecUser(c.spec, passwdFile, groupFile)
+		if err != nil {
+			t.Fatalf("get exec user %v error : %v", c.spec, err)
+		}
+		if !reflect.DeepEqual(*execUser, c.want) {
+			t.Fatalf("exec user %v => %+v, want %+v", c.spec, *execUser, c.want)
+		}
+	}
+
+	for _, spec := range []string{
+		":0",
+		"bob",
+		"broken",
+		"-1",
+		"alice:nogroup",
+		"alice:-1",
+	} {
+		if _, err := GetExecUser(spec, passwdFile, groupFile); err == nil {
+			t.Fatalf("get exec user %v should fail", spec)
+		}
+	}
+
+	// 镜像中没有 passwd / group 文件时只接受数字 ID
+	execUser, err := GetExecUser("1000:1000", path.Join(dir, "nopasswd"), path.Join(dir, "nogroup"))
+	if err != nil {
+		t.Fatalf("get exec user without passwd file error : %v", err)
+	}
+	if !reflect.DeepEqual(*execUser, ExecUser{Uid: 1000, Gid: 1000, Home: "/"}) {
+		t.Fatalf("exec user without passwd file => %+v", *execUser)
+	}
+	if _, err := GetExecUser("root", path.Join(dir, "nopasswd"), path.Join(dir, "nogroup")); err == nil {
+		t.Fatalf("get exec user by name without passwd file should fail")
+	}
+}
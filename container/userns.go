@@ -0,0 +1,380 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// --userns 用户命名空间模式
+const (
+	UsernsModeAuto   = "auto"    // 容器 root 映射为当前用户, 其余 ID 映射到 /etc/subuid /etc/subgid 中的范围
+	UsernsModeHost   = "host"    // 不创建 user namespace, 与宿主机使用相同的 ID
+	UsernsModeKeepID = "keep-id" // 当前用户在容器内保持相同的 ID, 容器 root 映射到从属 ID
+)
+
+// 从属 ID 文件与容器内可用的 ID 数量
+var (
+	SubUIDFile string = "/etc/subuid"
+	SubGIDFile string = "/etc/subgid"
+	IDMapSize  int    = 65536
+)
+
+// IDMap 容器 ID 到宿主机 ID 的一段映射, 与 /proc/[pid]/uid_map 的一行相同
+type IDMap struct {
+	ContainerID int `json:"ContainerID"`
+	HostID      int `json:"HostID"`
+	Size        int `json:"Size"`
+}
+
+// ParseUsernsMode 解析 --userns, 为空时为 auto
+func ParseUsernsMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return UsernsModeAuto, nil
+	case UsernsModeAuto, UsernsModeHost, UsernsModeKeepID:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("Invalid userns mode %v, use host|auto|keep-id", mode)
+	}
+}
+
+// GetIDMappings 根据 --userns 与当前用户的从属 ID 范围生成 uid gid 映射
+// host 模式返回 nil
+func GetIDMappings(mode string) ([]IDMap, []IDMap, error) {
+
+	if mode == UsernsModeHost {
+		if os.Geteuid() != 0 {
+			return nil, nil, fmt.Errorf("--userns=host requires root")
+		}
+		return nil, nil, nil
+	}
+
+	uid, gid := os.Getuid(), os.Getgid()
+
+	userName := strconv.Itoa(uid)
+	if u, err := user.LookupId(userName); err == nil {
+		userName = u.Username
+	}
+
+	uidMap, err := newIDMappings(mode, uid, userName, SubUIDFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gidMap, err := newIDMappings(mode, gid, userName, SubGIDFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return uidMap, gidMap, nil
+}
+
+// newIDMappings 生成一种 ID 的映射
+// auto: 容器 0 => 当前 ID, 容器 1 ~ 65535 => 从属 ID
+// keep-id: 在 auto 的基础上交换容器 0 与当前 ID 的映射, 当前 ID 在容器内不变
+// 没有从属 ID 时 root 使用相同的 ID 映射, 普通用户只映射容器 root
+func newIDMappings(mode string, id int, userName, subIDFile string) ([]IDMap, error) {
+
+	subStart, subCount, err := readSubIDRange(subIDFile, userName, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if subCount == 0 {
+		if id == 0 {
+			return []IDMap{{ContainerID: 0, HostID: 0, Size: IDMapSize}}, nil
+		}
+		if mode == UsernsModeKeepID {
+			return nil, fmt.Errorf("--userns=keep-id requires a range for %v in %v", userName, subIDFile)
+		}
+		log.Warnf("No range for %v in %v, only container root is mapped", userName, subIDFile)
+		return []IDMap{{ContainerID: 0, HostID: id, Size: 1}}, nil
+	}
+
+	size := IDMapSize - 1
+	if subCount < size {
+		size = subCount
+	}
+
+	if mode != UsernsModeKeepID || id == 0 {
+		return []IDMap{
+			{ContainerID: 0, HostID: id, Size: 1},
+			{ContainerID: 1, HostID: subStart, Size: size},
+		}, nil
+	}
+
+	if id > size {
+		return nil, fmt.Errorf("--userns=keep-id can't keep id %v, only %v ids in %v", id, size, subIDFile)
+	}
+
+	// 容器 ID id 原本映射到 subStart + id - 1, 改为映射容器 0
+	idMap := []IDMap{{ContainerID: 0, HostID: subStart + id - 1, Size: 1}}
+	if id > 1 {
+		idMap = append(idMap, IDMap{ContainerID: 1, HostID: subStart, Size: id - 1})
+	}
+	idMap = append(idMap, IDMap{ContainerID: id, HostID: id, Size: 1})
+	if size > id {
+		idMap = append(idMap, IDMap{ContainerID: id + 1, HostID: subStart + id, Size: size - id})
+	}
+
+	return idMap, nil
+}
+
+// readSubIDRange 读取 /etc/subuid /etc/subgid 中用户的第一段从属 ID
+// 文件格式 name_or_id:start:count, 不存在时 count 为 0
+func readSubIDRange(subIDFile, userName string, id int) (int, int, error) {
+
+	file, err := os.Open(subIDFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, fmt.Errorf("Open %v error %v", subIDFile, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) != 3 || (fields[0] != userName && fields[0] != strconv.Itoa(id)) {
+			continue
+		}
+
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil || count <= 0 {
+			continue
+		}
+
+		return start, count, nil
+	}
+
+	return 0, 0, scanner.Err()
+}
+
+// ToHostID 容器 ID 转换为宿主机 ID, 不在映射中时返回 false
+func ToHostID(idMap []IDMap, id int) (int, bool) {
+	for _, m := range idMap {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID, true
+		}
+	}
+	return id, false
+}
+
+// ToContainerID 宿主机 ID 转换为容器 ID, 不在映射中时返回 false
+func ToContainerID(idMap []IDMap, id int) (int, bool) {
+	for _, m := range idMap {
+		if id >= m.HostID && id < m.HostID+m.Size {
+			return m.ContainerID + id - m.HostID, true
+		}
+	}
+	return id, false
+}
+
+// sameIDMappings 两个映射是否相同, nil (host) 与不改变 ID 的映射相同
+func sameIDMappings(a, b []IDMap) bool {
+	if isIdentityMapping(a) && isIdentityMapping(b) {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// isIdentityMapping 映射是否不改变 ID
+func isIdentityMapping(idMap []IDMap) bool {
+	for _, m := range idMap {
+		if m.ContainerID != m.HostID {
+			return false
+		}
+	}
+	return true
+}
+
+// toSysProcIDMap 转换为 SysProcAttr 的映射
+func toSysProcIDMap(idMap []IDMap) []syscall.SysProcIDMap {
+	var sysIDMap []syscall.SysProcIDMap
+	for _, m := range idMap {
+		sysIDMap = append(sysIDMap, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	return sysIDMap
+}
+
+// GetIDMappings 获取容器的 uid gid 映射
+// 旧版本创建的容器没有记录映射, 只映射容器 root
+func (containerInfo *ContainerInfo) GetIDMappings() ([]IDMap, []IDMap) {
+	if containerInfo.UsernsMode == UsernsModeHost {
+		return nil, nil
+	}
+	if len(containerInfo.UidMappings) == 0 || len(containerInfo.GidMappings) == 0 {
+		return []IDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+			[]IDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	}
+	return containerInfo.UidMappings, containerInfo.GidMappings
+}
+
+// UseIDMapHelper 普通用户只能映射自己的 ID, 其他映射需要通过 newuidmap newgidmap 设置
+func (containerInfo *ContainerInfo) UseIDMapHelper() bool {
+	if os.Geteuid() == 0 || containerInfo.UsernsMode == UsernsModeHost {
+		return false
+	}
+	uidMap, gidMap := containerInfo.GetIDMappings()
+	return len(uidMap) > 1 || len(gidMap) > 1
+}
+
+// WriteIDMappings 通过 newuidmap newgidmap 设置容器进程的映射
+// 容器进程在读取启动配置前等待, 映射完成后才发送启动配置
+func WriteIDMappings(pid int, uidMap, gidMap []IDMap) error {
+
+	for _, helper := range []struct {
+		name  string
+		idMap []IDMap
+	}{{"newuidmap", uidMap}, {"newgidmap", gidMap}} {
+
+		args := []string{strconv.Itoa(pid)}
+		for _, m := range helper.idMap {
+			args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+		}
+
+		if output, err := exec.Command(helper.name, args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%v %v error %v : %s", helper.name, strings.Join(args, " "), err, output)
+		}
+	}
+
+	return nil
+}
+
+// ShiftOwnership 按照映射修改目录下所有文件的属主, 容器内看到的属主与镜像中相同
+// 解压镜像层时调用, 不在映射中的 ID 保持不变
+func ShiftOwnership(dir string, uidMap, gidMap []IDMap) error {
+	return RemapOwnership(dir, nil, nil, uidMap, gidMap)
+}
+
+// RemapOwnership 将按照 from 映射转换过的属主改为按照 to 映射转换
+// 先由 from 转换回容器内的 ID, 再由 to 转换为宿主机 ID, from 为 nil 时文件的属主即为容器内的 ID
+func RemapOwnership(dir string, fromUIDMap, fromGIDMap, toUIDMap, toGIDMap []IDMap) error {
+
+	if sameIDMappings(fromUIDMap, toUIDMap) && sameIDMappings(fromGIDMap, toGIDMap) {
+		return nil
+	}
+
+	return filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+
+		uid := RemapID(fromUIDMap, toUIDMap, int(stat.Uid))
+		gid := RemapID(fromGIDMap, toGIDMap, int(stat.Gid))
+		if uid == int(stat.Uid) && gid == int(stat.Gid) {
+			return nil
+		}
+
+		if err := os.Lchown(filePath, uid, gid); err != nil {
+			return fmt.Errorf("Chown %v error %v", filePath, err)
+		}
+
+		// chown 会清除 setuid setgid 位
+		if info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 && info.Mode()&os.ModeSymlink == 0 {
+			if err := os.Chmod(filePath, info.Mode()); err != nil {
+				return fmt.Errorf("Chmod %v error %v", filePath, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// RemapID 宿主机 ID 由 from 映射转换为 to 映射
+// 不在 from 映射中的 ID 保持不变, 不在 to 映射中的容器 ID 直接作为宿主机 ID
+func RemapID(from, to []IDMap, id int) int {
+
+	if len(from) > 0 {
+		containerID, ok := ToContainerID(from, id)
+		if !ok {
+			return id
+		}
+		id = containerID
+	}
+
+	hostID, _ := ToHostID(to, id)
+	return hostID
+}
+
+// TarOwnerMapArgs 打包容器层时将宿主机上的属主转换为容器内的 ID
+// 在 tmpDir 中生成 tar 的 --owner-map --group-map 文件, 返回 tar 的参数
+func TarOwnerMapArgs(dir, tmpDir string, uidMap, gidMap []IDMap) ([]string, error) {
+
+	if isIdentityMapping(uidMap) && isIdentityMapping(gidMap) {
+		return nil, nil
+	}
+
+	uids, gids := map[int]bool{}, map[int]bool{}
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uids[int(stat.Uid)] = true
+			gids[int(stat.Gid)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Walk %v error %v", dir, err)
+	}
+
+	ownerMapFile := path.Join(tmpDir, "owner-map")
+	groupMapFile := path.Join(tmpDir, "group-map")
+
+	if err := writeTarIDMapFile(ownerMapFile, uids, uidMap); err != nil {
+		return nil, err
+	}
+	if err := writeTarIDMapFile(groupMapFile, gids, gidMap); err != nil {
+		return nil, err
+	}
+
+	return []string{"--numeric-owner", "--owner-map=" + ownerMapFile, "--group-map=" + groupMapFile}, nil
+}
+
+// writeTarIDMapFile 每行为 +宿主机ID +容器ID
+func writeTarIDMapFile(mapFile string, ids map[int]bool, idMap []IDMap) error {
+
+	var sortedIDs []int
+	for id := range ids {
+		sortedIDs = append(sortedIDs, id)
+	}
+	sort.Ints(sortedIDs)
+
+	var content strings.Builder
+	for _, id := range sortedIDs {
+		if containerID, ok := ToContainerID(idMap, id); ok {
+			fmt.Fprintf(&content, "+%d +%d\n", id, containerID)
+		}
+	}
+
+	if err := ioutil.WriteFile(mapFile, []byte(content.String()), 0644); err != nil {
+		return fmt.Errorf("Write %v error %v", mapFile, err)
+	}
+
+	return nil
+}
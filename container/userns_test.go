@@ -0,0 +1,170 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestParseUsernsMode(t *testing.T) {
+	for _, c := range []struct {
+		input string
+		want  string
+	}{
+		{"", UsernsModeAuto},
+		{"auto", UsernsModeAuto},
+		{"host", UsernsModeHost},
+		{"keep-id", UsernsModeKeepID},
+	} {
+		mode, err := ParseUsernsMode(c.input)
+		if err != nil {
+			t.Fatalf("parse userns mode %v error : %v", c.input, err)
+		}
+		if mode != c.want {
+			t.Fatalf("userns mode %v => %v, want %v", c.input, mode, c.want)
+		}
+	}
+
+	for _, mode := range []string{"private", "keepid", "Host"} {
+		if _, err := ParseUsernsMode(mode); err == nil {
+			t.Fatalf("parse userns mode %v should fail", mode)
+		}
+	}
+}
+
+func TestNewIDMappings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "userns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	subIDFile := path.Join(dir, "subuid")
+	if err := ioutil.WriteFile(subIDFile, []byte("root:100000:65536\nalice:200000:65536\n1001:300000:10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name     string
+		mode     string
+		id       int
+		userName string
+		file     string
+		want     []IDMap
+	}{
+		{"root auto", UsernsModeAuto, 0, "root", subIDFile, []IDMap{
+			{ContainerID: 0, HostID: 0, Size: 1},
+			{ContainerID: 1, HostID: 100000, Size: 65535},
+		}},
+		{"root keep-id", UsernsModeKeepID, 0, "root", subIDFile, []IDMap{
+			{ContainerID: 0, HostID: 0, Size: 1},
+			{ContainerID: 1, HostID: 100000, Size: 65535},
+		}},
+		{"user auto", UsernsModeAuto, 1000, "alice", subIDFile, []IDMap{
+			{ContainerID: 0, HostID: 1000, Size: 1},
+			{ContainerID: 1, HostID: 200000, Size: 65535},
+		}},
+		{"user keep-id", UsernsModeKeepID, 1000, "alice", subIDFile, []IDMap{
+			{ContainerID: 0, HostID: 200999, Size: 1},
+			{ContainerID: 1, HostID: 200000, Size: 999},
+			{ContainerID: 1000, HostID: 1000, Size: 1},
+			{ContainerID: 1001, HostID: 201000, Size: 64535},
+		}},
+		{"range by id", UsernsModeAuto, 1001, "bob", subIDFile, []IDMap{
+			{ContainerID: 0, HostID: 1001, Size: 1},
+			{ContainerID: 1, HostID: 300000, Size: 10},
+		}},
+		{"root without range", UsernsModeAuto, 0, "root", "/nonexistent", []IDMap{
+			{ContainerID: 0, HostID: 0, Size: IDMapSize},
+		}},
+		{"user without range", UsernsModeAuto, 1000, "alice", "/nonexistent", []IDMap{
+			{ContainerID: 0, HostID: 1000, Size: 1},
+		}},
+	} {
+		idMap, err := newIDMappings(c.mode, c.id, c.userName, c.file)
+		if err != nil {
+			t.Fatalf("%v: new id mappings error : %v", c.name, err)
+		}
+		if !reflect.DeepEqual(idMap, c.want) {
+			t.Fatalf("%v: id mappings %v, want %v", c.name, idMap, c.want)
+		}
+	}
+
+	// keep-id 需要从属 ID, 且当前 ID 必须在范围内
+	if _, err := newIDMappings(UsernsModeKeepID, 1000, "alice", "/nonexistent"); err == nil {
+		t.Fatalf("keep-id without range should fail")
+	}
+	if _, err := newIDMappings(UsernsModeKeepID, 1001, "bob", subIDFile); err == nil {
+		t.Fatalf("keep-id with id out of range should fail")
+	}
+}
+
+func TestRemapID(t *testing.T) {
+	auto := []IDMap{{ContainerID: 0, HostID: 0, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65535}}
+	keepID := []IDMap{
+		{ContainerID: 0, HostID: 100999, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 999},
+		{ContainerID: 1000, HostID: 1000, Size: 1},
+		{ContainerID: 1001, HostID: 101000, Size: 64535},
+	}
+
+	for _, c := range []struct {
+		name     string
+		from, to []IDMap
+		id, want int
+	}{
+		{"auto to host root", auto, nil, 0, 0},
+		{"auto to host user", auto, nil, 100004, 5},
+		{"auto to keep-id root", auto, keepID, 0, 100999},
+		{"auto to keep-id user", auto, keepID, 100999, 1000},
+		{"not in from mapping", auto, nil, 5, 5},
+		{"container id to auto", nil, auto, 5, 100004},
+	} {
+		if got := RemapID(c.from, c.to, c.id); got != c.want {
+			t.Fatalf("%v: remap id %v => %v, want %v", c.name, c.id, got, c.want)
+		}
+	}
+
+	if !sameIDMappings(nil, []IDMap{{ContainerID: 0, HostID: 0, Size: IDMapSize}}) {
+		t.Fatalf("host mapping should be the same as identity mapping")
+	}
+	if sameIDMappings(nil, auto) || sameIDMappings(auto, keepID) {
+		t.Fatalf("different mappings should not be the same")
+	}
+}
+
+func TestRemapOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown requires root")
+	}
+
+	dir, err := ioutil.TempDir("", "userns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(file, 100004, 100005); err != nil {
+		t.Fatal(err)
+	}
+
+	auto := []IDMap{{ContainerID: 0, HostID: 0, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65535}}
+	if err := RemapOwnership(dir, auto, auto, nil, nil); err != nil {
+		t.Fatalf("remap ownership error : %v", err)
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(file, &stat); err != nil {
+		t.Fatal(err)
+	}
+	if stat.Uid != 5 || stat.Gid != 6 {
+		t.Fatalf("remap ownership %v:%v, want 5:6", stat.Uid, stat.Gid)
+	}
+}
@@ -33,7 +33,8 @@ var (
 )
 
 // NewWorkSpace 创建容器文件系统
-func NewWorkSpace(imageName, containerID string) (*DriverInfo, error) {
+// uidMap gidMap 为容器的 user namespace 映射, 与镜像层的映射不同时使用转换属主后的镜像层副本
+func NewWorkSpace(imageName, containerID string, uidMap, gidMap []IDMap) (*DriverInfo, error) {
 
 	driverInfo := &DriverInfo{
 		Driver: Driver,
@@ -53,6 +54,11 @@ func NewWorkSpace(imageName, containerID string) (*DriverInfo, error) {
 		return nil, fmt.Errorf("Can't create %v image error : %v", imageID, err)
 	}
 
+	// 按照容器的映射转换镜像层的属主
+	if err := CreateShiftedLayers(containerID, imageLower, uidMap, gidMap); err != nil {
+		return nil, fmt.Errorf("Can't shift %v image layers error : %v", imageID, err)
+	}
+
 	// container layer 层
 	// upperdir和lowerdir有同名文件时会用upperdir的文件
 	if err := CreateWriteLayer(containerID); err != nil {
@@ -75,56 +81,23 @@ func NewWorkSpace(imageName, containerID string) (*DriverInfo, error) {
 	return driverInfo, nil
 }
 
-// SetVolume 讲 数据卷信息写入 MountDir/[containerID]/link 文件中
+// SetVolume 解析 -v 数据卷参数, 记录到 containerInfo.Mount 中, 由 init 进程挂载
 func SetVolume(containerID string, volumes []string) []*MountInfo {
 
 	mountInfo := []*MountInfo{}
 
-	// 创建  MountDir/[containerID]/link 文件
-	linkFile, err := os.Create(path.Join(MountDir, containerID, "link"))
-
-	if err != nil {
-		log.Warnf("Create qsrdocker : %v link file fail %v", containerID, err)
-	}
-
-	defer linkFile.Close()
-
 	// 追加进入 BindVolumeInfo
 	for _, volume := range volumes {
 		if strings.Replace(volume, " ", "", -1) != "" {
-			// host volume : guest volume
-			volumePaths := strings.Split(volume, ":")
-			length := len(volumePaths)
-
-			if length == 2 && strings.Replace(volumePaths[0], " ", "", -1) != "" && strings.Replace(volumePaths[1], " ", "", -1) != "" {
-				// 获取绝对路径
-				volumePaths[0], err = filepath.Abs(volumePaths[0])
-
-				if err != nil {
-					log.Warnf("Get Source Abs Path fail")
-					// 无法获取绝对路径，则跳过本次循环
-					continue
-				} else {
-					log.Debugf("Get Source Abs Path %v", volumePaths[0])
-				}
-
-				mountInfo = append(mountInfo, &MountInfo{
-					Type:        MountType,
-					Source:      volumePaths[0],
-					Destination: volumePaths[1],
-					RW:          true,
-				},
-				)
-
-				// src:dst\n
-				bindInfo := strings.Join(volumePaths, ":")
-				bindInfo = strings.Join([]string{bindInfo, "\n"}, "")
-
-				linkFile.WriteString(bindInfo)
-
-			} else {
-				log.Warnf("Volume parameter input is not correct : %v", volumePaths)
+			// host volume : guest volume [: options]
+			mount, err := ParseVolume(volume)
+			if err != nil {
+				log.Warnf("Volume parameter input is not correct : %v", err)
+				continue
 			}
+
+			log.Debugf("Get Source Abs Path %v", mount.Source)
+			mountInfo = append(mountInfo, mount)
 		}
 	}
 	// 返回 mountInfo 作为 contionInfo 数据
@@ -133,51 +106,44 @@ func SetVolume(containerID string, volumes []string) []*MountInfo {
 
 // InitVolume  数据卷挂载
 // 需要在 mount namespace 修改后(unshared) 才进行 Mount Bind 挂载
-func InitVolume(CurrDir string) {
+// 挂载失败时返回错误, 避免只读数据卷以可写的方式运行
+func InitVolume(CurrDir string, mounts []*MountInfo) error {
 
 	// 通过 pwd 当前目录 /MountDir/[containerID]/merge 获取
 	// 先获取 Dir /MountDir/[containerID] 再 获取 base containerID
 	containerID := filepath.Base(filepath.Dir(CurrDir))
 
-	linkfile, err := os.Open(path.Join(MountDir, containerID, "link"))
-
-	if err != nil {
-		log.Warnf("Can't open link file: %v, err: %v", linkfile, err)
-	}
-
-	defer linkfile.Close()
-
-	// 按行读取
-	scanner := bufio.NewScanner(linkfile)
-	for scanner.Scan() {
-		volume := scanner.Text()
-
-		if strings.Replace(volume, " ", "", -1) != "" {
-			// host volume : guest volume
-			volumePaths := strings.Split(volume, ":")
-			length := len(volumePaths)
-
-			if length == 2 && strings.Replace(volumePaths[0], " ", "", -1) != "" && strings.Replace(volumePaths[1], " ", "", -1) != "" {
+	for _, mount := range mounts {
+		if mount.Type == TmpfsMountType {
+			if err := mountTmpfs(CurrDir, mount); err != nil {
+				return err
+			}
+			continue
+		}
 
-				// 数据卷实现
-				MountBindVolume(volumePaths, containerID)
+		if strings.Replace(mount.Source, " ", "", -1) == "" || strings.Replace(mount.Destination, " ", "", -1) == "" {
+			log.Warnf("Volume Set is not correct : %v:%v", mount.Source, mount.Destination)
+			continue
+		}
 
-			} else {
-				log.Warnf("Volume Set is not correct")
-			}
+		// 数据卷实现
+		if err := MountBindVolume(mount, containerID); err != nil {
+			return err
 		}
 	}
+
+	return nil
 }
 
 // MountBindVolume 数据卷实现
-func MountBindVolume(volumePaths []string, containerID string) {
+func MountBindVolume(mount *MountInfo, containerID string) error {
 
 	// host 卷
 	// 不存在则创建
-	hostPath, _ := filepath.Abs(volumePaths[0])
+	hostPath, _ := filepath.Abs(mount.Source)
 
 	// guest 卷
-	containerPath := volumePaths[1]
+	containerPath := mount.Destination
 
 	mountPath := path.Join(MountDir, containerID, "merged")
 
@@ -212,13 +178,14 @@ func MountBindVolume(volumePaths []string, containerID string) {
 		}
 	}
 
-	// 运行 mount --bind 挂载
-	_, err := exec.Command("mount", "--bind", hostPath, containerVolumePtah).CombinedOutput()
-	if err != nil {
-		log.Errorf("Mount Bind volume %v failed. %v", volumePaths, err)
-	} else {
-		log.Debugf("Mount Bind volume  %v to %v success", hostPath, containerVolumePtah)
+	// bind mount 挂载, 设置只读与挂载传播类型
+	if err := mountBind(hostPath, containerVolumePtah, mount); err != nil {
+		log.Errorf("Mount Bind volume %v:%v failed. %v", hostPath, containerPath, err)
+		return err
 	}
+
+	log.Debugf("Mount Bind volume  %v to %v success", hostPath, containerVolumePtah)
+	return nil
 }
 
 // CreateReadOnlyLayer  解压 image.tar 到 镜像存放目录
@@ -269,6 +236,16 @@ func CreateReadOnlyLayer(imageID string) error {
 
 		log.Debugf("Tar %v successful ", imageTarPath)
 
+		// 镜像层的属主按照默认的 user namespace 映射转换为宿主机 ID
+		// 映射不同的容器使用 CreateShiftedLayers 创建的副本
+		uidMap, gidMap, err := GetIDMappings(UsernsModeAuto)
+		if err != nil {
+			return err
+		}
+		if err := ShiftOwnership(imageTarDir, uidMap, gidMap); err != nil {
+			return fmt.Errorf("Shift image %v ownership error %v", imageID, err)
+		}
+
 		// 删除镜像压缩文件a
 		if err := os.RemoveAll(imageTarPath); err != nil {
 			log.Debugf("Remove ImageTarPath %s error %v", imageTarPath, err)
@@ -283,6 +260,53 @@ func CreateReadOnlyLayer(imageID string) error {
 	return nil
 }
 
+// CreateShiftedLayers 容器的映射与镜像层的 auto 映射不同时 (host, keep-id)
+// 复制镜像层到 /MountDir/[containerID]/layers/[imageID], 并按照容器的映射修改属主
+// 副本随容器的 DeleteDockerDir 删除
+func CreateShiftedLayers(containerID, imageLower string, uidMap, gidMap []IDMap) error {
+
+	imageUIDMap, imageGIDMap, err := GetIDMappings(UsernsModeAuto)
+	if err != nil {
+		return err
+	}
+
+	if sameIDMappings(imageUIDMap, uidMap) && sameIDMappings(imageGIDMap, gidMap) {
+		return nil
+	}
+
+	for _, imageID := range strings.Split(imageLower, ":") {
+		layerDir := path.Join(MountDir, containerID, "layers", imageID)
+
+		if err := os.MkdirAll(path.Dir(layerDir), 0700); err != nil {
+			return fmt.Errorf("Mkdir %v error %v", path.Dir(layerDir), err)
+		}
+
+		// cp -a 保留属主 权限 与 链接
+		if output, err := exec.Command("cp", "-a", path.Join(ImageDir, imageID), layerDir).CombinedOutput(); err != nil {
+			return fmt.Errorf("Copy image layer %v error %v : %s", imageID, err, output)
+		}
+
+		if err := RemapOwnership(layerDir, imageUIDMap, imageGIDMap, uidMap, gidMap); err != nil {
+			return fmt.Errorf("Shift image layer %v ownership error %v", imageID, err)
+		}
+
+		log.Debugf("Shift image layer %v to %v", imageID, layerDir)
+	}
+
+	return nil
+}
+
+// LayerDir 容器使用的镜像层目录, 存在转换属主后的副本时使用副本
+func LayerDir(containerID, imageID string) string {
+
+	layerDir := path.Join(MountDir, containerID, "layers", imageID)
+	if exist, _ := PathExists(layerDir); exist {
+		return layerDir
+	}
+
+	return path.Join(ImageDir, imageID)
+}
+
 // CreateWriteLayer 创建容器 cow 层
 func CreateWriteLayer(containerID string) error {
 
@@ -340,9 +364,9 @@ func CreateMountPointWithOverlay2(containerID, imageLower string) (map[string]st
 
 		// 判断是否为最后一层
 		if i == imageLowerSliceLen-1 {
-			lowerDir = strings.Join([]string{lowerDir, path.Join(ImageDir, imageID)}, "")
+			lowerDir = strings.Join([]string{lowerDir, LayerDir(containerID, imageID)}, "")
 		} else {
-			lowerDir = strings.Join([]string{lowerDir, path.Join(ImageDir, imageID), ":"}, "")
+			lowerDir = strings.Join([]string{lowerDir, LayerDir(containerID, imageID), ":"}, "")
 		}
 	}
 
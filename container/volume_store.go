@@ -0,0 +1,322 @@
+package container
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Volume 命名数据卷
+// 数据保存在 VolumeDir/[name]/_data, 元数据保存在 VolumeDir/[name]/volume.json
+type Volume struct {
+	Name       string            `json:"Name"`
+	Driver     string            `json:"Driver"`     // 目前只支持 local
+	Mountpoint string            `json:"Mountpoint"` // 宿主机上的数据目录
+	Labels     map[string]string `json:"Labels"`     // --label key=value
+	CreatedAt  string            `json:"CreatedAt"`  // 创建时间
+	Anonymous  bool              `json:"Anonymous"`  // -v /path 创建的匿名数据卷, 只有匿名数据卷会被 rm -v 删除
+	Containers []string          `json:"Containers"` // 使用该数据卷的容器ID
+	RefCount   int               `json:"RefCount"`   // 使用该数据卷的容器数量
+}
+
+// VolumeMountpoint 命名数据卷在宿主机上的数据目录
+func VolumeMountpoint(name string) string {
+	return path.Join(VolumeDir, name, "_data")
+}
+
+// CreateVolume 创建命名数据卷, 名称为空时随机生成
+func CreateVolume(name, driver string, labels map[string]string) (*Volume, error) {
+
+	if driver == "" {
+		driver = DefaultVolumeDriver
+	}
+
+	if driver != DefaultVolumeDriver {
+		return nil, fmt.Errorf("Volume driver %v is not supported, use %v", driver, DefaultVolumeDriver)
+	}
+
+	if name == "" {
+		name = randVolumeName()
+	}
+
+	if !volumeNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("Invalid volume name %v, use [a-zA-Z0-9][a-zA-Z0-9_.-]*", name)
+	}
+
+	if exist, _ := PathExists(path.Join(VolumeDir, name, VolumeInfoFile)); exist {
+		return nil, fmt.Errorf("Volume %v exists", name)
+	}
+
+	return createVolume(name, driver, labels, false)
+}
+
+// createVolume 创建数据目录并记录元数据
+func createVolume(name, driver string, labels map[string]string, anonymous bool) (*Volume, error) {
+
+	if labels == nil {
+		labels = map[string]string{}
+	}
+
+	volume := &Volume{
+		Name:       name,
+		Driver:     driver,
+		Mountpoint: VolumeMountpoint(name),
+		Labels:     labels,
+		CreatedAt:  time.Now().Format(time.RFC3339),
+		Anonymous:  anonymous,
+		Containers: []string{},
+	}
+
+	if err := os.MkdirAll(volume.Mountpoint, 0755); err != nil {
+		return nil, fmt.Errorf("Mkdir volume %v error %v", volume.Mountpoint, err)
+	}
+
+	if err := volume.Dump(); err != nil {
+		os.RemoveAll(path.Join(VolumeDir, name))
+		return nil, err
+	}
+
+	log.Debugf("Create volume %v in %v success", name, volume.Mountpoint)
+
+	RecordEvent(EventTypeVolume, "create", name, map[string]string{"driver": driver, "anonymous": strconv.FormatBool(anonymous)})
+
+	return volume, nil
+}
+
+// GetVolume 获取命名数据卷
+func GetVolume(name string) (*Volume, error) {
+
+	if !volumeNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("Invalid volume name %v", name)
+	}
+
+	volume := &Volume{Name: name}
+	if err := volume.Load(); err != nil {
+		return nil, err
+	}
+
+	return volume, nil
+}
+
+// ListVolumes 获取所有命名数据卷, 按名称排序
+func ListVolumes() ([]*Volume, error) {
+
+	volumes := []*Volume{}
+
+	files, err := ioutil.ReadDir(VolumeDir)
+	if os.IsNotExist(err) {
+		return volumes, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Read volume dir %v error %v", VolumeDir, err)
+	}
+
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+
+		volume, err := GetVolume(file.Name())
+		if err != nil {
+			log.Warnf("Load volume %v error %v", file.Name(), err)
+			continue
+		}
+		volumes = append(volumes, volume)
+	}
+
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+
+	return volumes, nil
+}
+
+// RemoveVolume 删除命名数据卷, 被容器使用时不能删除
+func RemoveVolume(name string) error {
+
+	volume, err := GetVolume(name)
+	if err != nil {
+		return fmt.Errorf("Get volume %v error %v", name, err)
+	}
+
+	if len(volume.Containers) > 0 {
+		return fmt.Errorf("Volume %v is in use by containers %v", name, volume.Containers)
+	}
+
+	return volume.remove()
+}
+
+// PruneVolumes 删除未被容器使用的匿名数据卷, all 为 true 时同时删除未使用的命名数据卷
+func PruneVolumes(all bool) ([]string, error) {
+
+	volumes, err := ListVolumes()
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := []string{}
+	for _, volume := range volumes {
+		if len(volume.Containers) > 0 || (!volume.Anonymous && !all) {
+			continue
+		}
+
+		if err := volume.remove(); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, volume.Name)
+	}
+
+	return pruned, nil
+}
+
+// AttachVolumes 容器创建时引用命名数据卷, 不存在时自动创建, 匿名数据卷使用随机名称
+// 数据卷的 Source 设置为 VolumeDir/[name]/_data, 首次使用时由 MountBindVolume 从镜像中复制数据
+func AttachVolumes(containerID string, mounts []*MountInfo) error {
+
+	for i, mount := range mounts {
+		if mount.Type != VolumeMountType {
+			continue
+		}
+
+		volume, err := attachVolume(containerID, mount)
+		if err != nil {
+			ReleaseVolumes(containerID, mounts[:i], true)
+			return err
+		}
+
+		mount.Name = volume.Name
+		mount.Source = volume.Mountpoint
+	}
+
+	return nil
+}
+
+// attachVolume 获取或创建数据卷, 记录容器引用
+func attachVolume(containerID string, mount *MountInfo) (*Volume, error) {
+
+	var volume *Volume
+	var err error
+
+	if mount.Name == "" {
+		volume, err = createVolume(randVolumeName(), DefaultVolumeDriver, nil, true)
+	} else if exist, _ := PathExists(path.Join(VolumeDir, mount.Name, VolumeInfoFile)); exist {
+		volume, err = GetVolume(mount.Name)
+	} else {
+		volume, err = CreateVolume(mount.Name, DefaultVolumeDriver, nil)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Attach volume %v to %v error %v", mount.Name, mount.Destination, err)
+	}
+
+	if !containsString(volume.Containers, containerID) {
+		volume.Containers = append(volume.Containers, containerID)
+	}
+
+	if err := volume.Dump(); err != nil {
+		return nil, err
+	}
+
+	return volume, nil
+}
+
+// ReleaseVolumes 容器删除时释放数据卷的引用
+// removeAnonymous 为 true 时 (rm -v, -it 容器退出) 删除不再被使用的匿名数据卷, 命名数据卷与 bind mount 的宿主机目录不会被删除
+func ReleaseVolumes(containerID string, mounts []*MountInfo, removeAnonymous bool) {
+
+	for _, mount := range mounts {
+		if mount.Type != VolumeMountType || mount.Name == "" {
+			continue
+		}
+
+		volume, err := GetVolume(mount.Name)
+		if err != nil {
+			log.Warnf("Release volume %v of container %v error %v", mount.Name, containerID, err)
+			continue
+		}
+
+		containers := []string{}
+		for _, id := range volume.Containers {
+			if id != containerID {
+				containers = append(containers, id)
+			}
+		}
+		volume.Containers = containers
+
+		if removeAnonymous && volume.Anonymous && len(volume.Containers) == 0 {
+			if err := volume.remove(); err != nil {
+				log.Errorf("Remove anonymous volume %v error %v", volume.Name, err)
+			}
+			continue
+		}
+
+		if err := volume.Dump(); err != nil {
+			log.Errorf("Release volume %v of container %v error %v", mount.Name, containerID, err)
+		}
+	}
+}
+
+// Dump 保存数据卷元数据
+func (volume *Volume) Dump() error {
+
+	volume.RefCount = len(volume.Containers)
+
+	volumeInfoByte, err := json.MarshalIndent(volume, " ", "    ")
+	if err != nil {
+		return fmt.Errorf("Marshal volume %v info error %v", volume.Name, err)
+	}
+
+	volumeInfoPath := path.Join(VolumeDir, volume.Name, VolumeInfoFile)
+	if err := ioutil.WriteFile(volumeInfoPath, volumeInfoByte, 0644); err != nil {
+		return fmt.Errorf("Write volume info %v error %v", volumeInfoPath, err)
+	}
+
+	return nil
+}
+
+// Load 读取数据卷元数据
+func (volume *Volume) Load() error {
+
+	volumeInfoPath := path.Join(VolumeDir, volume.Name, VolumeInfoFile)
+
+	volumeInfoByte, err := ioutil.ReadFile(volumeInfoPath)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(volumeInfoByte, volume); err != nil {
+		return fmt.Errorf("Unmarshal volume info %v error %v", volumeInfoPath, err)
+	}
+
+	return nil
+}
+
+// remove 删除数据卷目录与元数据
+func (volume *Volume) remove() error {
+
+	if err := os.RemoveAll(path.Join(VolumeDir, volume.Name)); err != nil {
+		return fmt.Errorf("Remove volume %v error %v", volume.Name, err)
+	}
+
+	log.Debugf("Remove volume %v success", volume.Name)
+
+	RecordEvent(EventTypeVolume, "destroy", volume.Name, nil)
+
+	return nil
+}
+
+// randVolumeName 匿名数据卷的名称, 64 位十六进制
+func randVolumeName() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
@@ -4,11 +4,13 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"os/exec"
 	"path"
+	"qsrdocker/cgroups/subsystems"
 	"qsrdocker/container"
 	"qsrdocker/network"
 	"strconv"
@@ -21,34 +23,32 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func inspectContainer(containerName string) {
+// inspectContainer 获取容器信息
+func inspectContainer(containerName string) (*container.ContainerInfo, error) {
 	// 获取containerInfo信息
 	containerInfo, err := container.GetContainerInfoByNameID(containerName)
 	if err != nil {
-		log.Errorf("Get containerInfo fail : %v", err)
-		return
+		return nil, fmt.Errorf("Get containerInfo fail : %v", err)
 	}
 
-	// 存入数据
-	containerInfoBytes, err := json.MarshalIndent(containerInfo, " ", "    ")
-	if err != nil {
-		log.Errorf("Get container %v Info err : %v", containerName, err)
-		return
+	// oom_kill_disable 时 OOM 的容器不会被杀死而是被挂起, 实时读取 cgroup 状态
+	if containerInfo.Status.Running || containerInfo.Status.Paused {
+		if oomState, err := containerInfo.Cgroup.OOMState(containerInfo.Status.Pid); err == nil {
+			containerInfo.Status.OOMStalled = oomState.OOMKillDisable && oomState.UnderOOM
+		}
 	}
 
-	containerInfoStr := strings.Join([]string{string(containerInfoBytes), "\n"}, "")
-
-	fmt.Fprint(os.Stdout, containerInfoStr)
-
+	return containerInfo, nil
 }
 
 // stopContainer 停止容器
-func stopContainer(containerName string, sleepTime int) {
+// 发送停止信号，等待 sleepTime 秒后容器仍未退出则发送 SIGKILL
+// 网络和 cgroup 由 monitor 进程在容器退出后清理
+func stopContainer(containerName string, sleepTime int) error {
 	containerID, err := container.GetContainerIDByName(containerName)
 
 	if strings.Replace(containerID, " ", "", -1) == "" || err != nil {
-		log.Errorf("Get containerID fail : %v", err)
-		return
+		return fmt.Errorf("Get containerID fail : %v", err)
 	}
 
 	log.Debugf("Get containerID success  id : %v", containerID)
@@ -56,52 +56,321 @@ func stopContainer(containerName string, sleepTime int) {
 	// 获取containerInfo信息
 	containerInfo, err := container.GetContainerInfoByNameID(containerID)
 	if err != nil {
-		log.Errorf("Get containerInfo fail : %v", err)
-		return
+		return fmt.Errorf("Get containerInfo fail : %v", err)
 	}
 
-	if !containerInfo.Status.Running {
-		log.Errorf("Stop container fail, container is not running : %v", err)
-		return
+	// 等待重启的容器，标记为 stop 即可，monitor 不会再重启它
+	if containerInfo.Status.Restarting {
+		restarting := false
+		err := container.UpdateContainerInfo(containerID, func(latestInfo *container.ContainerInfo) (bool, error) {
+			if !latestInfo.Status.Restarting {
+				return false, nil
+			}
+			restarting = true
+			latestInfo.Status.StatusSet("Stopped")
+			latestInfo.Status.Pid = -1
+			return true, nil
+		})
+		if err != nil || restarting {
+			return err
+		}
+
+		// monitor 已重启容器, 按运行中的容器停止
+		if containerInfo, err = container.GetContainerInfoByNameID(containerID); err != nil {
+			return fmt.Errorf("Get containerInfo fail : %v", err)
+		}
+	}
+
+	if !containerInfo.Status.Running && !containerInfo.Status.Paused {
+		return fmt.Errorf("Stop container %v fail, container is not running", containerName)
 	}
 
 	pid := containerInfo.Status.Pid
+	paused := containerInfo.Status.Paused
 
-	if sleepTime > 0 {
-		// 睡眠 sleepTime 秒后
-		time.Sleep(time.Duration(sleepTime) * time.Second)
+	// 先标记 StopRequested, monitor 回收容器进程后设置为 Stopped, 不会按重启策略重启
+	if err := setStopRequested(containerID, pid, true); err != nil {
+		return err
 	}
 
-	// 断开网络连接
-	err = network.Disconnect(containerInfo.NetWorks.Network.ID, containerInfo)
-	if err != nil {
-		log.Errorf("Stop container %v network error %v", containerName, err)
+	stopSignal := containerInfo.GetStopSignal()
+
+	if err := syscall.Kill(pid, stopSignal); err != nil {
+		// 信号未发送成功, 撤销 StopRequested, 避免之后的退出被记录为 Stopped
+		if e := setStopRequested(containerID, pid, false); e != nil {
+			log.Errorf("Reset container %v stop request error %v", containerName, e)
+		}
+		return fmt.Errorf("Send signal %v to container %v error %v", container.SignalName(stopSignal), containerName, err)
 	}
 
-	// 调用系统调用发送信号 SIGTERM
-	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
-		log.Errorf("Stop container %v error %v", containerName, err)
-		return
+	// 被冻结的进程无法处理信号，解冻后信号才会被处理
+	if paused {
+		if err := containerInfo.Cgroup.Thaw(pid); err != nil {
+			return fmt.Errorf("Unpause container %v error %v", containerName, err)
+		}
+	}
+
+	// 等待容器退出，超时后 SIGKILL
+	if !waitProcessExit(pid, time.Duration(sleepTime)*time.Second) {
+		log.Debugf("Container %v did not exit within %v seconds, kill it", containerName, sleepTime)
+
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+			log.Warnf("Send signal SIGKILL to container %v error %v", containerName, err)
+		}
+
+		if !waitProcessExit(pid, 10*time.Second) {
+			return fmt.Errorf("Stop container %v fail, process %v can't be killed", containerName, pid)
+		}
 	}
 
 	log.Debugf("Stop container %v success", containerName)
 
-	// 设置当前
-	containerInfo.Status.StatusSet("Paused")
-	containerInfo.Status.Pid = -1
+	container.RecordContainerEvent("stop", containerInfo, nil)
 
-	// 持久化 container Info
-	container.RecordContainerInfo(containerInfo, containerID)
+	return waitContainerCleanup(containerID)
+}
+
+// setStopRequested 在 config.json 文件锁内设置 StopRequested
+// 容器已退出或重启 (Pid 变化) 时返回错误
+func setStopRequested(containerID string, pid int, stopRequested bool) error {
+
+	return container.UpdateContainerInfo(containerID, func(containerInfo *container.ContainerInfo) (bool, error) {
+		if containerInfo.Status.Pid != pid {
+			return false, fmt.Errorf("Container %v process %v has exited", containerID, pid)
+		}
+
+		containerInfo.Status.StopRequested = stopRequested
+
+		return true, nil
+	})
+}
+
+// waitContainerCleanup 等待 monitor 记录容器退出信息并清理网络和 cgroup
+// monitor 已不存在时由当前进程清理
+func waitContainerCleanup(containerID string) error {
+
+	for i := 0; i < 100; i++ {
+		containerInfo, err := container.GetContainerInfoByNameID(containerID)
+
+		// -it 容器退出后会被删除
+		if err != nil {
+			return nil
+		}
+
+		if containerInfo.Status.Pid == -1 {
+			return nil
+		}
+
+		monitorPid := containerInfo.Status.MonitorPid
+		if monitorPid <= 0 || waitProcessExit(monitorPid, 0) {
+			log.Warnf("Monitor of container %v is not exist, clean up container", containerID)
+
+			if err := network.Disconnect(containerInfo.NetWorks.Network.ID, containerInfo); err != nil {
+				log.Errorf("Disconnect container %v network error %v", containerID, err)
+			}
+			containerInfo.Cgroup.Destroy()
+
+			containerInfo.Status.Pid = -1
+			containerInfo.Status.MonitorPid = 0
+			containerInfo.Status.FinishedAt = time.Now().Format("2006-01-02 15:04:05")
+			return container.RecordContainerInfo(containerInfo, containerID)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("Wait monitor of container %v cleanup timeout", containerID)
+}
+
+// waitProcessExit 等待进程退出，timeout 内退出返回 true
+// 容器进程不是当前进程的子进程，僵尸状态即视为已退出
+func waitProcessExit(pid int, timeout time.Duration) bool {
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		if processExited(pid) {
+			return true
+		}
+
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// processExited 判断进程是否已经退出
+func processExited(pid int) bool {
+
+	// /proc/[pid]/stat  pid (comm) state ...
+	statBytes, err := ioutil.ReadFile(path.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return true
+	}
+
+	stat := string(statBytes)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+
+	return len(fields) > 0 && (fields[0] == "Z" || fields[0] == "X")
+}
+
+// killContainer 向容器 init 进程发送信号，不修改容器状态
+func killContainer(containerName, signal string) error {
+	containerID, err := container.GetContainerIDByName(containerName)
+
+	if strings.Replace(containerID, " ", "", -1) == "" || err != nil {
+		return fmt.Errorf("Get containerID fail : %v", err)
+	}
+
+	// 获取containerInfo信息
+	containerInfo, err := container.GetContainerInfoByNameID(containerID)
+	if err != nil {
+		return fmt.Errorf("Get containerInfo fail : %v", err)
+	}
+
+	if !containerInfo.Status.Running && !containerInfo.Status.Paused {
+		return fmt.Errorf("Container %v is not running", containerName)
+	}
+
+	sig, err := container.ParseSignal(signal)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Kill(containerInfo.Status.Pid, sig); err != nil {
+		return fmt.Errorf("Kill container %v error %v", containerName, err)
+	}
+
+	log.Debugf("Send signal %v to container %v success", container.SignalName(sig), containerName)
+
+	container.RecordContainerEvent("kill", containerInfo, map[string]string{"signal": container.SignalName(sig)})
+
+	return nil
+}
+
+// waitContainer 阻塞直到容器停止，返回退出码
+// 尚未启动或按重启策略等待重启的容器继续等待, 直到不再运行
+// done 关闭时 (如客户端断开) 停止等待
+func waitContainer(containerName string, done <-chan struct{}) (int, error) {
+	containerID, err := container.GetContainerIDByName(containerName)
+
+	if strings.Replace(containerID, " ", "", -1) == "" || err != nil {
+		return 0, fmt.Errorf("Get containerID fail : %v", err)
+	}
+
+	// 获取containerInfo信息
+	containerInfo, err := container.GetContainerInfoByNameID(containerID)
+	if err != nil {
+		return 0, fmt.Errorf("Get containerInfo fail : %v", err)
+	}
+
+	// 容器进程退出且不再重启后 monitor 记录为 Stopped / OOMKilled / Dead
+	for containerInfo.Status.Created || containerInfo.Status.Running || containerInfo.Status.Paused || containerInfo.Status.Restarting {
+		select {
+		case <-done:
+			return 0, fmt.Errorf("Wait container %v canceled", containerName)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if containerInfo, err = container.GetContainerInfoByNameID(containerID); err != nil {
+			return 0, fmt.Errorf("Container %v has been removed", containerName)
+		}
+	}
+
+	return containerInfo.Status.ExitCode, nil
+}
+
+// restartContainer 重启容器，复用 stop / start 流程
+// start 时保留原有的端口映射，并尽量保留原有 IP
+func restartContainer(containerName string, sleepTime int) error {
+	containerID, err := container.GetContainerIDByName(containerName)
+
+	if strings.Replace(containerID, " ", "", -1) == "" || err != nil {
+		return fmt.Errorf("Get containerID fail : %v", err)
+	}
+
+	// 获取containerInfo信息
+	containerInfo, err := container.GetContainerInfoByNameID(containerID)
+	if err != nil {
+		return fmt.Errorf("Get containerInfo fail : %v", err)
+	}
+
+	if containerInfo.Status.Running || containerInfo.Status.Paused || containerInfo.Status.Restarting {
+		if err := stopContainer(containerID, sleepTime); err != nil {
+			return err
+		}
+	}
+
+	if err := startContainer(containerID, false); err != nil {
+		return err
+	}
+
+	container.RecordContainerEvent("restart", containerInfo, nil)
+
+	return nil
+}
+
+// renameContainer 修改容器名
+// 同时修改 containernames.json ContainerInfo.Name 以及容器的 /etc/hostname
+func renameContainer(containerName, newName string) error {
+	containerID, err := container.GetContainerIDByName(containerName)
+
+	if strings.Replace(containerID, " ", "", -1) == "" || err != nil {
+		return fmt.Errorf("Get containerID fail : %v", err)
+	}
+
+	if strings.Replace(newName, " ", "", -1) == "" || strings.Contains(newName, "/") {
+		return fmt.Errorf("Invalid container name %v", newName)
+	}
+
+	// 获取containerInfo信息
+	containerInfo, err := container.GetContainerInfoByNameID(containerID)
+	if err != nil {
+		return fmt.Errorf("Get containerInfo fail : %v", err)
+	}
+
+	oldName := containerInfo.Name
+	if oldName == newName {
+		return fmt.Errorf("Container %v already has name %v", containerID, newName)
+	}
+
+	if err := renameContainerNameInfo(containerID, oldName, newName); err != nil {
+		return err
+	}
+
+	err = container.UpdateContainerInfo(containerID, func(latestInfo *container.ContainerInfo) (bool, error) {
+		latestInfo.Name = newName
+		containerInfo = latestInfo
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
 
+	// hostname 文件 bind mount 到容器的 /etc/hostname, 直接覆盖写入即可生效
+	// -h 指定了主机名时保留
+	if containerInfo.Hostname == "" {
+		hostnameFilePath := path.Join(container.ContainerDir, containerID, "hostname")
+		if err := ioutil.WriteFile(hostnameFilePath, []byte(newName), 0644); err != nil {
+			log.Warnf("Rename container %v hostname error %v", containerID, err)
+		}
+	}
+
+	log.Debugf("Rename container %v %v => %v success", containerID, oldName, newName)
+
+	container.RecordContainerEvent("rename", containerInfo, map[string]string{"oldName": oldName})
+
+	return nil
 }
 
 // removeContainer 删除容器
-func removeContainer(containerName string, Force, volume bool) {
+func removeContainer(containerName string, Force, volume bool) error {
 	containerID, err := container.GetContainerIDByName(containerName)
 
 	if strings.Replace(containerID, " ", "", -1) == "" || err != nil {
-		log.Errorf("Get containerID fail : %v", err)
-		return
+		return fmt.Errorf("Get containerID fail : %v", err)
 	}
 
 	log.Debugf("Get containerID success  id : %v", containerID)
@@ -109,28 +378,19 @@ func removeContainer(containerName string, Force, volume bool) {
 	// 获取containerInfo信息
 	containerInfo, err := container.GetContainerInfoByNameID(containerID)
 	if err != nil {
-		log.Errorf("Get containerInfo fail : %v", err)
-		return
+		return fmt.Errorf("Get containerInfo fail : %v", err)
 	}
 
 	// 容器running状态且未设置 Force
-	if containerInfo.Status.Running {
+	if containerInfo.Status.Running || containerInfo.Status.Paused || containerInfo.Status.Restarting {
 		if Force {
 			// 强制退出
-			stopContainer(containerName, 0)
+			if err := stopContainer(containerName, 0); err != nil {
+				return err
+			}
 
 		} else {
-			log.Errorf("Remove container %v fail, container is running", containerName)
-			return
-		}
-	}
-
-	// 容器是非正常状态 Dead
-	if containerInfo.Status.Dead {
-		// 断开网络连接
-		err = network.Disconnect(containerInfo.NetWorks.Network.ID, containerInfo)
-		if err != nil {
-			log.Errorf("Stop container %v network error %v", containerName, err)
+			return fmt.Errorf("Remove container %v fail, container is running", containerName)
 		}
 	}
 
@@ -148,27 +408,22 @@ func removeContainer(containerName string, Force, volume bool) {
 	// 所以无法删除 /sys/fs/cgroup/[subsystem]/qsrdocker/[containerID]
 	containerInfo.Cgroup.Destroy()
 
-	// 需要删除数据卷
-	if volume {
-		volumeMountInfoSlice := containerInfo.Mount
+	// 释放数据卷引用, -v 时删除不再被使用的匿名数据卷
+	// 命名数据卷由 qsrdocker volume rm 删除, bind mount 的宿主机目录不删除
+	container.ReleaseVolumes(containerID, containerInfo.Mount, volume)
 
-		for _, mountInfo := range volumeMountInfoSlice {
-			if err := os.RemoveAll(mountInfo.Source); err != nil {
-				log.Errorf("Remove Mount Bind Volume %v Error: %v", mountInfo.Source, err)
-			} else {
-				log.Debug("Remove Mount Bind Volume %v success", mountInfo.Source)
-			}
-		}
-	}
+	container.RecordContainerEvent("destroy", containerInfo, nil)
+
+	return nil
 }
 
 // startContainer 启动容器
-func startContainer(containerName string) {
+// attach 为前台 run -it, monitor 缓存客户端 attach 之前的输出
+func startContainer(containerName string, attach bool) error {
 	containerID, err := container.GetContainerIDByName(containerName)
 
 	if strings.Replace(containerID, " ", "", -1) == "" || err != nil {
-		log.Errorf("Get containerID fail : %v", err)
-		return
+		return fmt.Errorf("Get containerID fail : %v", err)
 	}
 
 	log.Debugf("Get containerID success  id : %v", containerID)
@@ -176,45 +431,194 @@ func startContainer(containerName string) {
 	// 获取containerInfo信息
 	containerInfo, err := container.GetContainerInfoByNameID(containerID)
 	if err != nil {
-		log.Errorf("Get containerInfo fail : %v", err)
-		return
-	}
-
-	// 容器是非正常状态 Dead
-	if containerInfo.Status.Dead {
-		// 断开网络连接
-		err = network.Disconnect(containerInfo.NetWorks.Network.ID, containerInfo)
-		if err != nil {
-			log.Errorf("Stop container %v network error %v", containerName, err)
-		}
+		return fmt.Errorf("Get containerInfo fail : %v", err)
 	}
 
 	// 判断容器状态
 	if containerInfo.Status.Running {
-		log.Errorf(" This container %v is running, can't not start", containerName)
-		return
+		return fmt.Errorf("This container %v is running, can't not start", containerName)
+	}
+
+	if containerInfo.Status.Paused {
+		return fmt.Errorf("This container %v is paused, unpause the container instead", containerName)
+	}
+
+	if containerInfo.Status.Restarting {
+		return fmt.Errorf("This container %v is restarting, can't not start", containerName)
 	}
 
 	// 检测挂载点是否存在异常
 	health, err := container.MountPointCheckFuncMap[containerInfo.GraphDriver.Driver](containerInfo.GraphDriver.Data)
 
 	if !health || err != nil {
-		log.Errorf(" Can't start container %v , workSpace is unhealthy", containerName)
-		return
+		return fmt.Errorf("Can't start container %v , workSpace is unhealthy", containerName)
 	}
 
-	// 获取管道通信
-	containerProcess, writeCmdPipe := StartParentProcess(containerInfo)
+	// 手动启动时重启次数清零
+	if containerInfo.RestartCount != 0 {
+		containerInfo.RestartCount = 0
+		if err := container.RecordContainerInfo(containerInfo, containerID); err != nil {
+			return err
+		}
+	}
 
-	if containerProcess == nil || writeCmdPipe == nil {
-		log.Errorf("New parent process error")
-		return
+	// 由 monitor 进程启动容器进程并等待其退出
+	// 容器进程是 monitor 的子进程, 与 qsrdocker / qsrdockerd 无关
+	if err := startMonitor(containerID, attach); err != nil {
+		return fmt.Errorf("Start container %v error : %v", containerName, err)
+	}
+
+	return nil
+}
+
+// pauseContainer 通过 freezer 冻结容器内所有进程，保留内存和网络状态
+func pauseContainer(containerName string) error {
+	containerID, err := container.GetContainerIDByName(containerName)
+
+	if strings.Replace(containerID, " ", "", -1) == "" || err != nil {
+		return fmt.Errorf("Get containerID fail : %v", err)
+	}
+
+	// 在 config.json 文件锁内冻结并记录状态, 避免与 monitor 的退出 OOM 健康检查记录互相覆盖
+	var pausedInfo *container.ContainerInfo
+	err = container.UpdateContainerInfo(containerID, func(containerInfo *container.ContainerInfo) (bool, error) {
+		if containerInfo.Status.Paused {
+			return false, fmt.Errorf("Container %v is already paused", containerName)
+		}
+
+		if !containerInfo.Status.Running {
+			return false, fmt.Errorf("Container %v is not running", containerName)
+		}
+
+		if err := containerInfo.Cgroup.Freeze(containerInfo.Status.Pid); err != nil {
+			return false, fmt.Errorf("Pause container %v error %v", containerName, err)
+		}
+
+		containerInfo.Status.StatusSet("Paused")
+		pausedInfo = containerInfo
+
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Pause container %v success", containerName)
+
+	container.RecordContainerEvent("pause", pausedInfo, nil)
+
+	return nil
+}
+
+// unpauseContainer 解冻容器内所有进程
+func unpauseContainer(containerName string) error {
+	containerID, err := container.GetContainerIDByName(containerName)
+
+	if strings.Replace(containerID, " ", "", -1) == "" || err != nil {
+		return fmt.Errorf("Get containerID fail : %v", err)
+	}
+
+	// 在 config.json 文件锁内解冻并记录状态
+	var unpausedInfo *container.ContainerInfo
+	err = container.UpdateContainerInfo(containerID, func(containerInfo *container.ContainerInfo) (bool, error) {
+		if !containerInfo.Status.Paused {
+			return false, fmt.Errorf("Container %v is not paused", containerName)
+		}
+
+		if err := containerInfo.Cgroup.Thaw(containerInfo.Status.Pid); err != nil {
+			return false, fmt.Errorf("Unpause container %v error %v", containerName, err)
+		}
+
+		containerInfo.Status.StatusSet("Running")
+		unpausedInfo = containerInfo
+
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Unpause container %v success", containerName)
+
+	container.RecordContainerEvent("unpause", unpausedInfo, nil)
+
+	return nil
+}
+
+// updateContainer 修改容器的资源限制, resConfig 中为空的值保持不变
+// 运行中的容器直接写入 cgroup, 并持久化到 config.json, start 时重新设置
+func updateContainer(containerName string, resConfig *subsystems.ResourceConfig) error {
+	containerID, err := container.GetContainerIDByName(containerName)
+
+	if strings.Replace(containerID, " ", "", -1) == "" || err != nil {
+		return fmt.Errorf("Get containerID fail : %v", err)
+	}
+
+	if err := resConfig.Validate(); err != nil {
+		return err
+	}
+
+	// 在 config.json 文件锁内修改, 避免 monitor 同时写入时丢失新的资源限制
+	var updatedInfo *container.ContainerInfo
+	err = container.UpdateContainerInfo(containerID, func(containerInfo *container.ContainerInfo) (bool, error) {
+		oldResConfig := containerInfo.Cgroup.Resource
+		newResConfig := *oldResConfig
+		newResConfig.Update(resConfig)
+
+		containerInfo.Cgroup.Resource = &newResConfig
+
+		// 写入运行中容器的 cgroup, 内核拒绝时恢复原有配置
+		if containerInfo.Status.Running || containerInfo.Status.Paused {
+			if err := containerInfo.Cgroup.Set(); err != nil {
+				containerInfo.Cgroup.Resource = oldResConfig
+				if rollbackErr := containerInfo.Cgroup.Set(); rollbackErr != nil {
+					log.Warnf("Restore container %v cgroup error %v", containerName, rollbackErr)
+				}
+				return false, fmt.Errorf("Update container %v resource error : %v", containerName, err)
+			}
+		}
+
+		updatedInfo = containerInfo
+
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Update container %v resource %+v", containerName, updatedInfo.Cgroup.Resource)
+
+	container.RecordContainerEvent("update", updatedInfo, nil)
+
+	return nil
+}
+
+// launchContainer 启动容器进程, 设置 cgroup 网络 并发送用户命令
+// 在 monitor 进程中执行，容器进程为 monitor 的子进程
+// console 为 pty slave, 后台运行的 tty 容器由 monitor 创建
+func launchContainer(containerInfo *container.ContainerInfo, console *os.File) (*exec.Cmd, error) {
+
+	containerID := containerInfo.ID
+
+	// 获取管道通信
+	containerProcess, configPipe, statusPipe, err := container.NewParentProcess(containerInfo, console)
+	if err != nil {
+		return nil, fmt.Errorf("New parent process error : %v", err)
 	}
 
 	log.Debugf("Get Qsrdocker : %v parent process and pipe success", containerID)
 
-	if err := containerProcess.Start(); err != nil { // 启动真正的容器进程
-		log.Error(err)
+	err = containerProcess.Start() // 启动真正的容器进程
+
+	// 关闭父进程中 init 进程一端的管道, init 进程退出后读取结果返回 EOF
+	for _, pipe := range containerProcess.ExtraFiles {
+		pipe.Close()
+	}
+
+	if err != nil {
+		configPipe.Close()
+		statusPipe.Close()
+		return nil, fmt.Errorf("Start container process error : %v", err)
 	}
 
 	log.Debugf("Create container process success, pis is %v ", containerProcess.Process.Pid)
@@ -223,130 +627,89 @@ func startContainer(containerName string) {
 	containerInfo.Status.StatusSet("Running")
 	containerInfo.Status.Pid = containerProcess.Process.Pid
 	containerInfo.Status.StartTime = time.Now().Format("2006-01-02 15:04:05")
+	containerInfo.Status.MonitorPid = os.Getpid()
+	containerInfo.Status.ExitCode = 0
+	containerInfo.Status.Signal = ""
+	containerInfo.Status.FinishedAt = ""
+	containerInfo.Status.OOMTime = ""
+
+	// 每次启动重新开始健康检查
+	containerInfo.Status.Health = nil
+	if containerInfo.HealthCheck.Enabled() {
+		containerInfo.Status.Health = container.NewHealth()
+	}
 
 	// 设置 cgroup
 	// init 和 set 操作英格
 	containerInfo.Cgroup.Init()
-	containerInfo.Cgroup.Set()
+	if err := containerInfo.Cgroup.Set(); err != nil {
+		log.Warnf("Set container %v cgroup error %v", containerID, err)
+	}
 	containerInfo.Cgroup.Apply(containerInfo.Status.Pid)
 
 	log.Debugf("Create cgroup config: %+v", containerInfo.Cgroup.Resource)
 
 	// 启动容器网络
-	err = network.Connect(containerInfo.NetWorks.Network.ID, containerInfo.NetWorks.Network.Driver, nil, containerInfo)
-	if err != nil {
-		log.Errorf("Start container %v network error %v", containerName, err)
+	if err := network.Connect(containerInfo.NetWorks.Network.ID, containerInfo.NetWorks.Network.Driver, nil, containerInfo); err != nil {
+		log.Errorf("Start container %v network error %v", containerInfo.Name, err)
 	}
 
-	// 将用户命令发送给 init container 进程
-	sendInitCommand(append([]string{containerInfo.Path}, containerInfo.Args...), writeCmdPipe)
-
-	// 将 containerInfo 存入
-	container.RecordContainerInfo(containerInfo, containerID)
-
-	fmt.Printf("%v\n", containerID)
-
-}
+	// 普通用户通过 newuidmap newgidmap 设置 user namespace 映射
+	if containerInfo.UseIDMapHelper() {
+		uidMap, gidMap := containerInfo.GetIDMappings()
+		err = container.WriteIDMappings(containerInfo.Status.Pid, uidMap, gidMap)
+	}
 
-// StartParentProcess 创建 container 的启动进程
-// 除了不设置 workspace 之外 其他的步骤和 container.NerParentProcess 基本一样
-// 只能写重复代码了
-func StartParentProcess(containerInfo *container.ContainerInfo) (*exec.Cmd, *os.File) {
+	// 将启动配置发送给 init container 进程, 等待 init 进程 exec 用户命令
+	var initConfig *container.InitConfig
+	if err == nil {
+		initConfig, err = container.NewInitConfig(containerInfo)
+	}
 
-	readCmdPipe, writeCmdPipe, err := container.NewPipe()
+	if err == nil {
+		err = container.SendInitConfig(configPipe, initConfig)
+	} else {
+		configPipe.Close()
+	}
 
-	if err != nil {
-		log.Errorf("Create New Cmd pipe err: %v", err)
-		return nil, nil
+	if err == nil {
+		err = container.WaitInitStatus(statusPipe)
+	} else {
+		statusPipe.Close()
 	}
 
-	// exec 方式直接运行 qsrdocker init
-	cmd := exec.Command("/proc/self/exe", "init") // 执行 initCmd
-	uid := syscall.Getuid()                       // 字符串转int
-	gid := syscall.Getgid()
-
-	log.Debugf("Get qsrdocker : %v uid : %v ; gid : %v", containerInfo.ID, uid, gid)
-
-	if err = container.InitUserNamespace(); err != nil {
-		log.Fatalf("UserNamespace err : %v", err)
-	}
-
-	// 设置 namespace
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWUTS |
-			syscall.CLONE_NEWIPC | // IPC 调用参数
-			syscall.CLONE_NEWPID |
-			syscall.CLONE_NEWNS | // 史上第一个 Namespace
-			syscall.CLONE_NEWUSER |
-			syscall.CLONE_NEWNET,
-		UidMappings: []syscall.SysProcIDMap{
-			{
-				ContainerID: 0, // 映射为root
-				HostID:      uid,
-				Size:        1,
-			},
-		},
-		GidMappings: []syscall.SysProcIDMap{
-			{
-				ContainerID: 0, // 映射为root
-				HostID:      gid,
-				Size:        1,
-			},
-		},
-		GidMappingsEnableSetgroups: false,
-	}
-
-	if containerInfo.NetWorks.Network.Driver == "host" {
-
-		// 除去 net ns
-		cmd.SysProcAttr.Cloneflags = (syscall.CLONE_NEWUTS |
-			syscall.CLONE_NEWIPC | // IPC 调用参数
-			syscall.CLONE_NEWPID |
-			syscall.CLONE_NEWNS | // 史上第一个 Namespace
-			syscall.CLONE_NEWUSER)
-	}
-
-	log.Debugf("Set NameSpace to qsrdocker : %v", containerInfo.ID)
-
-	// 容器信息目录 /[containerDir]/[containerID]/ 目录
-	containerDir := path.Join(container.ContainerDir, containerInfo.ID)
-
-	// 打开 log 文件
-	containerLogFile := path.Join(containerDir, container.ContainerLogFile)
-	logFileFd, err := os.Open(containerLogFile)
+	// 启动失败, init 进程已退出或被杀死, 清理网络和 cgroup, 不记录 Running 状态
 	if err != nil {
-		log.Errorf("Get log file %s error %v", containerLogFile, err)
-		return nil, nil
-	}
+		containerProcess.Process.Kill()
+		containerProcess.Wait()
 
-	// 将标准输出 错误 重定向到 log 文件中
-	cmd.Stdout = logFileFd
-	cmd.Stderr = logFileFd
+		if err := network.Disconnect(containerInfo.NetWorks.Network.ID, containerInfo); err != nil {
+			log.Errorf("Disconnect container %v network error %v", containerID, err)
+		}
+		containerInfo.Cgroup.Destroy()
 
-	// 传入管道问价读端fld
-	cmd.ExtraFiles = []*os.File{readCmdPipe}
-	// 一个进程的文件描d述符默认 0 1 2 代表 输入 输出 错误
-	// readCmdPipe 为外带的第四个文件描述符 下标为 3
+		return nil, fmt.Errorf("Start container process error : %v", err)
+	}
 
-	// 设置进程环境变量
-	cmd.Env = append([]string{}, containerInfo.Env...)
-	log.Debugf("Set container Env : %v", cmd.Env)
+	// 将 containerInfo 存入
+	if err := container.RecordContainerInfo(containerInfo, containerID); err != nil {
+		return containerProcess, err
+	}
 
-	// 设置进程运行目录
-	cmd.Dir = container.GetMountPathFuncMap[containerInfo.GraphDriver.Driver](containerInfo.GraphDriver.Data)
+	container.RecordContainerEvent("start", containerInfo, nil)
 
-	return cmd, writeCmdPipe
+	return containerProcess, nil
 }
 
-// LogContainer 输入 container log
-func logContainer(containerName string, tailline int, follow bool) {
+// logContainer 输出 container log 到 w
+// follow 时持续输出新日志, 直到 done 关闭
+func logContainer(w io.Writer, containerName string, tailline int, follow bool, done <-chan struct{}) error {
 
 	// 获取 container ID
 	containerID, err := container.GetContainerIDByName(containerName)
 
 	if strings.Replace(containerID, " ", "", -1) == "" || err != nil {
-		log.Errorf("Get containerID fail : %v", err)
-		return
+		return fmt.Errorf("Get containerID fail : %v", err)
 	}
 
 	// 获取 log 日志路径
@@ -354,19 +717,19 @@ func logContainer(containerName string, tailline int, follow bool) {
 
 	// log文件不存在则创建
 	if exist, err := container.PathExists(logFilePath); !exist || err != nil {
-		_, err := os.Create(logFilePath)
+		logFile, err := os.Create(logFilePath)
 		if err != nil {
-			log.Errorf("Can't find log file %s error %v", logFilePath, err)
-			return
+			return fmt.Errorf("Can't find log file %s error %v", logFilePath, err)
 		}
+		// qsrdockerd 常驻, 需要关闭
+		logFile.Close()
 	}
 
 	// 获取 log 文件信息
 	fileInfo, err := os.Stat(logFilePath)
 
 	if err != nil {
-		log.Errorf("Open log file err : %v", err)
-		return
+		return fmt.Errorf("Open log file err : %v", err)
 	}
 
 	fileOffSet := fileInfo.Size()
@@ -387,7 +750,7 @@ func logContainer(containerName string, tailline int, follow bool) {
 			currLines, _, currOffset, _ = readLines(logFilePath, currOffset, 10000)
 			// 逐行打印到终端
 			for _, line := range currLines {
-				fmt.Fprint(os.Stdout, strings.Join([]string{line, "\n"}, ""))
+				fmt.Fprint(w, strings.Join([]string{line, "\n"}, ""))
 			}
 			// 如果文件读取完毕
 			if currOffset >= fileOffSet {
@@ -416,53 +779,63 @@ func logContainer(containerName string, tailline int, follow bool) {
 		if currLineCount >= tailline {
 			// 打印 末尾 tailline 行
 			for _, line := range currLines[(currLineCount - tailline):] {
-				fmt.Fprint(os.Stdout, strings.Join([]string{line, "\n"}, ""))
+				fmt.Fprint(w, strings.Join([]string{line, "\n"}, ""))
 			}
 		} else {
 			// 打印 preLines 中的数据
 			if (sliceCount + currLineCount) < tailline {
 				// 全部读取
 				for _, line := range preLines {
-					fmt.Fprint(os.Stdout, strings.Join([]string{line, "\n"}, ""))
+					fmt.Fprint(w, strings.Join([]string{line, "\n"}, ""))
 				}
 			} else {
 				// 从 preLines 中读取部分
 				for _, line := range preLines[(currLineCount - 1):] {
-					fmt.Fprint(os.Stdout, strings.Join([]string{line, "\n"}, ""))
+					fmt.Fprint(w, strings.Join([]string{line, "\n"}, ""))
 				}
 			}
 
 			// 打印全部 currLines中的数据
 			for _, line := range currLines {
-				fmt.Fprint(os.Stdout, line)
+				fmt.Fprint(w, line)
 			}
 		}
 	}
 
+	if !follow {
+		return nil
+	}
+
 	// tail -f 开启
-	if follow {
-		// 使用 tail 组件
-		t, err := tail.TailFile(logFilePath, tail.Config{
-
-			ReOpen:    follow,                                                  // true则文件被删掉阻塞等待新建该文件，false则文件被删掉时程序结束 tail -F
-			Poll:      true,                                                    // 使用Linux的Poll函数，poll的作用是把当前的文件指针挂到等待队列
-			Follow:    follow,                                                  // true则一直阻塞并监听指定文件，false则一次读完就结束程序 tail -f
-			MustExist: false,                                                   // true则没有找到文件就报错并结束，false则没有找到文件就阻塞保持住
-			Location:  &tail.SeekInfo{Offset: currOffset, Whence: os.SEEK_SET}, // 从 all/tail -t 操作读取完毕的位置开始读取
-		})
+	// 使用 tail 组件
+	t, err := tail.TailFile(logFilePath, tail.Config{
 
-		if err != nil {
-			log.Errorf("Open log file err : %v", err) //如果文件不存在，会阻塞并打印Waiting for xxx.log to appear...，直到文件被创建
-		}
-		// 从 chan 管道读取
-		for line := range t.Lines {
-			// 打印到终端
-			fmt.Fprint(os.Stdout, strings.Join([]string{line.Text, "\n"}, ""))
-			//fmt.Fprint(os.Stdout, line.Text)
-		}
+		ReOpen:    follow,                                                  // true则文件被删掉阻塞等待新建该文件，false则文件被删掉时程序结束 tail -F
+		Poll:      true,                                                    // 使用Linux的Poll函数，poll的作用是把当前的文件指针挂到等待队列
+		Follow:    follow,                                                  // true则一直阻塞并监听指定文件，false则一次读完就结束程序 tail -f
+		MustExist: false,                                                   // true则没有找到文件就报错并结束，false则没有找到文件就阻塞保持住
+		Location:  &tail.SeekInfo{Offset: currOffset, Whence: os.SEEK_SET}, // 从 all/tail -t 操作读取完毕的位置开始读取
+		Logger:    tail.DiscardingLogger,
+	})
 
+	if err != nil {
+		return fmt.Errorf("Open log file err : %v", err)
+	}
+	defer t.Cleanup()
+	defer t.Stop()
+
+	// 从 chan 管道读取, 客户端断开后结束
+	for {
+		select {
+		case line, ok := <-t.Lines:
+			if !ok {
+				return t.Err()
+			}
+			fmt.Fprint(w, strings.Join([]string{line.Text, "\n"}, ""))
+		case <-done:
+			return nil
+		}
 	}
-
 }
 
 // readLines 按行读取
@@ -504,38 +877,7 @@ func readLines(path string, offSet int64, maxline int) ([]string, int, int64, er
 }
 
 // ListContainers 列出container信息
-func listContainers(all bool) {
-	// 获取 ContainerDir 下的文件
-	containerDirs, err := ioutil.ReadDir(container.ContainerDir)
-	if err != nil {
-		log.Errorf("Read dir %s error %v", container.ContainerDir, err)
-		return
-	}
-
-	var containerInfos []*container.ContainerInfo
-
-	// 遍历所有文件
-	for _, dir := range containerDirs {
-
-		// 若获取 containernames.json ，则直接 continue
-		if dir.Name() == container.ContainerNameFile {
-			continue
-		}
-
-		// 获取 containerInfo
-		tmpContainerInfo, err := container.GetContainerInfo(dir)
-		if err != nil {
-			log.Errorf("Get container info error %v", err)
-			continue
-		}
-
-		// 若无 -a ，则不显示 running 状态之外的 containerinfo
-		if !all && !tmpContainerInfo.Status.Running {
-			continue
-		}
-
-		containerInfos = append(containerInfos, tmpContainerInfo)
-	}
+func listContainers(containerInfos []*container.ContainerInfo) {
 
 	// 使用 tabwriter.NewWriter 在 终端 打出容器信息，打印对齐的表格
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
@@ -546,7 +888,13 @@ func listContainers(all bool) {
 			info.Image,
 			info.Name,
 			info.Status.Pid,
-			info.Status.Status,
+			// 设置了健康检查时显示健康状态 Running (healthy)
+			func(status *container.StatusInfo) string {
+				if status.Running && status.Health != nil {
+					return fmt.Sprintf("%s (%s)", status.Status, status.Health.Status)
+				}
+				return status.Status
+			}(info.Status),
 			//  path + args
 			strings.Join(append([]string{info.Path}, info.Args...), " "),
 			// 匿名函数
@@ -595,8 +943,50 @@ func listContainers(all bool) {
 	}
 }
 
+// getContainerInfos 获取所有 container info
+// all 为 false 时只返回 running 状态的容器
+func getContainerInfos(all bool) ([]*container.ContainerInfo, error) {
+	// 还未创建过容器
+	if exist, _ := container.PathExists(container.ContainerDir); !exist {
+		return nil, nil
+	}
+
+	// 获取 ContainerDir 下的文件
+	containerDirs, err := ioutil.ReadDir(container.ContainerDir)
+	if err != nil {
+		return nil, fmt.Errorf("Read dir %s error %v", container.ContainerDir, err)
+	}
+
+	var containerInfos []*container.ContainerInfo
+
+	// 遍历所有文件
+	for _, dir := range containerDirs {
+
+		// 若获取 containernames.json ，则直接 continue
+		if dir.Name() == container.ContainerNameFile {
+			continue
+		}
+
+		// 获取 containerInfo
+		tmpContainerInfo, err := container.GetContainerInfo(dir)
+		if err != nil {
+			log.Errorf("Get container info error %v", err)
+			continue
+		}
+
+		// 若无 -a ，则不显示 running 状态之外的 containerinfo
+		if !all && !tmpContainerInfo.Status.Running && !tmpContainerInfo.Status.Paused {
+			continue
+		}
+
+		containerInfos = append(containerInfos, tmpContainerInfo)
+	}
+
+	return containerInfos, nil
+}
+
 // CommitContainer 导出容器分层镜像
-func CommitContainer(containerName, imageNameTag string) {
+func CommitContainer(containerName, imageNameTag string) error {
 
 	// imagename imagetag
 	var imageName string
@@ -624,8 +1014,7 @@ func CommitContainer(containerName, imageNameTag string) {
 	containerID, err := container.GetContainerIDByName(containerName)
 
 	if strings.Replace(containerID, " ", "", -1) == "" || err != nil {
-		log.Errorf("Get containerID fail : %v", err)
-		return
+		return fmt.Errorf("Get containerID fail : %v", err)
 	}
 
 	log.Debugf("Get containerID success  id : %v", containerID)
@@ -633,8 +1022,7 @@ func CommitContainer(containerName, imageNameTag string) {
 	// 获取containerInfo信息
 	containerInfo, err := container.GetContainerInfoByNameID(containerID)
 	if err != nil {
-		log.Errorf("Get containerInfo fail : %v", err)
-		return
+		return fmt.Errorf("Get containerInfo fail : %v", err)
 	}
 
 	if containerInfo.Status.Running {
@@ -649,6 +1037,13 @@ func CommitContainer(containerName, imageNameTag string) {
 		Path: containerInfo.Path,
 		Args: containerInfo.Args,
 		Env:  containerInfo.Env,
+		// 保留容器的停止信号
+		StopSignal: containerInfo.StopSignal,
+		// 保留容器的健康检查配置
+		HealthCheck: containerInfo.HealthCheck,
+		// 保留容器的工作目录与运行用户
+		WorkingDir: containerInfo.WorkingDir,
+		User:       containerInfo.User,
 	}
 
 	container.RecordContainerInfo(containerInfo, containerID)
@@ -667,8 +1062,7 @@ func CommitContainer(containerName, imageNameTag string) {
 	//ReadFile函数会读取文件的全部内容，并将结果以[]byte类型返回
 	lowerInfoBytes, err := ioutil.ReadFile(lowerPath)
 	if err != nil {
-		log.Errorf("Can't open lower  : %v", lowerPath)
-		return
+		return fmt.Errorf("Can't open lower  : %v", lowerPath)
 	}
 
 	// 获取 lower 层信息
@@ -678,13 +1072,26 @@ func CommitContainer(containerName, imageNameTag string) {
 	imageTarPath := path.Join(container.ImageDir, imageID)
 	imageTarPath = strings.Join([]string{imageTarPath, ".tar"}, "")
 
+	// 容器层的属主转换为容器内的 ID, 解压时再按照映射转换
+	uidMap, gidMap := containerInfo.GetIDMappings()
+	ownerMapArgs, err := container.TarOwnerMapArgs(mountPath, path.Join(container.ContainerDir, containerID), uidMap, gidMap)
+	if err != nil {
+		return err
+	}
+
 	// 运行命令，并返回标准输出和标准错误
-	if _, err := exec.Command("tar", "-czf", imageTarPath, "-C", mountPath, ".").CombinedOutput(); err != nil {
-		log.Errorf("Tar folder %s error %v", mountPath, err)
+	tarArgs := append(ownerMapArgs, "-czf", imageTarPath, "-C", mountPath, ".")
+	if _, err := exec.Command("tar", tarArgs...).CombinedOutput(); err != nil {
+		return fmt.Errorf("Tar folder %s error %v", mountPath, err)
 	}
 
 	recordImageInfo(imageName, imageTag, lowerInfo)
 
+	imageRef := strings.Join([]string{imageName, imageTag}, ":")
+	container.RecordContainerEvent("commit", containerInfo, map[string]string{"comment": imageRef})
+	container.RecordEvent(container.EventTypeImage, "commit", imageID, map[string]string{"name": imageRef, "container": containerID})
+
+	return nil
 }
 
 // randStringImageID 随机获取镜像id
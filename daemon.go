@@ -0,0 +1,727 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"qsrdocker/cgroups/subsystems"
+	"qsrdocker/container"
+	"qsrdocker/network"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// qsrdockerd 相关参数
+const (
+	// DaemonName 以该名称运行 (软链接) 时直接启动 daemon
+	DaemonName = "qsrdockerd"
+	// DaemonSocket qsrdockerd 监听的 unix socket
+	DaemonSocket = "/run/qsrdocker.sock"
+	// APIVersion REST API 版本, 所有路由以 /[APIVersion]/ 开头
+	APIVersion = "v1"
+)
+
+// daemonCmd 启动 qsrdockerd 守护进程
+var daemonCmd = cli.Command{
+	Name:      "daemon",
+	Usage:     "Run qsrdockerd, serve the REST API on " + DaemonSocket,
+	ArgsUsage: "[]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "debug", // 开启 debug 日志
+			Usage: `Enable debug log`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.Bool("debug") {
+			log.SetLevel(log.DebugLevel)
+		}
+		return QsrdockerDaemon(DaemonSocket)
+	},
+}
+
+// apiError API 错误返回体
+type apiError struct {
+	Message string `json:"message"`
+}
+
+// apiNetworkCreate 创建网络的请求体
+type apiNetworkCreate struct {
+	Name   string `json:"Name"`
+	Driver string `json:"Driver"`
+	Subnet string `json:"Subnet"`
+}
+
+// apiVolumeCreate 创建数据卷的请求体
+type apiVolumeCreate struct {
+	Name   string            `json:"Name"`
+	Driver string            `json:"Driver"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// apiVolumePruneResponse 清理数据卷的返回体
+type apiVolumePruneResponse struct {
+	VolumesDeleted []string `json:"VolumesDeleted"`
+}
+
+// apiCommit commit 容器的请求体
+type apiCommit struct {
+	Image string `json:"Image"`
+}
+
+// apiRunResponse run 容器的返回体
+type apiRunResponse struct {
+	ID string `json:"ID"`
+}
+
+// apiWaitResponse wait 容器的返回体
+type apiWaitResponse struct {
+	StatusCode int `json:"StatusCode"`
+}
+
+// apiTopResponse top 的返回体
+type apiTopResponse struct {
+	Titles    []string   `json:"Titles"`
+	Processes [][]string `json:"Processes"`
+}
+
+// apiVersion version 返回体
+type apiVersion struct {
+	Version    string `json:"Version"`
+	APIVersion string `json:"ApiVersion"`
+}
+
+// qsrdockerDaemon REST API 服务
+type qsrdockerDaemon struct {
+	// 串行化所有会修改容器/网络状态的操作
+	// 所有操作都直接读写 RootDir 下的 json 文件，并发执行会互相覆盖
+	lock sync.Mutex
+	// daemon 版本
+	version string
+}
+
+// QsrdockerDaemon 启动 qsrdockerd，在 unix socket 上提供 REST API
+func QsrdockerDaemon(socketPath string) error {
+
+	// 清理上一次异常退出残留的 socket 文件
+	if exist, _ := container.PathExists(socketPath); exist {
+		if err := os.Remove(socketPath); err != nil {
+			return fmt.Errorf("Remove old socket %v error : %v", socketPath, err)
+		}
+	}
+
+	if err := os.MkdirAll(path.Dir(socketPath), 0755); err != nil {
+		return fmt.Errorf("Mkdir socket dir %v error : %v", path.Dir(socketPath), err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("Listen on %v error : %v", socketPath, err)
+	}
+
+	// 仅 root 和同组用户可访问
+	if err := os.Chmod(socketPath, 0660); err != nil {
+		log.Warnf("Chmod socket %v error : %v", socketPath, err)
+	}
+
+	// iptables 和 网络初始化 只需要在启动时执行一次
+	if err := network.IPtablesInit(); err != nil {
+		log.Warnf("Init iptables error : %v", err)
+	}
+	network.InitNetwork()
+
+	// 按照重启策略恢复容器 (如宿主机重启后)
+	restoreContainers()
+
+	daemon := &qsrdockerDaemon{version: Version}
+	server := &http.Server{Handler: daemon}
+
+	// 收到 SIGINT / SIGTERM 时关闭服务
+	// 容器进程不依赖 qsrdockerd，daemon 退出后继续运行
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigChan
+		log.Warnf("Get signal %v, qsrdockerd exit", sig)
+		server.Close()
+	}()
+
+	log.Warnf("qsrdockerd %v listen on unix://%v", Version, socketPath)
+
+	err = server.Serve(listener)
+	os.Remove(socketPath)
+
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// restoreContainers qsrdockerd 启动时按照重启策略恢复未运行的容器
+// always: 未运行即启动; unless-stopped: 未被 qsrdocker stop 停止时启动
+func restoreContainers() {
+	containerInfos, err := getContainerInfos(true)
+	if err != nil {
+		log.Warnf("Restore containers error : %v", err)
+		return
+	}
+
+	for _, containerInfo := range containerInfos {
+		if containerInfo.Restart == nil || containerInfo.Status.Running || containerInfo.Status.Restarting {
+			continue
+		}
+
+		switch containerInfo.Restart.Name {
+		case container.RestartPolicyAlways:
+		case container.RestartPolicyUnlessStopped:
+			if containerInfo.Status.Stopped {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if err := startContainer(containerInfo.ID, false); err != nil {
+			log.Warnf("Restore container %v error : %v", containerInfo.ID, err)
+			continue
+		}
+
+		log.Debugf("Restore container %v with restart policy %v", containerInfo.ID, containerInfo.Restart)
+	}
+}
+
+// ServeHTTP 路由分发
+// GET    /v1/version
+// GET    /v1/containers?all=1
+// POST   /v1/containers                      run
+// POST   /v1/containers/create               create
+// GET    /v1/containers/[name]               inspect
+// DELETE /v1/containers/[name]?force=1&volumes=1
+// GET    /v1/containers/[name]/logs?tail=10&follow=1
+// GET    /v1/containers/[name]/attach        非 tty 容器的新日志
+// GET    /v1/containers/[name]/top?ps_args=-ef
+// GET    /v1/containers/[name]/stats
+// POST   /v1/containers/[name]/start?attach=1
+// POST   /v1/containers/[name]/stop?t=10
+// POST   /v1/containers/[name]/restart?t=10
+// POST   /v1/containers/[name]/wait
+// POST   /v1/containers/[name]/rename?name=newName
+// POST   /v1/containers/[name]/kill?signal=SIGKILL
+// POST   /v1/containers/[name]/pause
+// POST   /v1/containers/[name]/unpause
+// POST   /v1/containers/[name]/commit
+// GET    /v1/networks
+// POST   /v1/networks
+// DELETE /v1/networks/[name]
+// GET    /v1/volumes
+// POST   /v1/volumes
+// GET    /v1/volumes/[name]
+// DELETE /v1/volumes/[name]
+// POST   /v1/volumes/prune?all=1
+// GET    /v1/events?since=&until=&filter=type=container&follow=1
+func (daemon *qsrdockerDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	log.Debugf("API %v %v", r.Method, r.URL.String())
+
+	// /v1/containers/name/start => [v1 containers name start]
+	paths := container.RemoveNullSliceString(strings.Split(r.URL.Path, "/"))
+
+	if len(paths) < 2 || paths[0] != APIVersion {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("Unsupported API path %v, use /%v/", r.URL.Path, APIVersion))
+		return
+	}
+
+	switch paths[1] {
+	case "version":
+		writeAPIJSON(w, http.StatusOK, &apiVersion{Version: daemon.version, APIVersion: APIVersion})
+	case "containers":
+		daemon.serveContainers(w, r, paths[2:])
+	case "networks":
+		daemon.serveNetworks(w, r, paths[2:])
+	case "volumes":
+		daemon.serveVolumes(w, r, paths[2:])
+	case "events":
+		daemon.serveEvents(w, r)
+	default:
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("Unsupported API path %v", r.URL.Path))
+	}
+}
+
+// serveContainers /v1/containers 相关路由
+func (daemon *qsrdockerDaemon) serveContainers(w http.ResponseWriter, r *http.Request, paths []string) {
+
+	switch {
+	// GET /v1/containers
+	case len(paths) == 0 && r.Method == http.MethodGet:
+		containerInfos, err := getContainerInfos(queryBool(r, "all"))
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if containerInfos == nil {
+			containerInfos = []*container.ContainerInfo{}
+		}
+		writeAPIJSON(w, http.StatusOK, containerInfos)
+
+	// POST /v1/containers
+	case len(paths) == 0 && r.Method == http.MethodPost:
+		runConfig := &container.RunConfig{}
+		if err := json.NewDecoder(r.Body).Decode(runConfig); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Decode run config error : %v", err))
+			return
+		}
+
+		// API 只支持后台运行, -it 容器需要同时指定 -d, 由 monitor 持有 pty
+		if runConfig.Tty && !runConfig.Detach {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Tty container can't run by qsrdockerd without detach"))
+			return
+		}
+
+		daemon.lock.Lock()
+		containerID, err := QsrdockerRun(runConfig)
+		daemon.lock.Unlock()
+
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAPIJSON(w, http.StatusCreated, &apiRunResponse{ID: containerID})
+
+	// POST /v1/containers/create
+	case len(paths) == 1 && paths[0] == "create" && r.Method == http.MethodPost:
+		runConfig := &container.RunConfig{}
+		if err := json.NewDecoder(r.Body).Decode(runConfig); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Decode run config error : %v", err))
+			return
+		}
+
+		// create 的容器总是由 monitor 启动
+		runConfig.Detach = true
+
+		daemon.lock.Lock()
+		containerID, err := QsrdockerCreate(runConfig)
+		daemon.lock.Unlock()
+
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAPIJSON(w, http.StatusCreated, &apiRunResponse{ID: containerID})
+
+	// GET /v1/containers/[name]
+	case len(paths) == 1 && r.Method == http.MethodGet:
+		containerInfo, err := inspectContainer(paths[0])
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, err)
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, containerInfo)
+
+	// DELETE /v1/containers/[name]
+	case len(paths) == 1 && r.Method == http.MethodDelete:
+		daemon.lock.Lock()
+		err := removeContainer(paths[0], queryBool(r, "force"), queryBool(r, "volumes"))
+		daemon.lock.Unlock()
+
+		writeAPIResult(w, err)
+
+	// GET /v1/containers/[name]/[logs|attach|top|stats]
+	case len(paths) == 2 && r.Method == http.MethodGet:
+		daemon.serveContainerRead(w, r, paths[0], paths[1])
+
+	// POST /v1/containers/[name]/[action]
+	case len(paths) == 2 && r.Method == http.MethodPost:
+		daemon.serveContainerAction(w, r, paths[0], paths[1])
+
+	default:
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("Unsupported API %v %v", r.Method, r.URL.Path))
+	}
+}
+
+// serveContainerAction POST /v1/containers/[name]/[action]
+func (daemon *qsrdockerDaemon) serveContainerAction(w http.ResponseWriter, r *http.Request, containerName, action string) {
+
+	var err error
+
+	switch action {
+	case "start":
+		daemon.lock.Lock()
+		err = startContainer(containerName, queryBool(r, "attach"))
+		daemon.lock.Unlock()
+
+	case "stop":
+		sleepTime := 10
+		if t := r.URL.Query().Get("t"); t != "" {
+			if sleepTime, err = strconv.Atoi(t); err != nil || sleepTime < 0 {
+				writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Invalid stop time %v", t))
+				return
+			}
+		}
+
+		daemon.lock.Lock()
+		err = stopContainer(containerName, sleepTime)
+		daemon.lock.Unlock()
+
+	case "restart":
+		sleepTime := 10
+		if t := r.URL.Query().Get("t"); t != "" {
+			if sleepTime, err = strconv.Atoi(t); err != nil || sleepTime < 0 {
+				writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Invalid stop time %v", t))
+				return
+			}
+		}
+
+		daemon.lock.Lock()
+		err = restartContainer(containerName, sleepTime)
+		daemon.lock.Unlock()
+
+	case "wait":
+		// 阻塞直到容器退出，不能加锁, 客户端断开后停止等待
+		exitCode, err := waitContainer(containerName, r.Context().Done())
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, &apiWaitResponse{StatusCode: exitCode})
+		return
+
+	case "rename":
+		daemon.lock.Lock()
+		err = renameContainer(containerName, r.URL.Query().Get("name"))
+		daemon.lock.Unlock()
+
+	case "kill":
+		signal := r.URL.Query().Get("signal")
+		if signal == "" {
+			signal = "SIGKILL"
+		}
+
+		// 不修改容器状态，无需加锁
+		err = killContainer(containerName, signal)
+
+	case "pause":
+		daemon.lock.Lock()
+		err = pauseContainer(containerName)
+		daemon.lock.Unlock()
+
+	case "unpause":
+		daemon.lock.Lock()
+		err = unpauseContainer(containerName)
+		daemon.lock.Unlock()
+
+	case "update":
+		resConfig := &subsystems.ResourceConfig{}
+		if err := json.NewDecoder(r.Body).Decode(resConfig); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Invalid resource config : %v", err))
+			return
+		}
+
+		daemon.lock.Lock()
+		err = updateContainer(containerName, resConfig)
+		daemon.lock.Unlock()
+
+	case "commit":
+		commit := &apiCommit{}
+		if err := json.NewDecoder(r.Body).Decode(commit); err != nil || commit.Image == "" {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Missing image name"))
+			return
+		}
+
+		daemon.lock.Lock()
+		err = CommitContainer(containerName, commit.Image)
+		daemon.lock.Unlock()
+
+	default:
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("Unsupported container action %v", action))
+		return
+	}
+
+	writeAPIResult(w, err)
+}
+
+// serveContainerRead GET /v1/containers/[name]/[action]
+// 只读取容器信息, 不需要加锁; logs attach 持续输出直到客户端断开
+func (daemon *qsrdockerDaemon) serveContainerRead(w http.ResponseWriter, r *http.Request, containerName, action string) {
+
+	switch action {
+	case "logs":
+		tail := 0
+		if t := r.URL.Query().Get("tail"); t != "" {
+			var err error
+			if tail, err = strconv.Atoi(t); err != nil || tail < 0 {
+				writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Invalid tail %v", t))
+				return
+			}
+		}
+
+		stream := &apiStreamWriter{w: w}
+		writeAPIStream(stream, logContainer(stream, containerName, tail, queryBool(r, "follow"), r.Context().Done()))
+
+	case "attach":
+		containerID, err := container.GetContainerIDByName(containerName)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, err)
+			return
+		}
+
+		stream := &apiStreamWriter{w: w}
+		writeAPIStream(stream, followContainerLog(stream, containerID, r.Context().Done()))
+
+	case "top":
+		top, err := topContainer(containerName, r.URL.Query()["ps_args"])
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, top)
+
+	case "stats":
+		stats, err := getRunningContainerStats(containerName)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, stats)
+
+	default:
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("Unsupported container API %v", action))
+	}
+}
+
+// serveEvents GET /v1/events, 每行一个 json 事件, follow 时持续输出直到客户端断开
+func (daemon *qsrdockerDaemon) serveEvents(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("Unsupported API %v %v", r.Method, r.URL.Path))
+		return
+	}
+
+	query := r.URL.Query()
+	now := time.Now()
+
+	var since, until time.Time
+	var err error
+
+	if query.Get("since") != "" {
+		if since, err = container.ParseEventTime(query.Get("since"), now); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if query.Get("until") != "" {
+		if until, err = container.ParseEventTime(query.Get("until"), now); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	eventFilter, err := container.ParseEventFilter(query["filter"])
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	stream := &apiStreamWriter{w: w}
+	writeAPIStream(stream, streamEvents(stream, since, until, eventFilter, queryBool(r, "follow"), r.Context().Done()))
+}
+
+// serveNetworks /v1/networks 相关路由
+func (daemon *qsrdockerDaemon) serveNetworks(w http.ResponseWriter, r *http.Request, paths []string) {
+
+	switch {
+	// GET /v1/networks
+	case len(paths) == 0 && r.Method == http.MethodGet:
+		writeAPIJSON(w, http.StatusOK, getNetworks())
+
+	// POST /v1/networks
+	case len(paths) == 0 && r.Method == http.MethodPost:
+		nwCreate := &apiNetworkCreate{}
+		if err := json.NewDecoder(r.Body).Decode(nwCreate); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Decode network config error : %v", err))
+			return
+		}
+
+		if nwCreate.Name == "" || strings.Replace(nwCreate.Subnet, " ", "", -1) == "" {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Missing network name or CIDR"))
+			return
+		}
+
+		if nwCreate.Driver == "" {
+			nwCreate.Driver = container.DefaultNetworkDriver
+		}
+
+		daemon.lock.Lock()
+		err := network.CreateNetwork(nwCreate.Driver, nwCreate.Subnet, nwCreate.Name)
+		daemon.lock.Unlock()
+
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError,
+				fmt.Errorf("Create network %v in driver %v error: %v", nwCreate.Name, nwCreate.Driver, err))
+			return
+		}
+		writeAPIJSON(w, http.StatusCreated, nil)
+
+	// DELETE /v1/networks/[name]
+	case len(paths) == 1 && r.Method == http.MethodDelete:
+		daemon.lock.Lock()
+		err := network.DeleteNetwork(paths[0])
+		daemon.lock.Unlock()
+
+		if err != nil {
+			err = fmt.Errorf("Remove network %v error: %v", paths[0], err)
+		}
+		writeAPIResult(w, err)
+
+	default:
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("Unsupported API %v %v", r.Method, r.URL.Path))
+	}
+}
+
+// serveVolumes /v1/volumes 相关路由
+func (daemon *qsrdockerDaemon) serveVolumes(w http.ResponseWriter, r *http.Request, paths []string) {
+
+	switch {
+	// GET /v1/volumes
+	case len(paths) == 0 && r.Method == http.MethodGet:
+		volumes, err := container.ListVolumes()
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, volumes)
+
+	// POST /v1/volumes
+	case len(paths) == 0 && r.Method == http.MethodPost:
+		volumeCreate := &apiVolumeCreate{}
+		if err := json.NewDecoder(r.Body).Decode(volumeCreate); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("Decode volume config error : %v", err))
+			return
+		}
+
+		daemon.lock.Lock()
+		volume, err := container.CreateVolume(volumeCreate.Name, volumeCreate.Driver, volumeCreate.Labels)
+		daemon.lock.Unlock()
+
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, fmt.Errorf("Create volume %v error: %v", volumeCreate.Name, err))
+			return
+		}
+		writeAPIJSON(w, http.StatusCreated, volume)
+
+	// POST /v1/volumes/prune
+	case len(paths) == 1 && paths[0] == "prune" && r.Method == http.MethodPost:
+		daemon.lock.Lock()
+		pruned, err := container.PruneVolumes(queryBool(r, "all"))
+		daemon.lock.Unlock()
+
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, fmt.Errorf("Prune volumes error: %v", err))
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, &apiVolumePruneResponse{VolumesDeleted: pruned})
+
+	// GET /v1/volumes/[name]
+	case len(paths) == 1 && r.Method == http.MethodGet:
+		volume, err := container.GetVolume(paths[0])
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, fmt.Errorf("Get volume %v error: %v", paths[0], err))
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, volume)
+
+	// DELETE /v1/volumes/[name]
+	case len(paths) == 1 && r.Method == http.MethodDelete:
+		daemon.lock.Lock()
+		err := container.RemoveVolume(paths[0])
+		daemon.lock.Unlock()
+
+		writeAPIResult(w, err)
+
+	default:
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("Unsupported API %v %v", r.Method, r.URL.Path))
+	}
+}
+
+// queryBool 获取 bool 类型的 query 参数
+func queryBool(r *http.Request, key string) bool {
+	value, _ := strconv.ParseBool(r.URL.Query().Get(key))
+	return value
+}
+
+// writeAPIJSON 返回 json 数据
+func writeAPIJSON(w http.ResponseWriter, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if data == nil {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Errorf("Write API response error : %v", err)
+	}
+}
+
+// writeAPIError 返回错误信息
+func writeAPIError(w http.ResponseWriter, code int, err error) {
+	log.Debugf("API error : %v", err)
+	writeAPIJSON(w, code, &apiError{Message: err.Error()})
+}
+
+// apiStreamWriter 持续输出的 API, 每次写入后立即发送给客户端
+// 尚未写入数据时出错仍可以返回错误信息
+type apiStreamWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+// Write 第一次写入时返回 200
+func (stream *apiStreamWriter) Write(data []byte) (int, error) {
+	if !stream.written {
+		stream.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		stream.w.WriteHeader(http.StatusOK)
+		stream.written = true
+	}
+
+	n, err := stream.w.Write(data)
+	if flusher, ok := stream.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+// writeAPIStream 输出结束后根据 err 返回错误信息, 已经输出数据时只记录日志
+func writeAPIStream(stream *apiStreamWriter, err error) {
+	if stream.written {
+		if err != nil {
+			log.Warnf("API stream error : %v", err)
+		}
+		return
+	}
+
+	if err != nil {
+		writeAPIError(stream.w, http.StatusInternalServerError, err)
+		return
+	}
+	stream.w.WriteHeader(http.StatusOK)
+}
+
+// writeAPIResult 根据 err 返回 204 或者错误信息
+func writeAPIResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
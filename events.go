@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"qsrdocker/container"
+	"time"
+
+	"github.com/hpcloud/tail"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// eventsCmd 打印事件日志
+var eventsCmd = cli.Command{
+	Name:  "events",
+	Usage: "Get real time events from containers, networks, images and volumes",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "Show all events created since timestamp",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Usage: "Stream events until this timestamp",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter", // 多个 --filter
+			Usage: "Filter output based on conditions provided, like type=container,name=xxx",
+		},
+		cli.BoolFlag{
+			Name:  "f,follow", // 持续输出新的事件
+			Usage: "Follow new events",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print events as json lines",
+		},
+	},
+	Action: func(context *cli.Context) error {
+
+		now := time.Now()
+
+		// 相对时间以当前时间计算后发送给 qsrdockerd
+		query := url.Values{}
+		for _, key := range []string{"since", "until"} {
+			if context.String(key) == "" {
+				continue
+			}
+			eventTime, err := container.ParseEventTime(context.String(key), now)
+			if err != nil {
+				return err
+			}
+			query.Set(key, eventTime.Format(time.RFC3339Nano))
+		}
+
+		if _, err := container.ParseEventFilter(context.StringSlice("filter")); err != nil {
+			return err
+		}
+		for _, filter := range context.StringSlice("filter") {
+			query.Add("filter", filter)
+		}
+
+		if context.Bool("follow") {
+			query.Set("follow", "1")
+		}
+
+		events, err := daemonStream(http.MethodGet, "/events", query, nil)
+		if err != nil {
+			return err
+		}
+		defer events.Close()
+
+		return printEvents(events, context.Bool("json"))
+	},
+}
+
+// printEvents 打印 qsrdockerd 返回的事件, 每行一个 json
+func printEvents(events io.Reader, jsonFormat bool) error {
+
+	scanner := bufio.NewScanner(events)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		if jsonFormat {
+			fmt.Fprintln(os.Stdout, scanner.Text())
+			continue
+		}
+
+		event := &container.Event{}
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			log.Debugf("Unmarshal event %v error %v", scanner.Text(), err)
+			continue
+		}
+		fmt.Fprintln(os.Stdout, event.String())
+	}
+
+	return scanner.Err()
+}
+
+// streamEvents 输出满足条件的历史事件, follow 时持续输出新事件直到 until 或 done 关闭
+func streamEvents(w io.Writer, since, until time.Time, eventFilter container.EventFilter, follow bool, done <-chan struct{}) error {
+
+	// 输出单个事件, 超过 until 时返回 false
+	writeEvent := func(line string) bool {
+		event := &container.Event{}
+		if err := json.Unmarshal([]byte(line), event); err != nil {
+			log.Debugf("Unmarshal event %v error %v", line, err)
+			return true
+		}
+
+		eventTime := time.Unix(0, event.TimeNano)
+
+		if !until.IsZero() && eventTime.After(until) {
+			return false
+		}
+
+		if (!since.IsZero() && eventTime.Before(since)) || !eventFilter.Match(event) {
+			return true
+		}
+
+		fmt.Fprintln(w, line)
+		return true
+	}
+
+	// 读取历史事件
+	var offset int64
+	if eventsFd, err := os.Open(container.EventsFile); err == nil {
+		scanner := bufio.NewScanner(eventsFd)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+		for scanner.Scan() {
+			offset += int64(len(scanner.Bytes())) + 1
+			if !writeEvent(scanner.Text()) {
+				eventsFd.Close()
+				return nil
+			}
+		}
+		eventsFd.Close()
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("Open events file %v error %v", container.EventsFile, err)
+	}
+
+	if !follow || (!until.IsZero() && time.Now().After(until)) {
+		return nil
+	}
+
+	// 使用 tail 组件，从历史事件读取完毕的位置开始
+	t, err := tail.TailFile(container.EventsFile, tail.Config{
+		ReOpen:    true,
+		Poll:      true,
+		Follow:    true,
+		MustExist: false,
+		Location:  &tail.SeekInfo{Offset: offset, Whence: os.SEEK_SET},
+		Logger:    tail.DiscardingLogger,
+	})
+	if err != nil {
+		return fmt.Errorf("Follow events file %v error %v", container.EventsFile, err)
+	}
+	defer t.Cleanup()
+	defer t.Stop()
+
+	// 到达 until 后结束
+	var untilCh <-chan time.Time
+	if !until.IsZero() {
+		untilCh = time.After(time.Until(until))
+	}
+
+	for {
+		select {
+		case line, ok := <-t.Lines:
+			if !ok {
+				return t.Err()
+			}
+			if !writeEvent(line.Text) {
+				return nil
+			}
+		case <-untilCh:
+			return nil
+		case <-done:
+			return nil
+		}
+	}
+}
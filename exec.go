@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"os"
 	"io/ioutil"
-	"encoding/json"
+	"net/http"
 	"strings"
 	"os/exec"
 	"qsrdocker/container"
@@ -22,16 +22,48 @@ const ENVEXECPID = "QSRDOCKER_PID"
 // ENVEXECCMD 环境变量 cmd
 const ENVEXECCMD = "QSRDOCKER_CMD"
 
+// exec -w -u 传递给 nsenter 的环境变量
+const (
+	ENVEXECWORKDIR = "QSRDOCKER_WORKDIR"
+	ENVEXECUID     = "QSRDOCKER_UID"
+	ENVEXECGID     = "QSRDOCKER_GID"
+	ENVEXECGROUPS  = "QSRDOCKER_GROUPS"
+)
+
+// exec 进程的 capability 位图 no_new_privs 与 seccomp 过滤程序, 传递给 nsenter
+const (
+	ENVEXECCAPS       = "QSRDOCKER_CAPS"
+	ENVEXECNONEWPRIVS = "QSRDOCKER_NO_NEW_PRIVS"
+	ENVEXECSECCOMP    = "QSRDOCKER_SECCOMP"
+)
+
+// execOptions qsrdocker exec 的 -w -u -e --cap-add --cap-drop --privileged 参数
+type execOptions struct {
+	WorkingDir string   // 为空时使用容器的工作目录
+	User       string   // 为空时使用容器的运行用户
+	Env        []string // 覆盖容器的环境变量
+	CapAdd     []string // 在容器的 capability 上添加
+	CapDrop    []string // 在容器的 capability 上删除
+	Privileged bool     // 全部 capability
+}
+
 // ExecContainer 登陆到已经创建好的 qsrdocker 
-func ExecContainer(tty bool, containerName string, cmdList []string) {
+func ExecContainer(tty bool, containerName string, cmdList []string, options *execOptions) {
 	
-	// 获得目标容器状态
-	statusInfo, err := GetContainerStatusByName(containerName)
-	if err != nil {
-		log.Errorf("Exec container GetContainerStatusByName %s error %v", containerName, err)
+	// 由 qsrdockerd 获得目标容器信息
+	containerInfo := &container.ContainerInfo{}
+	if err := daemonRequest(http.MethodGet, containerAPIPath(containerName, ""), nil, nil, containerInfo); err != nil {
+		log.Errorf("Exec container get containerInfo %s error %v", containerName, err)
 		return
 	}
+	statusInfo := containerInfo.Status
 	
+	// 被冻结的容器无法执行命令
+	if statusInfo.Paused {
+		log.Errorf("Exec container fail, container %v is paused, unpause the container first", containerName)
+		return
+	}
+
 	// 判断进程状态
 	if !statusInfo.Running {
 		log.Errorf("Exec container fail, Status is Dead and  pid %v is not exist", statusInfo.Pid)
@@ -46,9 +78,22 @@ func ExecContainer(tty bool, containerName string, cmdList []string) {
 	log.Debugf("Container pid %s", pid)
 	log.Debugf("Command %s", cmdStr)
 
-	// 重新 fork/exec 执行自己
-	// 触发__attribute__函数
-	cmd := exec.Command("/proc/self/exe", "exec")
+	cmd := newExecCommand(pid, cmdStr)
+
+	// -e 环境变量
+	cmd.Env = mergeEnv(cmd.Env, options.Env)
+
+	// -w -u 工作目录与运行用户, 由 nsenter 设置
+	if err := setExecUser(cmd, pid, containerInfo, options); err != nil {
+		log.Errorf("Exec container %s error %v", containerName, err)
+		return
+	}
+
+	// --cap-add --cap-drop --privileged 在容器的 capability 基础上调整, 使用容器的 seccomp profile
+	if err := setExecSecurity(cmd, containerInfo, options); err != nil {
+		log.Errorf("Exec container %s error %v", containerName, err)
+		return
+	}
 
 	// 标准输出输入错误
 	if tty {
@@ -57,7 +102,23 @@ func ExecContainer(tty bool, containerName string, cmdList []string) {
 		cmd.Stderr = os.Stderr
 	}
 
-	// 获取进程环境变量  
+	log.Debugf("Set exec container %s env %v", containerName, cmd.Env)
+
+	if err := cmd.Run(); err != nil {
+		log.Errorf("Exec container %s error %v", containerName, err)
+	}
+}
+
+
+// newExecCommand 创建在容器 namespace 中执行命令的进程
+// exec 与 健康检查 共用
+func newExecCommand(pid, cmdStr string) *exec.Cmd {
+
+	// 重新 fork/exec 执行自己
+	// 触发__attribute__函数
+	cmd := exec.Command("/proc/self/exe", "exec")
+
+	// 获取进程环境变量
 	// 在 run 创建 container 时，可能设置了环境变量
 	containerEnvSlice := getEnvSliceByPid(pid)
 
@@ -69,45 +130,118 @@ func ExecContainer(tty bool, containerName string, cmdList []string) {
 	// 便于后面再一次调用时触发 nsenter
 	// containerEnvSlice == 容器创建时设置的
 	cmd.Env = append([]string{envPid, envCmd}, containerEnvSlice...)
-	log.Debugf("Set exec container %s env %v", containerName, cmd.Env)
 
-	if err := cmd.Run(); err != nil {
-		log.Errorf("Exec container %s error %v", containerName, err)
+	return cmd
+}
+
+// setExecUser 设置 exec 进程的工作目录与运行用户
+// 运行用户根据容器的 /etc/passwd /etc/group 解析
+func setExecUser(cmd *exec.Cmd, pid string, containerInfo *container.ContainerInfo, options *execOptions) error {
+
+	workingDir := options.WorkingDir
+	if workingDir == "" {
+		workingDir = containerInfo.WorkingDir
+	}
+
+	if workingDir != "" {
+		if !path.IsAbs(workingDir) {
+			return fmt.Errorf("The working directory %v is invalid, it needs to be an absolute path", workingDir)
+		}
+		cmd.Env = append(cmd.Env, strings.Join([]string{ENVEXECWORKDIR, workingDir}, "="))
+	}
+
+	user := options.User
+	if user == "" {
+		user = containerInfo.User
+	}
+
+	if user == "" {
+		return nil
+	}
+
+	containerRoot := path.Join("/proc", pid, "root")
+	execUser, err := container.GetExecUser(user, path.Join(containerRoot, "etc/passwd"), path.Join(containerRoot, "etc/group"))
+	if err != nil {
+		return err
+	}
+
+	cmd.Env = append(cmd.Env,
+		strings.Join([]string{ENVEXECUID, strconv.Itoa(execUser.Uid)}, "="),
+		strings.Join([]string{ENVEXECGID, strconv.Itoa(execUser.Gid)}, "="),
+	)
+
+	// user namespace 禁用 setgroups 时保留原有的附加组
+	setgroups, _ := ioutil.ReadFile(path.Join("/proc", pid, "setgroups"))
+	if strings.TrimSpace(string(setgroups)) != "deny" {
+		var groups []string
+		for _, gid := range execUser.Sgids {
+			groups = append(groups, strconv.Itoa(gid))
+		}
+		cmd.Env = append(cmd.Env, strings.Join([]string{ENVEXECGROUPS, strings.Join(groups, ",")}, "="))
+	}
+
+	if !hasEnv(cmd.Env, "HOME") {
+		cmd.Env = append(cmd.Env, "HOME="+execUser.Home)
 	}
+
+	return nil
 }
 
+// setExecSecurity 设置 exec 进程的 capability no_new_privs 与 seccomp, 由 nsenter 设置
+func setExecSecurity(cmd *exec.Cmd, containerInfo *container.ContainerInfo, options *execOptions) error {
 
-// GetContainerStatusByName 通过容器名获取容器状态信息
-func GetContainerStatusByName(containerName string) (*container.StatusInfo, error) {
+	caps, err := container.TweakCapabilities(containerInfo.GetCapabilities(), options.CapAdd, options.CapDrop, options.Privileged)
+	if err != nil {
+		return err
+	}
 
-	// 获取 container ID
-	containerID, err := container.GetContainerIDByName(containerName)
+	cmd.Env = append(cmd.Env, strings.Join([]string{ENVEXECCAPS, strconv.FormatUint(container.CapabilityBits(caps), 16)}, "="))
 
-	if strings.Replace(containerID, " ", "", -1) == "" || err != nil {
-		return nil, fmt.Errorf("Get containerID fail : %v", err)
+	if containerInfo.NoNewPrivs && !options.Privileged {
+		cmd.Env = append(cmd.Env, ENVEXECNONEWPRIVS+"=1")
 	}
-	
-	containerConfigFile := path.Join(container.ContainerDir, containerID, container.ConfigName)
 
-	// 获取容器配置信息
-	configBytes, err := ioutil.ReadFile(containerConfigFile)
+	seccompProfile, err := containerInfo.GetSeccompProfile()
+	if err != nil || seccompProfile == nil {
+		return err
+	}
+
+	program, err := container.CompileSeccompProfile(seccompProfile, caps)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	
-	// 反序列化
-	var containerInfo container.ContainerInfo
-	if err := json.Unmarshal(configBytes, &containerInfo); err != nil {
-		return nil, err
+
+	// 每条指令编码为 16 位十六进制 code jt jf k
+	var seccompHex strings.Builder
+	for _, insn := range program {
+		fmt.Fprintf(&seccompHex, "%04x%02x%02x%08x", insn.Code, insn.Jt, insn.Jf, insn.K)
+	}
+	cmd.Env = append(cmd.Env, strings.Join([]string{ENVEXECSECCOMP, seccompHex.String()}, "="))
+
+	return nil
+}
+
+// mergeEnv 合并环境变量, override 中的变量覆盖 base 中的同名变量
+func mergeEnv(base, override []string) []string {
+
+	result := []string{}
+	for _, env := range base {
+		if !hasEnv(override, strings.SplitN(env, "=", 2)[0]) {
+			result = append(result, env)
+		}
 	}
 
-	// 检测当前状态
-	containerInfo.Status.StatusCheck()
+	return append(result, override...)
+}
 
-	// 持久化当前状态
-	container.RecordContainerInfo(&containerInfo, containerID)
-	
-	return containerInfo.Status, nil
+// hasEnv 判断是否设置了环境变量 key
+func hasEnv(envSlice []string, key string) bool {
+	for _, env := range envSlice {
+		if strings.SplitN(env, "=", 2)[0] == key {
+			return true
+		}
+	}
+	return false
 }
 
 // getEnvSliceByPid 获取进程环境变量
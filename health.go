@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"qsrdocker/container"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// startHealthCheck 按照健康检查配置定期在容器中执行检查命令
+// 由 monitor 在容器进程启动后调用，返回的函数在容器进程退出后调用，停止检查
+func startHealthCheck(containerInfo *container.ContainerInfo) func() {
+
+	if !containerInfo.HealthCheck.Enabled() {
+		return func() {}
+	}
+
+	healthConfig := *containerInfo.HealthCheck
+	healthConfig.SetDefault()
+
+	containerID := containerInfo.ID
+	pid := containerInfo.Status.Pid
+	startTime := time.Now()
+
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+
+	go func() {
+		defer close(doneCh)
+
+		ticker := time.NewTicker(healthConfig.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			}
+
+			latestInfo, err := container.GetContainerInfoByNameID(containerID)
+			if err != nil || latestInfo.Status.Pid != pid {
+				return
+			}
+
+			// 被冻结的容器无法执行检查命令
+			if !latestInfo.Status.Running {
+				continue
+			}
+
+			result := runHealthProbe(latestInfo, &healthConfig)
+			inStartPeriod := time.Since(startTime) < healthConfig.StartPeriod
+
+			recordHealthResult(containerID, pid, result, healthConfig.Retries, inStartPeriod)
+		}
+	}()
+
+	return func() {
+		close(stopCh)
+		<-doneCh
+	}
+}
+
+// runHealthProbe 通过 exec 相同的方式在容器 namespace 中执行一次检查命令
+// 与 ExecContainer 相同, 以容器的运行用户 capability seccomp 与 no_new_privs 执行
+func runHealthProbe(containerInfo *container.ContainerInfo, healthConfig *container.HealthConfig) *container.HealthResult {
+
+	result := &container.HealthResult{
+		Start: time.Now().Format("2006-01-02 15:04:05"),
+	}
+
+	output := &bytes.Buffer{}
+
+	pid := strconv.Itoa(containerInfo.Status.Pid)
+	cmd := newExecCommand(pid, healthConfig.Test)
+
+	options := &execOptions{}
+	err := setExecUser(cmd, pid, containerInfo, options)
+	if err == nil {
+		err = setExecSecurity(cmd, containerInfo, options)
+	}
+	if err != nil {
+		result.End = time.Now().Format("2006-01-02 15:04:05")
+		result.ExitCode = -1
+		result.Output = fmt.Sprintf("Set health check user and security error : %v", err)
+		return result
+	}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	// 检查命令与其子进程在同一进程组中，超时后一起杀死
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		result.End = time.Now().Format("2006-01-02 15:04:05")
+		result.ExitCode = -1
+		result.Output = fmt.Sprintf("Start health check error : %v", err)
+		return result
+	}
+
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+
+	select {
+	case <-waitCh:
+		result.ExitCode = cmd.ProcessState.ExitCode()
+		result.Output = output.String()
+	case <-time.After(healthConfig.Timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-waitCh
+		result.ExitCode = -1
+		result.Output = fmt.Sprintf("Health check exceeded timeout (%v)", healthConfig.Timeout)
+	}
+
+	result.End = time.Now().Format("2006-01-02 15:04:05")
+
+	if len(result.Output) > container.HealthOutputMax {
+		result.Output = result.Output[:container.HealthOutputMax]
+	}
+
+	return result
+}
+
+// recordHealthResult 记录检查结果
+// 在 config.json 文件锁内重新读取, 只修改 Health, 避免覆盖 stop 等操作写入的状态
+func recordHealthResult(containerID string, pid int, result *container.HealthResult, retries int, inStartPeriod bool) {
+
+	var containerInfo *container.ContainerInfo
+	var prevStatus string
+
+	err := container.UpdateContainerInfo(containerID, func(info *container.ContainerInfo) (bool, error) {
+		if info.Status.Pid != pid || !info.Status.Running {
+			return false, nil
+		}
+
+		if info.Status.Health == nil {
+			info.Status.Health = container.NewHealth()
+		}
+
+		prevStatus = info.Status.Health.Status
+		info.Status.Health.Update(result, retries, inStartPeriod)
+		containerInfo = info
+
+		return true, nil
+	})
+	if err != nil {
+		log.Errorf("Record container %v health error %v", containerID, err)
+		return
+	}
+
+	// 容器已停止或重启
+	if containerInfo == nil {
+		return
+	}
+
+	log.Debugf("Container %v health check exit code %v, status %v", containerID, result.ExitCode, containerInfo.Status.Health.Status)
+
+	// 仅在健康状态变化时记录事件
+	if containerInfo.Status.Health.Status != prevStatus {
+		container.RecordContainerEvent("health_status", containerInfo, map[string]string{"status": containerInfo.Status.Health.Status})
+	}
+}
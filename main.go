@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -9,6 +10,9 @@ import (
 
 const usage = ` qsrdocker is a simple container runtime implementation.`
 
+// Version qsrdocker 版本
+const Version = "1.2.1"
+
 func main() {
 	/*
 		初始化cli
@@ -17,22 +21,37 @@ func main() {
 	qsrdocker.Name = "qsrdocker"
 	qsrdocker.UsageText = usage
 	qsrdocker.Usage = usage
-	qsrdocker.Version = "1.2.1"
+	qsrdocker.Version = Version
 
 	// 定义cli的runCmd initCmd
 	qsrdocker.Commands = []cli.Command{
 		initCmd,
+		monitorCmd,
 		runCmd,
+		createCmd,
 		commitCmd,
 		listCmd,
 		logCmd,
 		execCmd,
 		inspectCmd,
+		topCmd,
+		statsCmd,
 		stopCmd,
+		killCmd,
+		restartCmd,
+		waitCmd,
+		renameCmd,
 		removeCmd,
 		startCmd,
+		pauseCmd,
+		unpauseCmd,
+		attachCmd,
+		updateCmd,
+		eventsCmd,
 		imageCmd,
 		networkCmd,
+		volumeCmd,
+		daemonCmd,
 	}
 
 	// 设定log配置项
@@ -46,7 +65,14 @@ func main() {
 		return nil
 	}
 
-	err := qsrdocker.Run(os.Args) // 获取输入 os.Args，该输入在 cil 操作中以 context 体现/控制
+	args := os.Args
+
+	// 以 qsrdockerd 名称运行时 (ln -s qsrdocker qsrdockerd) 直接启动 daemon
+	if filepath.Base(args[0]) == DaemonName {
+		args = append([]string{args[0], daemonCmd.Name}, args[1:]...)
+	}
+
+	err := qsrdocker.Run(args) // 获取输入 os.Args，该输入在 cil 操作中以 context 体现/控制
 
 	if err != nil {
 		log.Fatal(err)
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"qsrdocker/cgroups/subsystems"
 	"qsrdocker/container"
+	"regexp"
+	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -17,68 +25,8 @@ var runCmd = cli.Command{
 	Usage:     `Create a container with namespace and cgroup`,
 	ArgsUsage: "imageName [command]",
 
-	Flags: []cli.Flag{
-		cli.BoolFlag{
-			Name:  "it,ti", // 指定 t 参数即当前的输入输出导入到标准输入输出
-			Usage: `Enable tty and Keep STDIN open even if not attached`,
-		},
-		cli.BoolFlag{
-			Name:  "d", // 后台去启动 默认模式
-			Usage: "Detach container",
-		},
-		cli.StringFlag{
-			Name:  "m", // 设置 内存使用
-			Usage: "Set Memory limit",
-		},
-		cli.StringFlag{
-			Name:  "cpushare", // 限制 Cpu 使用
-			Usage: "Set cpushare limit",
-		},
-		cli.StringFlag{
-			Name:  "cpuset", // 限制 Cpu 使用核数
-			Usage: "Set cpuset limit",
-		},
-		cli.StringFlag{
-			Name:  "cpumem", // 在 NUMA模式下 限制 Cpu 使用 内存节点
-			Usage: "Set cpumem node limit in NUMA mode，Usually no restrictions",
-		},
-		cli.StringFlag{
-			Name:  "name", // 容器名称
-			Usage: "Container name",
-		},
-		cli.StringFlag{
-			Name:  "oom_kill_disable", // 容器名称
-			Usage: "oom_kill_disable, 1: disable 0:able (default 0)",
-		},
-		// 存在多个 -v 操作
-		cli.StringSliceFlag{
-			Name:  "v", // 数据卷
-			Usage: "Set volume mount",
-		},
-		cli.StringSliceFlag{
-			Name:  "e",
-			Usage: "Set environment",
-		},
-		cli.StringFlag{
-			Name:  "n", // 指定网络
-			Usage: "Set container network id",
-			Value: container.DefaultNetworkID,
-		},
-		cli.StringFlag{
-			Name:  "netdriver", // 指定网络
-			Usage: "Set container network driver, like bridge, host, none, container",
-			Value: container.DefaultNetworkDriver,
-		},
-		cli.StringFlag{
-			Name:  "container", // 指定网络
-			Usage: "Set container ID/Name with container driver network",
-			Value: container.DefaultNetworkID,
-		},
-		cli.StringSliceFlag{
-			Name:  "p",
-			Usage: "Set port mapping",
-		},
-	},
+	Flags:    runFlags,
+	HideHelp: true, // -h 为 --hostname, 使用 --help 查看帮助
 
 	/*
 		1. 是否包含 cmd
@@ -87,85 +35,400 @@ var runCmd = cli.Command{
 	*/
 	Action: func(context *cli.Context) error {
 
-		// 打印当前输入的命令
-		log.Debugf("Qsrdocker run cmd : %v", context.Args())
+		runConfig, err := parseRunConfig(context)
+		if err != nil {
+			return err
+		}
 
-		if len(context.Args()) < 1 {
-			return fmt.Errorf("Missing run container command, please qsrdocker run -h")
+		// 前台运行的 -it 由 qsrdockerd 创建并启动, 当前终端 attach 到 monitor 持有的 pty
+		if runConfig.Tty && !runConfig.Detach {
+			return runAttachedContainer(runConfig)
 		}
 
-		var cmdList []string
-		for _, arg := range context.Args() {
-			cmdList = append(cmdList, arg)
+		// 后台运行交由 qsrdockerd
+		runResp := &apiRunResponse{}
+		if err := daemonRequest(http.MethodPost, "/containers", nil, runConfig, runResp); err != nil {
+			return err
 		}
 
-		imageName := cmdList[0]
-		cmdList = cmdList[1:]
+		fmt.Printf("%v\n", runResp.ID)
+		return nil
+	},
+}
 
-		// log.Warnf("get cmd %v", cmdList)
+// runFlags run / create 共用的参数
+var runFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "it,ti", // 指定 t 参数即当前的输入输出导入到标准输入输出
+		Usage: `Enable tty and Keep STDIN open even if not attached`,
+	},
+	cli.BoolFlag{
+		Name:  "d", // 后台去启动 默认模式
+		Usage: "Detach container",
+	},
+	cli.StringFlag{
+		Name:  "m", // 设置 内存使用
+		Usage: "Set Memory limit",
+	},
+	cli.StringFlag{
+		Name:  "cpushare", // 限制 Cpu 使用
+		Usage: "Set cpushare limit",
+	},
+	cli.StringFlag{
+		Name:  "cpuset", // 限制 Cpu 使用核数
+		Usage: "Set cpuset limit",
+	},
+	cli.StringFlag{
+		Name:  "cpumem", // 在 NUMA模式下 限制 Cpu 使用 内存节点
+		Usage: "Set cpumem node limit in NUMA mode，Usually no restrictions",
+	},
+	cli.StringFlag{
+		Name:  "name", // 容器名称
+		Usage: "Container name",
+	},
+	cli.StringFlag{
+		Name:  "oom_kill_disable", // 容器名称
+		Usage: "oom_kill_disable, 1: disable 0:able (default 0)",
+	},
+	// 存在多个 -v 操作
+	cli.StringSliceFlag{
+		Name:  "v", // 数据卷
+		Usage: "Bind mount a host path or a named volume, src:dst[:ro|rw|z|rshared|rslave|rprivate], dst only for an anonymous volume",
+	},
+	cli.StringSliceFlag{
+		Name:  "e",
+		Usage: "Set environment",
+	},
+	cli.StringFlag{
+		Name:  "n", // 指定网络
+		Usage: "Set container network id",
+		Value: container.DefaultNetworkID,
+	},
+	cli.StringFlag{
+		Name:  "netdriver", // 指定网络
+		Usage: "Set container network driver, like bridge, host, none, container",
+		Value: container.DefaultNetworkDriver,
+	},
+	cli.StringFlag{
+		Name:  "container", // 指定网络
+		Usage: "Set container ID/Name with container driver network",
+		Value: container.DefaultNetworkID,
+	},
+	cli.StringSliceFlag{
+		Name:  "p",
+		Usage: "Set port mapping",
+	},
+	cli.StringFlag{
+		Name:  "restart", // 重启策略
+		Usage: "Restart policy to apply when a container exits, no|always|on-failure[:N]|unless-stopped",
+		Value: container.RestartPolicyNo,
+	},
+	cli.StringFlag{
+		Name:  "signal", // 停止信号
+		Usage: "Signal to stop the container, default use image STOPSIGNAL or SIGTERM",
+	},
+	cli.StringFlag{
+		Name:  "health-cmd", // 健康检查
+		Usage: "Command to run to check health",
+	},
+	cli.DurationFlag{
+		Name:  "health-interval",
+		Usage: "Time between running the check (default 30s)",
+	},
+	cli.DurationFlag{
+		Name:  "health-timeout",
+		Usage: "Maximum time to allow one check to run (default 30s)",
+	},
+	cli.IntFlag{
+		Name:  "health-retries",
+		Usage: "Consecutive failures needed to report unhealthy (default 3)",
+	},
+	cli.DurationFlag{
+		Name:  "health-start-period",
+		Usage: "Start period for the container to initialize before counting retries (default 0s)",
+	},
+	cli.BoolFlag{
+		Name:  "no-healthcheck",
+		Usage: "Disable any container-specified HEALTHCHECK",
+	},
+	cli.BoolFlag{
+		Name:  "init", // 容器内运行 init 进程
+		Usage: "Run an init inside the container that forwards signals and reaps processes",
+	},
+	cli.StringFlag{
+		Name:  "workdir, w", // 工作目录
+		Usage: "Working directory inside the container",
+	},
+	cli.StringFlag{
+		Name:  "user, u", // 运行用户
+		Usage: "Username or UID (format: <name|uid>[:<group|gid>])",
+	},
+	cli.StringFlag{
+		Name:  "hostname, h", // 主机名, -h 不再作为 help 的缩写
+		Usage: "Container host name",
+	},
+	cli.StringFlag{
+		Name:  "userns", // user namespace
+		Usage: "User namespace to use, host|auto|keep-id (default: auto)",
+	},
+	cli.StringSliceFlag{
+		Name:  "cap-add", // 添加 capability
+		Usage: "Add Linux capabilities, like NET_ADMIN or ALL",
+	},
+	cli.StringSliceFlag{
+		Name:  "cap-drop", // 删除 capability
+		Usage: "Drop Linux capabilities, like NET_RAW or ALL",
+	},
+	cli.BoolFlag{
+		Name:  "privileged", // 全部 capability
+		Usage: "Give all capabilities to the container",
+	},
+	cli.StringSliceFlag{
+		Name:  "security-opt", // seccomp profile
+		Usage: "Security options, seccomp=unconfined|<profile.json>",
+	},
+	cli.StringFlag{
+		Name:  "shm-size", // /dev/shm 大小
+		Usage: "Size of /dev/shm, a number with optional unit k, m or g (default 64m)",
+	},
+	cli.StringSliceFlag{
+		Name:  "device", // 宿主机设备
+		Usage: "Add a host device to the container, host[:container][:rwm]",
+	},
+	cli.BoolFlag{
+		Name:  "read-only", // 根文件系统只读
+		Usage: "Mount the container's root filesystem as read only",
+	},
+	cli.StringSliceFlag{
+		Name:  "tmpfs", // 挂载 tmpfs
+		Usage: "Mount a tmpfs directory, /path[:size=64m,mode=1777]",
+	},
+	cli.StringSliceFlag{
+		Name:  "mount", // --mount 挂载
+		Usage: "Attach a filesystem mount, type=bind|volume|tmpfs,source=..,target=..,readonly,bind-propagation=..",
+	},
+	cli.BoolFlag{
+		Name:  "help",
+		Usage: "show help",
+	},
+}
 
-		tty := context.Bool("it")
-		// -ti 或者 -it 都可以
-		detach := context.Bool("d")
+// hostnameRegexp 合法的主机名 RFC 1123
+var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
 
-		// 容器名称
-		containerName := context.String("name")
+// shmSizeRegexp /dev/shm 大小, 0 对于 tmpfs 为不限制, 不允许
+var shmSizeRegexp = regexp.MustCompile(`^[1-9][0-9]*[kKmMgG]?$`)
 
-		// 数据卷
-		volumes := context.StringSlice("v")
+// parseRunConfig 解析 run / create 的参数
+func parseRunConfig(context *cli.Context) (*container.RunConfig, error) {
 
-		// 数据卷
-		envSlice := context.StringSlice("e")
+	// 打印当前输入的命令
+	log.Debugf("Qsrdocker run cmd : %v", context.Args())
 
-		// 容器网络ID
-		networkID := context.String("n")
+	if len(context.Args()) < 1 {
+		return nil, fmt.Errorf("Missing run container command, please qsrdocker run --help")
+	}
 
-		// 容器网络driver
-		networkDriver := strings.ToLower(context.String("netdriver"))
+	var cmdList []string
+	for _, arg := range context.Args() {
+		cmdList = append(cmdList, arg)
+	}
 
-		if networkDriver == "container" {
-			return fmt.Errorf("This mode is not currently supported")
-		}
+	imageName := cmdList[0]
+	cmdList = cmdList[1:]
 
-		// container 模式网络 目标 container 信息
-		containerNetwork := context.String("container")
+	// log.Warnf("get cmd %v", cmdList)
 
-		// 端口映射
-		portmapping := context.StringSlice("p")
+	tty := context.Bool("it")
+	// -ti 或者 -it 都可以
+	detach := context.Bool("d")
 
-		if tty && detach {
-			return fmt.Errorf("ti and detach parameter can not both provided")
-		}
+	// 容器名称
+	containerName := context.String("name")
+
+	// 数据卷
+	volumes := context.StringSlice("v")
 
-		log.Debugf("Enable tty %v", tty)
+	// 数据卷
+	envSlice := context.StringSlice("e")
 
-		log.Debugf("Enable detach %v", detach)
+	// 容器网络ID
+	networkID := context.String("n")
 
-		oomKillAble := context.String("oom_kill_disable")
-		if oomKillAble != "0" {
-			// 可能存在其他数字(用户乱写....)
-			oomKillAble = "1"
+	// 容器网络driver
+	networkDriver := strings.ToLower(context.String("netdriver"))
+
+	if networkDriver == "container" {
+		return nil, fmt.Errorf("This mode is not currently supported")
+	}
+
+	// container 模式网络 目标 container 信息
+	containerNetwork := context.String("container")
+
+	// 端口映射
+	portmapping := context.StringSlice("p")
+
+	// 重启策略
+	restartPolicy, err := container.ParseRestartPolicy(context.String("restart"))
+	if err != nil {
+		return nil, err
+	}
+
+	// 前台运行的 -it 容器没有 monitor 进程，无法自动重启
+	if tty && !detach && restartPolicy.Name != container.RestartPolicyNo {
+		return nil, fmt.Errorf("restart policy can only be used with detach container")
+	}
+
+	// 停止信号
+	stopSignal := context.String("signal")
+	if stopSignal != "" {
+		sig, err := container.ParseSignal(stopSignal)
+		if err != nil {
+			return nil, err
 		}
+		stopSignal = container.SignalName(sig)
+	}
 
-		resConfig := &subsystems.ResourceConfig{
-			MemoryLimit:    context.String("m"),
-			CPUSet:         context.String("cpuset"),
-			CPUShare:       context.String("cpushare"),
-			CPUMem:         context.String("cpumem"),
-			OOMKillDisable: oomKillAble,
+	// 健康检查, 未设置时使用镜像的配置
+	var healthCheck *container.HealthConfig
+	if context.Bool("no-healthcheck") {
+		if context.String("health-cmd") != "" {
+			return nil, fmt.Errorf("--no-healthcheck conflicts with --health-cmd")
 		}
+		healthCheck = &container.HealthConfig{Test: container.HealthCheckDisable}
+	} else if context.String("health-cmd") != "" {
+		healthCheck = &container.HealthConfig{
+			Test:        context.String("health-cmd"),
+			Interval:    context.Duration("health-interval"),
+			Timeout:     context.Duration("health-timeout"),
+			Retries:     context.Int("health-retries"),
+			StartPeriod: context.Duration("health-start-period"),
+		}
+		if healthCheck.Interval < 0 || healthCheck.Timeout < 0 || healthCheck.Retries < 0 || healthCheck.StartPeriod < 0 {
+			return nil, fmt.Errorf("Health check options can't be negative")
+		}
+		healthCheck.SetDefault()
+	}
+
+	log.Debugf("Enable tty %v", tty)
+
+	log.Debugf("Enable detach %v", detach)
+
+	oomKillAble := context.String("oom_kill_disable")
+	if oomKillAble != "0" {
+		// 可能存在其他数字(用户乱写....)
+		oomKillAble = "1"
+	}
+
+	resConfig := &subsystems.ResourceConfig{
+		MemoryLimit:    context.String("m"),
+		CPUSet:         context.String("cpuset"),
+		CPUShare:       context.String("cpushare"),
+		CPUMem:         context.String("cpumem"),
+		OOMKillDisable: oomKillAble,
+	}
+
+	if err := resConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	// 工作目录必须为绝对路径
+	workingDir := context.String("workdir")
+	if workingDir != "" && !path.IsAbs(workingDir) {
+		return nil, fmt.Errorf("The working directory %v is invalid, it needs to be an absolute path", workingDir)
+	}
+
+	hostname := context.String("hostname")
+	if hostname != "" && (len(hostname) > 64 || !hostnameRegexp.MatchString(hostname)) {
+		return nil, fmt.Errorf("Invalid hostname %v", hostname)
+	}
+
+	shmSize := context.String("shm-size")
+	if shmSize != "" && !shmSizeRegexp.MatchString(shmSize) {
+		return nil, fmt.Errorf("Invalid shm size %v, use a number with optional unit k, m or g", shmSize)
+	}
+
+	usernsMode, err := container.ParseUsernsMode(context.String("userns"))
+	if err != nil {
+		return nil, err
+	}
+
+	// seccomp profile 文件在客户端读取
+	seccompProfile, err := container.ParseSecurityOpt(context.StringSlice("security-opt"))
+	if err != nil {
+		return nil, err
+	}
+
+	// 选用 container 网络模式 时，必须采用
+	if networkDriver == "container" && containerNetwork == "" {
+		return nil, fmt.Errorf("Please set container ID/Name with container driver network")
+	}
+
+	// 若是以下三种网络模型 则不需要 networkID 的存在
+	if networkDriver == "none" || networkDriver == "container" || networkDriver == "host" {
+		networkID = ""
+	}
+
+	runConfig := &container.RunConfig{
+		Tty:              tty,
+		Detach:           detach,
+		Cmd:              cmdList,
+		Volumes:          volumes,
+		Env:              envSlice,
+		PortMapping:      portmapping,
+		Resource:         resConfig,
+		Image:            imageName,
+		Name:             containerName,
+		NetworkID:        networkID,
+		NetworkDriver:    networkDriver,
+		ContainerNetwork: containerNetwork,
+		Restart:          restartPolicy,
+		StopSignal:       stopSignal,
+		HealthCheck:      healthCheck,
+		Init:             context.Bool("init"),
+		WorkingDir:       workingDir,
+		User:             context.String("user"),
+		Hostname:         hostname,
+		UsernsMode:       usernsMode,
+		Privileged:       context.Bool("privileged"),
+		CapAdd:           context.StringSlice("cap-add"),
+		CapDrop:          context.StringSlice("cap-drop"),
+		SeccompProfile:   seccompProfile,
+		ShmSize:          shmSize,
+		Devices:          context.StringSlice("device"),
+		ReadOnly:         context.Bool("read-only"),
+		Tmpfs:            context.StringSlice("tmpfs"),
+		Mounts:           context.StringSlice("mount"),
+	}
+
+	return runConfig, nil
+}
+
+// createCmd 创建容器但不启动, 使用 qsrdocker start 启动
+var createCmd = cli.Command{
+	Name:      "create",
+	Usage:     `Create a new container without starting it`,
+	ArgsUsage: "imageName [command]",
+
+	Flags:    runFlags,
+	HideHelp: true, // -h 为 --hostname, 使用 --help 查看帮助
 
-		// 选用 container 网络模式 时，必须采用
-		if networkDriver == "container" && containerNetwork == "" {
-			return fmt.Errorf("Please set container ID/Name with container driver network")
+	Action: func(context *cli.Context) error {
+
+		runConfig, err := parseRunConfig(context)
+		if err != nil {
+			return err
 		}
 
-		// 若是以下三种网络模型 则不需要 networkID 的存在
-		if networkDriver == "none" || networkDriver == "container" || networkDriver == "host" {
-			networkID = ""
+		// -it 容器由 monitor 持有 pty, start 后通过 qsrdocker attach 连接
+		runConfig.Detach = true
+
+		createResp := &apiRunResponse{}
+		if err := daemonRequest(http.MethodPost, "/containers/create", nil, runConfig, createResp); err != nil {
+			return err
 		}
 
-		QsrdockerRun(tty, cmdList, volumes, envSlice, portmapping, resConfig, imageName, containerName, networkID, networkDriver, containerNetwork)
+		fmt.Printf("%v\n", createResp.ID)
 		return nil
 	},
 }
@@ -206,8 +469,7 @@ var commitCmd = cli.Command{
 		}
 		containerName := context.Args().Get(0)
 		imageName := context.Args().Get(1)
-		CommitContainer(containerName, imageName)
-		return nil
+		return daemonRequest(http.MethodPost, containerAPIPath(containerName, "commit"), nil, &apiCommit{Image: imageName}, nil)
 	},
 }
 
@@ -225,9 +487,17 @@ var listCmd = cli.Command{
 
 	Action: func(context *cli.Context) error {
 		// show all container
-		all := context.Bool("a")
-		listContainers(all)
+		query := url.Values{}
+		if context.Bool("a") {
+			query.Set("all", "1")
+		}
 
+		var containerInfos []*container.ContainerInfo
+		if err := daemonRequest(http.MethodGet, "/containers", query, nil, &containerInfos); err != nil {
+			return err
+		}
+
+		listContainers(containerInfos)
 		return nil
 	},
 }
@@ -264,9 +534,21 @@ var logCmd = cli.Command{
 
 		containerName := context.Args().Get(0)
 
+		query := url.Values{}
+		query.Set("tail", strconv.Itoa(tail))
+		if follow {
+			query.Set("follow", "1")
+		}
+
 		// 打印 log
-		logContainer(containerName, tail, follow)
-		return nil
+		logs, err := daemonStream(http.MethodGet, containerAPIPath(containerName, "logs"), query, nil)
+		if err != nil {
+			return err
+		}
+		defer logs.Close()
+
+		_, err = io.Copy(os.Stdout, logs)
+		return err
 	},
 }
 
@@ -279,6 +561,30 @@ var execCmd = cli.Command{
 			Name:  "it,ti", // 指定 t 参数即当前的输入输出导入到标准输入输出
 			Usage: `Enable tty and Keep STDIN open even if not attached`,
 		},
+		cli.StringFlag{
+			Name:  "workdir, w", // 工作目录
+			Usage: "Working directory inside the container",
+		},
+		cli.StringFlag{
+			Name:  "user, u", // 运行用户
+			Usage: "Username or UID (format: <name|uid>[:<group|gid>])",
+		},
+		cli.StringSliceFlag{
+			Name:  "e",
+			Usage: "Set environment",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-add", // 在容器的 capability 上添加
+			Usage: "Add Linux capabilities to the exec process",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-drop", // 在容器的 capability 上删除
+			Usage: "Drop Linux capabilities from the exec process",
+		},
+		cli.BoolFlag{
+			Name:  "privileged",
+			Usage: "Give all capabilities to the exec process",
+		},
 	},
 	Action: func(context *cli.Context) error {
 
@@ -308,7 +614,14 @@ var execCmd = cli.Command{
 		}
 
 		// 处理
-		ExecContainer(tty, containerName, cmdList)
+		ExecContainer(tty, containerName, cmdList, &execOptions{
+			WorkingDir: context.String("workdir"),
+			User:       context.String("user"),
+			Env:        context.StringSlice("e"),
+			CapAdd:     context.StringSlice("cap-add"),
+			CapDrop:    context.StringSlice("cap-drop"),
+			Privileged: context.Bool("privileged"),
+		})
 		return nil
 	},
 }
@@ -325,13 +638,69 @@ var inspectCmd = cli.Command{
 
 		containerName := context.Args().Get(0)
 
-		// 打印 log
-		inspectContainer(containerName)
-		return nil
+		var containerInfo json.RawMessage
+		if err := daemonRequest(http.MethodGet, containerAPIPath(containerName, ""), nil, nil, &containerInfo); err != nil {
+			return err
+		}
+
+		// 与 config.json 相同的缩进格式
+		var containerInfoBuf bytes.Buffer
+		if err := json.Indent(&containerInfoBuf, containerInfo, " ", "    "); err != nil {
+			return fmt.Errorf("Get container %v Info err : %v", containerName, err)
+		}
+		containerInfoBuf.WriteString("\n")
+
+		_, err := containerInfoBuf.WriteTo(os.Stdout)
+		return err
 	},
 }
 
-// stopCmd 暂停 运行中的容器
+// topCmd 打印容器中运行的进程
+var topCmd = cli.Command{
+	Name:      "top",
+	Usage:     "Display the running processes of a container",
+	ArgsUsage: "containerName [ps OPTIONS]",
+	// ps 参数原样传给宿主机的 ps 命令
+	SkipFlagParsing: true,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Please input container Name")
+		}
+
+		containerName := context.Args().Get(0)
+
+		// ps 参数原样传给 qsrdockerd
+		query := url.Values{}
+		for _, psArg := range context.Args().Tail() {
+			query.Add("ps_args", psArg)
+		}
+
+		top := &apiTopResponse{}
+		if err := daemonRequest(http.MethodGet, containerAPIPath(containerName, "top"), query, nil, top); err != nil {
+			return err
+		}
+
+		return printTop(top)
+	},
+}
+
+// statsCmd 打印容器资源使用
+var statsCmd = cli.Command{
+	Name:      "stats",
+	Usage:     "Display a live stream of container(s) resource usage statistics",
+	ArgsUsage: "[containerName...]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stream", // 只采样一次, json 输出
+			Usage: "Disable streaming stats and only pull the first result as json",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return statsContainers(context.Args(), context.Bool("no-stream"))
+	},
+}
+
+// stopCmd 停止 运行中的容器
 var stopCmd = cli.Command{
 	Name:      "stop",
 	Usage:     "Stop a container",
@@ -339,7 +708,7 @@ var stopCmd = cli.Command{
 	Flags: []cli.Flag{
 		cli.IntFlag{
 			Name:  "t", // 指定 t
-			Value: 0,
+			Value: 10,
 			Usage: `Seconds to wait for stop before killing it`,
 		},
 	},
@@ -351,7 +720,112 @@ var stopCmd = cli.Command{
 		sleepTime := context.Int("t")
 
 		containerName := context.Args().Get(0)
-		stopContainer(containerName, sleepTime)
+		query := url.Values{}
+		query.Set("t", strconv.Itoa(sleepTime))
+		return daemonRequest(http.MethodPost, containerAPIPath(containerName, "stop"), query, nil, nil)
+	},
+}
+
+// restartCmd 重启容器
+var restartCmd = cli.Command{
+	Name:      "restart",
+	Usage:     "Restart one or more containers",
+	ArgsUsage: "containerName...",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t", // 指定 t
+			Value: 10,
+			Usage: `Seconds to wait for stop before killing it`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+
+		query := url.Values{}
+		query.Set("t", strconv.Itoa(context.Int("t")))
+
+		for _, containerName := range context.Args() {
+			// 多个容器
+			if err := daemonRequest(http.MethodPost, containerAPIPath(containerName, "restart"), query, nil, nil); err != nil {
+				log.Errorf("Restart container %v error : %v", containerName, err)
+				continue
+			}
+			fmt.Printf("%v\n", containerName)
+		}
+		return nil
+	},
+}
+
+// waitCmd 阻塞直到容器退出，打印退出码
+var waitCmd = cli.Command{
+	Name:      "wait",
+	Usage:     "Block until one or more containers stop, then print their exit codes",
+	ArgsUsage: "containerName...",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+
+		for _, containerName := range context.Args() {
+			// 多个容器
+			waitResp := &apiWaitResponse{}
+			if err := daemonRequest(http.MethodPost, containerAPIPath(containerName, "wait"), nil, nil, waitResp); err != nil {
+				log.Errorf("Wait container %v error : %v", containerName, err)
+				continue
+			}
+			fmt.Printf("%v\n", waitResp.StatusCode)
+		}
+		return nil
+	},
+}
+
+// renameCmd 修改容器名
+var renameCmd = cli.Command{
+	Name:      "rename",
+	Usage:     "Rename a container",
+	ArgsUsage: "containerName newName",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("Missing container name or new name")
+		}
+
+		query := url.Values{}
+		query.Set("name", context.Args().Get(1))
+
+		return daemonRequest(http.MethodPost, containerAPIPath(context.Args().Get(0), "rename"), query, nil, nil)
+	},
+}
+
+// killCmd 向容器发送信号
+var killCmd = cli.Command{
+	Name:      "kill",
+	Usage:     "Send a signal to one or more running containers",
+	ArgsUsage: "containerName...",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "signal,s", // 信号
+			Value: "SIGKILL",
+			Usage: `Signal to send to the container`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+
+		query := url.Values{}
+		query.Set("signal", context.String("signal"))
+
+		for _, containerName := range context.Args() {
+			// 多个容器
+			if err := daemonRequest(http.MethodPost, containerAPIPath(containerName, "kill"), query, nil, nil); err != nil {
+				log.Errorf("Kill container %v error : %v", containerName, err)
+				continue
+			}
+			fmt.Printf("%v\n", containerName)
+		}
 		return nil
 	},
 }
@@ -367,8 +841,8 @@ var removeCmd = cli.Command{
 			Usage: `Force the removal of a running container (uses SIGKILL)`,
 		},
 		cli.BoolFlag{
-			Name:  "v", // 强制删除容器
-			Usage: `Remove the volumes associated with the container`,
+			Name:  "v", // 删除匿名数据卷
+			Usage: `Remove anonymous volumes associated with the container`,
 		},
 	},
 	Action: func(context *cli.Context) error {
@@ -380,9 +854,15 @@ var removeCmd = cli.Command{
 		Force := context.Bool("f")
 		volume := context.Bool("v")
 
+		query := url.Values{}
+		query.Set("force", strconv.FormatBool(Force))
+		query.Set("volumes", strconv.FormatBool(volume))
+
 		for _, containerName := range context.Args() {
 			// 多个容器
-			removeContainer(containerName, Force, volume)
+			if err := daemonRequest(http.MethodDelete, containerAPIPath(containerName, ""), query, nil, nil); err != nil {
+				log.Errorf("Remove container %v error : %v", containerName, err)
+			}
 		}
 		return nil
 	},
@@ -400,8 +880,147 @@ var startCmd = cli.Command{
 
 		for _, containerName := range context.Args() {
 			// 多个容器
-			startContainer(containerName)
+			if err := daemonRequest(http.MethodPost, containerAPIPath(containerName, "start"), nil, nil, nil); err != nil {
+				log.Errorf("Start container %v error : %v", containerName, err)
+				continue
+			}
+			fmt.Printf("%v\n", containerName)
+		}
+		return nil
+	},
+}
+
+// pauseCmd 冻结容器内所有进程
+var pauseCmd = cli.Command{
+	Name:      "pause",
+	Usage:     "Pause all processes within one or more containers",
+	ArgsUsage: "containerName...",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+
+		for _, containerName := range context.Args() {
+			// 多个容器
+			if err := daemonRequest(http.MethodPost, containerAPIPath(containerName, "pause"), nil, nil, nil); err != nil {
+				log.Errorf("Pause container %v error : %v", containerName, err)
+				continue
+			}
+			fmt.Printf("%v\n", containerName)
 		}
 		return nil
 	},
 }
+
+// updateCmd 修改容器资源限制
+var updateCmd = cli.Command{
+	Name:      "update",
+	Usage:     "Update resource limits of one or more containers",
+	ArgsUsage: "containerName...",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "m,memory", // 设置 内存使用
+			Usage: "Set Memory limit",
+		},
+		cli.StringFlag{
+			Name:  "cpushare", // 限制 Cpu 使用
+			Usage: "Set cpushare limit",
+		},
+		cli.StringFlag{
+			Name:  "cpuset", // 限制 Cpu 使用核数
+			Usage: "Set cpuset limit",
+		},
+		cli.StringFlag{
+			Name:  "cpumem", // 在 NUMA模式下 限制 Cpu 使用 内存节点
+			Usage: "Set cpumem node limit in NUMA mode",
+		},
+		cli.StringFlag{
+			Name:  "oom_kill_disable",
+			Usage: "oom_kill_disable, 1: disable 0:able",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+
+		// 未指定的值保持不变
+		resConfig := &subsystems.ResourceConfig{
+			MemoryLimit:    context.String("memory"),
+			CPUSet:         context.String("cpuset"),
+			CPUShare:       context.String("cpushare"),
+			CPUMem:         context.String("cpumem"),
+			OOMKillDisable: context.String("oom_kill_disable"),
+		}
+
+		if *resConfig == (subsystems.ResourceConfig{}) {
+			return fmt.Errorf("You must provide one or more flags when using this command")
+		}
+
+		if err := resConfig.Validate(); err != nil {
+			return err
+		}
+
+		for _, containerName := range context.Args() {
+			// 多个容器
+			if err := daemonRequest(http.MethodPost, containerAPIPath(containerName, "update"), nil, resConfig, nil); err != nil {
+				log.Errorf("Update container %v error : %v", containerName, err)
+				continue
+			}
+			fmt.Printf("%v\n", containerName)
+		}
+		return nil
+	},
+}
+
+// attachCmd 连接后台运行容器的标准输入输出
+var attachCmd = cli.Command{
+	Name:      "attach",
+	Usage:     "Attach local standard input, output, and error streams to a running container",
+	ArgsUsage: "containerName",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "detach-keys", // 断开连接的按键
+			Usage: "Override the key sequence for detaching a container",
+			Value: container.DefaultDetachKeys,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Please input container Name")
+		}
+
+		return attachContainer(context.Args().Get(0), context.String("detach-keys"))
+	},
+}
+
+// unpauseCmd 解冻容器内所有进程
+var unpauseCmd = cli.Command{
+	Name:      "unpause",
+	Usage:     "Unpause all processes within one or more containers",
+	ArgsUsage: "containerName...",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+
+		for _, containerName := range context.Args() {
+			// 多个容器
+			if err := daemonRequest(http.MethodPost, containerAPIPath(containerName, "unpause"), nil, nil, nil); err != nil {
+				log.Errorf("Unpause container %v error : %v", containerName, err)
+				continue
+			}
+			fmt.Printf("%v\n", containerName)
+		}
+		return nil
+	},
+}
+
+// containerAPIPath 拼接容器相关的 API 路径 /containers/[name]/[action]
+func containerAPIPath(containerName, action string) string {
+	apiPath := strings.Join([]string{"/containers", url.PathEscape(containerName)}, "/")
+	if action != "" {
+		apiPath = strings.Join([]string{apiPath, action}, "/")
+	}
+	return apiPath
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"qsrdocker/container"
+	"qsrdocker/network"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// monitorResult monitor 进程通过管道返回给父进程的启动结果
+type monitorResult struct {
+	Pid   int    `json:"Pid"`   // 容器进程 pid
+	Error string `json:"Error"` // 启动失败原因
+}
+
+/*
+monitorCmd 容器监控进程, 由 startContainer 调用，禁止外部调用
+每个后台容器对应一个 monitor 进程:
+ 1. 启动容器进程 (monitor 的子进程)
+ 2. 等待容器进程退出，记录退出码 退出时间 信号
+ 3. 清理容器的网络和 cgroup
+ 4. 按照重启策略重新启动容器
+*/
+var monitorCmd = cli.Command{
+	Name:      "monitor",
+	Usage:     `Monitor container process, Do not call it outside.`,
+	ArgsUsage: "containerID",
+	HideHelp:  true,
+	Hidden:    true,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "attach", // 前台 run -it, 缓存 attach 之前的输出
+			Usage: "Keep tty output until the first client attaches",
+		},
+	},
+
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container ID")
+		}
+		return runMonitor(context.Args().Get(0), context.Bool("attach"))
+	},
+}
+
+// startMonitor 启动 monitor 进程并等待其返回容器进程的启动结果
+// attach 时 tty 容器的 monitor 缓存第一个客户端 attach 之前的输出
+func startMonitor(containerID string, attach bool) error {
+
+	// monitor 通过管道返回启动结果
+	readPipe, writePipe, err := container.NewPipe()
+	if err != nil {
+		return fmt.Errorf("Create monitor pipe error : %v", err)
+	}
+	defer readPipe.Close()
+
+	// monitor 日志
+	monitorLogFile := path.Join(container.ContainerDir, containerID, container.MonitorLogFile)
+	monitorLogFd, err := os.OpenFile(monitorLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		writePipe.Close()
+		return fmt.Errorf("Open monitor log file %v error : %v", monitorLogFile, err)
+	}
+	defer monitorLogFd.Close()
+
+	args := []string{"monitor"}
+	if attach {
+		args = append(args, "--attach")
+	}
+	cmd := exec.Command("/proc/self/exe", append(args, containerID)...)
+
+	// 新建 session，脱离 qsrdocker / qsrdockerd 的进程组和终端
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.Stdout = monitorLogFd
+	cmd.Stderr = monitorLogFd
+
+	// 管道写端为 monitor 的 fd 3
+	cmd.ExtraFiles = []*os.File{writePipe}
+
+	if err := cmd.Start(); err != nil {
+		writePipe.Close()
+		return fmt.Errorf("Start monitor process error : %v", err)
+	}
+
+	// 关闭父进程中的写端，monitor 关闭写端后 ReadAll 返回
+	writePipe.Close()
+
+	// 回收 monitor 进程, qsrdockerd 常驻时避免僵尸进程
+	go cmd.Wait()
+
+	resultBytes, err := ioutil.ReadAll(readPipe)
+	if err != nil {
+		return fmt.Errorf("Read monitor result error : %v", err)
+	}
+
+	result := &monitorResult{}
+	if err := json.Unmarshal(resultBytes, result); err != nil {
+		return fmt.Errorf("Monitor exit without result, see %v", monitorLogFile)
+	}
+
+	if result.Error != "" {
+		return fmt.Errorf("%v", result.Error)
+	}
+
+	log.Debugf("Monitor %v start container %v, pid is %v", cmd.Process.Pid, containerID, result.Pid)
+
+	return nil
+}
+
+// runMonitor monitor 进程主体
+func runMonitor(containerID string, holdOutput bool) error {
+
+	// fd 3 为 startMonitor 传入的管道写端
+	resultPipe := os.NewFile(uintptr(3), "pipe")
+
+	// 容器进程由 monitor 回收，忽略终端相关信号
+	signal.Ignore(syscall.SIGHUP, syscall.SIGINT)
+
+	containerInfo, err := container.GetContainerInfoByNameID(containerID)
+	if err != nil {
+		sendMonitorResult(resultPipe, 0, fmt.Errorf("Get containerInfo fail : %v", err))
+		return err
+	}
+
+	// tty 容器的 pty 由 monitor 持有, 通过 attach.sock 提供给 qsrdocker attach
+	var server *consoleServer
+	if containerInfo.TTy {
+		if server, err = startConsoleServer(containerID, holdOutput); err != nil {
+			sendMonitorResult(resultPipe, 0, err)
+			return err
+		}
+		defer server.close()
+	}
+
+	containerProcess, err := launchMonitoredContainer(containerInfo, server)
+	if containerProcess == nil {
+		sendMonitorResult(resultPipe, 0, err)
+		return err
+	}
+
+	// 容器进程已启动，其他错误只记录日志
+	if err != nil {
+		log.Errorf("Launch container %v error : %v", containerID, err)
+	}
+
+	// 返回给父进程后 qsrdocker / qsrdockerd 即可退出
+	sendMonitorResult(resultPipe, containerProcess.Process.Pid, nil)
+
+	// 重启退避时间
+	var backoff time.Duration
+
+	for {
+		startTime := time.Now()
+
+		// 健康检查
+		stopHealthCheck := startHealthCheck(containerInfo)
+
+		// OOM 监听
+		stopOOMMonitor := startOOMMonitor(containerInfo)
+
+		// 等待容器进程退出
+		// 退出码由 ProcessState 获取, 非 0 时 Wait 返回 *exec.ExitError
+		containerProcess.Wait()
+
+		stopHealthCheck()
+		oomKilled := stopOOMMonitor()
+
+		if server != nil {
+			server.closeConsole()
+		}
+
+		uptime := time.Since(startTime)
+
+		containerInfo = recordContainerExit(containerInfo, containerProcess.ProcessState, oomKilled)
+
+		// 容器已被删除
+		if containerInfo == nil {
+			return nil
+		}
+
+		if !containerInfo.Restart.ShouldRestart(containerInfo.Status.ExitCode, containerInfo.RestartCount, containerInfo.Status.Stopped) {
+			return nil
+		}
+
+		// 指数退避，避免不断崩溃的容器占满宿主机资源
+		backoff = container.RestartBackoff(backoff, uptime)
+
+		if containerInfo = waitRestart(containerInfo, backoff); containerInfo == nil {
+			return nil
+		}
+
+		// 与 startContainer 相同的启动流程: 重新设置 cgroup，连接网络并保留端口映射
+		containerProcess, err = launchMonitoredContainer(containerInfo, server)
+		if containerProcess == nil {
+			log.Errorf("Restart container %v error : %v", containerID, err)
+			if e := container.UpdateContainerInfo(containerID, func(latestInfo *container.ContainerInfo) (bool, error) {
+				latestInfo.Status.StatusSet("Dead")
+				latestInfo.Status.MonitorPid = 0
+				return true, nil
+			}); e != nil {
+				log.Errorf("Record container %v dead info error %v", containerID, e)
+			}
+			return err
+		}
+
+		if err != nil {
+			log.Errorf("Restart container %v error : %v", containerID, err)
+		}
+
+		log.Debugf("Restart container %v count %v, pid is %v", containerID, containerInfo.RestartCount, containerProcess.Process.Pid)
+	}
+}
+
+// waitRestart 记录 Restarting 状态并等待 backoff
+// 等待期间容器被 stop / rm 时返回 nil
+func waitRestart(containerInfo *container.ContainerInfo, backoff time.Duration) *container.ContainerInfo {
+
+	containerID := containerInfo.ID
+
+	// 等待期间被 stop 的容器不再重启
+	err := container.UpdateContainerInfo(containerID, func(latestInfo *container.ContainerInfo) (bool, error) {
+		if latestInfo.Status.Stopped {
+			return false, fmt.Errorf("Container %v has been stopped", containerID)
+		}
+		latestInfo.RestartCount++
+		latestInfo.Status.StatusSet("Restarting")
+		latestInfo.Status.MonitorPid = os.Getpid()
+		return true, nil
+	})
+	if err != nil {
+		log.Warnf("Record container %v restart info error %v", containerID, err)
+		return nil
+	}
+
+	log.Debugf("Container %v will restart in %v", containerID, backoff)
+
+	time.Sleep(backoff)
+
+	// 重新读取 config.json, 判断等待期间是否被 stop / rm
+	latestInfo, err := container.GetContainerInfoByNameID(containerID)
+	if err != nil {
+		log.Warnf("Container %v has been removed : %v", containerID, err)
+		return nil
+	}
+
+	if !latestInfo.Status.Restarting {
+		log.Debugf("Container %v has been stopped, cancel restart", containerID)
+		return nil
+	}
+
+	return latestInfo
+}
+
+// sendMonitorResult 返回容器启动结果并关闭管道
+func sendMonitorResult(resultPipe *os.File, pid int, err error) {
+	defer resultPipe.Close()
+
+	result := &monitorResult{Pid: pid}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	resultBytes, _ := json.Marshal(result)
+	if _, err := resultPipe.Write(resultBytes); err != nil {
+		log.Errorf("Send monitor result error : %v", err)
+	}
+}
+
+// recordContainerExit 记录容器退出信息，并清理网络和 cgroup
+// 返回重新读取的 containerInfo, 包含运行期间 update 修改的资源限制, 容器已被删除时返回 nil
+func recordContainerExit(containerInfo *container.ContainerInfo, state *os.ProcessState, oomKilled bool) *container.ContainerInfo {
+
+	containerID := containerInfo.ID
+
+	// 断开网络连接
+	if err := network.Disconnect(containerInfo.NetWorks.Network.ID, containerInfo); err != nil {
+		log.Errorf("Disconnect container %v network error %v", containerID, err)
+	}
+
+	// 删除 cgroup
+	containerInfo.Cgroup.Destroy()
+
+	// 在 config.json 文件锁内重新读取, 容器运行期间可能被 stop update 等操作修改
+	// 只修改退出相关的状态, 不写回 monitor 持有的旧配置
+	// 容器已被删除时直接返回
+	var exitInfo *container.ContainerInfo
+	err := container.UpdateContainerInfo(containerID, func(latestInfo *container.ContainerInfo) (bool, error) {
+		status := latestInfo.Status
+
+		// 退出码 与 信号
+		if waitStatus, ok := state.Sys().(syscall.WaitStatus); ok {
+			switch {
+			case waitStatus.Exited():
+				status.ExitCode = waitStatus.ExitStatus()
+				status.Signal = ""
+			case waitStatus.Signaled():
+				status.ExitCode = 128 + int(waitStatus.Signal())
+				status.Signal = waitStatus.Signal().String()
+			}
+		}
+
+		status.FinishedAt = time.Now().Format("2006-01-02 15:04:05")
+		status.Pid = -1
+		status.MonitorPid = 0
+
+		// 由 qsrdocker stop 退出为 Stopped, 被 OOM killer 杀死为 OOMKilled, 否则为 Dead
+		switch {
+		case status.StopRequested || status.Stopped:
+			status.StatusSet("Stopped")
+		case oomKilled:
+			status.StatusSet("OOMKilled")
+		default:
+			status.StatusSet("Dead")
+		}
+		status.StopRequested = false
+
+		exitInfo = latestInfo
+
+		return true, nil
+	})
+	if err != nil {
+		log.Warnf("Record container %v exit info error %v", containerID, err)
+		return nil
+	}
+
+	log.Debugf("Container %v exit code %v signal %v", containerID, exitInfo.Status.ExitCode, exitInfo.Status.Signal)
+
+	container.RecordContainerEvent("die", exitInfo, map[string]string{
+		"exitCode":  strconv.Itoa(exitInfo.Status.ExitCode),
+		"signal":    exitInfo.Status.Signal,
+		"oomKilled": strconv.FormatBool(oomKilled),
+	})
+
+	return exitInfo
+}
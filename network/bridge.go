@@ -145,7 +145,7 @@ func (bridge *BridgeNetworkDriver) initBridge(network *container.Network) error
 	gatewayIP := *network.IPRange
 	gatewayIP.IP = net.ParseIP(network.GateWayIP)
 
-	log.Debug("Get gate way ip %v", gatewayIP.IP.String())
+	log.Debugf("Get gate way ip %v", gatewayIP.IP.String())
 
 	// 在 host os 上  ip set [interface]
 	if err := setInterfaceIP(bridgeID, gatewayIP.String()); err != nil {
@@ -300,6 +300,69 @@ func (ipam *IPAM) Allocate(subnet *net.IPNet) (ip net.IP, err error) {
 	return
 }
 
+// AllocateIP 分配指定的 IP 地址, 已被占用时返回错误
+// 用于 start / restart 时保留容器原有的 IP
+func (ipam *IPAM) AllocateIP(subnet *net.IPNet, ip net.IP) (net.IP, error) {
+
+	// 存放网段中地址分配信息的 字符串切片
+	ipam.Subnets = &map[string]string{}
+
+	// 增加文件锁
+	if err := ipam.lock(); err != nil {
+		return nil, err
+	}
+
+	// 删除文件锁
+	defer ipam.unlock()
+
+	if exists, _ := container.PathExists(ipam.SubnetAllocatorPath); exists {
+		if err := ipam.load(); err != nil {
+			return nil, fmt.Errorf("Load SubNet info error %v", err)
+		}
+	}
+
+	// 将字符串转化为 网段信息
+	_, subnet, _ = net.ParseCIDR(subnet.String())
+
+	ipAllocStr, exist := (*ipam.Subnets)[subnet.String()]
+	if !exist {
+		return nil, fmt.Errorf("Subnet %v is not exist, please Create Network first", subnet.String())
+	}
+
+	allocIP := ip.To4()
+	if allocIP == nil || !subnet.Contains(allocIP) {
+		return nil, fmt.Errorf("IP %v is not in subnet %v", ip, subnet.String())
+	}
+
+	// 与 Allocate 相反，主机位从 1 开始分配，需要 -1
+	offset := 0
+	for t := uint(4); t > 0; t-- {
+		offset += int(allocIP[t-1]-subnet.IP[t-1]) << ((4 - t) * 8)
+	}
+	offset--
+
+	if offset <= 0 || offset >= len(ipAllocStr) {
+		return nil, fmt.Errorf("IP %v can't be allocated in subnet %v", ip, subnet.String())
+	}
+
+	if ipAllocStr[offset] != '0' {
+		return nil, fmt.Errorf("IP %v has been allocated in subnet %v", ip, subnet.String())
+	}
+
+	ipAllocs := []byte(ipAllocStr)
+	ipAllocs[offset] = '1'
+	(*ipam.Subnets)[subnet.String()] = string(ipAllocs)
+
+	log.Debugf("Allocate IP  %v success in %v", allocIP.String(), subnet.String())
+
+	// 持久化
+	if err := ipam.dump(); err != nil {
+		return nil, err
+	}
+
+	return allocIP, nil
+}
+
 // Release 使用图位法释放IP地址
 func (ipam *IPAM) Release(subnet *net.IPNet, ip *net.IP) error {
 
@@ -328,7 +391,13 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ip *net.IP) error {
 	offset := 0
 
 	// 将ip地址设置为4字节表达形式
-	releaseIP := ip.To4()
+	// 复制一份再计算, 不修改调用方的 ip
+	// 调用方的 ip 可能是 containerInfo.NetWorks.IPAddress, 释放后还会用于 AllocateIP 保留原有 IP
+	if ip.To4() == nil {
+		return fmt.Errorf("IP %v is not an IPv4 address", *ip)
+	}
+	releaseIP := make(net.IP, net.IPv4len)
+	copy(releaseIP, ip.To4())
 
 	// 除去 网关 1 地址
 	releaseIP[3]--
@@ -356,10 +425,7 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ip *net.IP) error {
 	// 持久化
 	ipam.dump()
 
-	// 恢复IP
-	releaseIP[3]++
-
-	log.Debugf("Release IP %v success", releaseIP.String())
+	log.Debugf("Release IP %v success", ip.String())
 
 	return nil
 }
@@ -1,7 +1,10 @@
 package network
 
 import (
+	"io/ioutil"
 	"net"
+	"os"
+	"path"
 	"testing"
 )
 
@@ -28,3 +31,53 @@ func TestRelease(t *testing.T) {
 	ipAllocator.Release(ipnet, &ip)
 	t.Logf("release ip: %v", ip.String())
 }
+
+func TestAllocateIPAfterRelease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ipam := &IPAM{
+		SubnetAllocatorPath: path.Join(dir, "subnet.json"),
+		SubnetLockPath:      path.Join(dir, "ipam.lock"),
+	}
+
+	_, ipnet, _ := net.ParseCIDR("10.250.0.0/24")
+	if err := ipam.Create(ipnet); err != nil {
+		t.Fatalf("create network %v error %v", ipnet, err)
+	}
+
+	// 第一个地址为网关
+	if _, err := ipam.Allocate(ipnet); err != nil {
+		t.Fatalf("alloc gateway ip error %v", err)
+	}
+
+	ip, err := ipam.Allocate(ipnet)
+	if err != nil {
+		t.Fatalf("alloc ip error %v", err)
+	}
+	want := ip.String()
+
+	// Release 不能修改调用方的 IP, monitor 释放后会用同一个 IP 重新分配
+	if err := ipam.Release(ipnet, &ip); err != nil {
+		t.Fatalf("release ip %v error %v", want, err)
+	}
+	if ip.String() != want {
+		t.Fatalf("release modified ip %v to %v", want, ip)
+	}
+
+	allocIP, err := ipam.AllocateIP(ipnet, ip)
+	if err != nil {
+		t.Fatalf("re-alloc ip %v error %v", want, err)
+	}
+	if allocIP.String() != want {
+		t.Fatalf("re-alloc ip got %v, want %v", allocIP, want)
+	}
+
+	// 已被分配的 IP 不能再次分配
+	if _, err := ipam.AllocateIP(ipnet, ip); err == nil {
+		t.Fatalf("re-alloc allocated ip %v should fail", want)
+	}
+}
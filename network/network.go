@@ -90,7 +90,13 @@ func CreateNetwork(driver, subnet, networkID string) error {
 		return err
 	}
 
-	return nw.Dump()
+	if err := nw.Dump(); err != nil {
+		return err
+	}
+
+	container.RecordEvent(container.EventTypeNetwork, "create", networkID, map[string]string{"driver": nw.Driver, "subnet": subnet})
+
+	return nil
 }
 
 // DeleteNetwork 删除网络
@@ -118,7 +124,13 @@ func DeleteNetwork(networkID string) error {
 	log.Debugf("Del network %v success", networkID)
 
 	// 删除配置文件
-	return nw.Remove()
+	if err := nw.Remove(); err != nil {
+		return err
+	}
+
+	container.RecordEvent(container.EventTypeNetwork, "destroy", networkID, nil)
+
+	return nil
 }
 
 // Connect 连接容器和已创建网络
@@ -140,9 +152,22 @@ func Connect(networkID, netDriver string, portSlice []string, containerInfo *con
 	}
 
 	// 分配容器IP地址
-	ip, err := ipAllocator.Allocate(nw.IPRange)
-	if err != nil {
-		return err
+	// start / restart 时尽量保留原有 IP
+	var ip net.IP
+	var err error
+	if containerInfo.NetWorks != nil && containerInfo.NetWorks.IPAddress != nil &&
+		containerInfo.NetWorks.Network != nil && containerInfo.NetWorks.Network.ID == networkID {
+		if prevIP, err := ipAllocator.AllocateIP(nw.IPRange, containerInfo.NetWorks.IPAddress); err == nil {
+			ip = prevIP
+		} else {
+			log.Debugf("Can't reuse ip %v : %v", containerInfo.NetWorks.IPAddress, err)
+		}
+	}
+
+	if ip == nil {
+		if ip, err = ipAllocator.Allocate(nw.IPRange); err != nil {
+			return err
+		}
 	}
 
 	// 创建网络端点
@@ -153,42 +178,9 @@ func Connect(networkID, netDriver string, portSlice []string, containerInfo *con
 	}
 
 	// 解析 Ports
-	// hostPort:containerPort、ip:hostPort:containerPort
-	// [80:80, 127.1.2.3:3306:3306]
 	// portSlice 存在可能是 start 操作
 	if portSlice != nil {
-		ports := map[string][]*container.Port{}
-
-		for _, portPair := range portSlice {
-			// 按照 ： 拆分
-			portPairSlice := strings.Split(portPair, ":")
-			portPairSlice = container.RemoveNullSliceString(portPairSlice)
-			port := &container.Port{}
-
-			// 根据长度判断 host ip
-			// 80:80
-			if len(portPairSlice) == 2 {
-				port.HostIP = "0.0.0.0"
-				port.HostPort = portPairSlice[0]
-				if !strings.Contains(portPairSlice[1], "/") {
-					portPairSlice[1] = fmt.Sprintf("%s/tcp", portPairSlice[1])
-				}
-				ports[portPairSlice[1]] = append(ports[portPairSlice[1]], port)
-			}
-
-			// 127.1.2.3:3306:3306
-			if len(portPairSlice) == 3 {
-				port.HostIP = portPairSlice[0]
-				port.HostPort = portPairSlice[1]
-				if !strings.Contains(portPairSlice[2], "/") {
-					portPairSlice[2] = fmt.Sprintf("%s/tcp", portPairSlice[2])
-				}
-				ports[portPairSlice[2]] = append(ports[portPairSlice[2]], port)
-			}
-		}
-
-		// 端口映射 map
-		ep.Ports = ports
+		ep.Ports = ParsePortMapping(portSlice)
 	} else {
 		// 若是 start 操作 ，则继承已经解析好的 ports
 		ep.Ports = containerInfo.NetWorks.Ports
@@ -207,7 +199,50 @@ func Connect(networkID, netDriver string, portSlice []string, containerInfo *con
 	}
 
 	// 利用 IP tables 配置主机和容器的端口映射
-	return configPortMapping(containerInfo)
+	if err = configPortMapping(containerInfo); err != nil {
+		return err
+	}
+
+	container.RecordEvent(container.EventTypeNetwork, "connect", networkID, map[string]string{"container": containerInfo.ID, "name": containerInfo.Name})
+
+	return nil
+}
+
+// ParsePortMapping 解析端口映射
+// hostPort:containerPort、ip:hostPort:containerPort
+// [80:80, 127.1.2.3:3306:3306]
+func ParsePortMapping(portSlice []string) map[string][]*container.Port {
+	ports := map[string][]*container.Port{}
+
+	for _, portPair := range portSlice {
+		// 按照 ： 拆分
+		portPairSlice := strings.Split(portPair, ":")
+		portPairSlice = container.RemoveNullSliceString(portPairSlice)
+		port := &container.Port{}
+
+		// 根据长度判断 host ip
+		// 80:80
+		if len(portPairSlice) == 2 {
+			port.HostIP = "0.0.0.0"
+			port.HostPort = portPairSlice[0]
+			if !strings.Contains(portPairSlice[1], "/") {
+				portPairSlice[1] = fmt.Sprintf("%s/tcp", portPairSlice[1])
+			}
+			ports[portPairSlice[1]] = append(ports[portPairSlice[1]], port)
+		}
+
+		// 127.1.2.3:3306:3306
+		if len(portPairSlice) == 3 {
+			port.HostIP = portPairSlice[0]
+			port.HostPort = portPairSlice[1]
+			if !strings.Contains(portPairSlice[2], "/") {
+				portPairSlice[2] = fmt.Sprintf("%s/tcp", portPairSlice[2])
+			}
+			ports[portPairSlice[2]] = append(ports[portPairSlice[2]], port)
+		}
+	}
+
+	return ports
 }
 
 // Disconnect 解除容器和已创建网络的连接
@@ -233,7 +268,13 @@ func Disconnect(networkID string, containerInfo *container.ContainerInfo) error
 	// 暂时不请客 容器 网络状态
 	// containerInfo.NetWorks = &container.Endpoint{}
 
-	return delPortMapping(containerInfo)
+	if err := delPortMapping(containerInfo); err != nil {
+		return err
+	}
+
+	container.RecordEvent(container.EventTypeNetwork, "disconnect", networkID, map[string]string{"container": containerInfo.ID, "name": containerInfo.Name})
+
+	return nil
 }
 
 // enterContainerNetNs 进入容器 NET NS
@@ -447,7 +488,7 @@ func InitNetwork() {
 		nw, err := NetworkDriverMap[strings.ToLower(nw.Driver)].Create(nw.IPRangeString, nw.ID)
 
 		if err != nil {
-			log.Error("Restore network %v error %v", nw.ID, err)
+			log.Errorf("Restore network %v error %v", nw.ID, err)
 		}
 
 		nw.Dump()
@@ -0,0 +1,46 @@
+package network
+
+import (
+	"fmt"
+	"qsrdocker/container"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+// InterfaceStats 容器网卡流量统计, 以容器为视角
+type InterfaceStats struct {
+	Name      string `json:"Name"` // 宿主机一侧的 veth 名称
+	RxBytes   uint64 `json:"RxBytes"`
+	RxPackets uint64 `json:"RxPackets"`
+	TxBytes   uint64 `json:"TxBytes"`
+	TxPackets uint64 `json:"TxPackets"`
+}
+
+// GetEndpointStats 通过 netlink 读取 bridge 网络端点宿主机一侧 veth 的流量计数
+// 宿主机一侧接收的数据即容器发送的数据
+// 非 bridge 网络返回 nil
+func GetEndpointStats(endpoint *container.Endpoint) (*InterfaceStats, error) {
+
+	if endpoint == nil || endpoint.Network == nil || strings.ToLower(endpoint.Network.Driver) != "bridge" || endpoint.VethName == "" {
+		return nil, nil
+	}
+
+	vethLink, err := netlink.LinkByName(endpoint.VethName)
+	if err != nil {
+		return nil, fmt.Errorf("Get veth %v error %v", endpoint.VethName, err)
+	}
+
+	linkStats := vethLink.Attrs().Statistics
+	if linkStats == nil {
+		return nil, fmt.Errorf("Veth %v has no statistics", endpoint.VethName)
+	}
+
+	return &InterfaceStats{
+		Name:      endpoint.VethName,
+		RxBytes:   linkStats.TxBytes,
+		RxPackets: linkStats.TxPackets,
+		TxBytes:   linkStats.RxBytes,
+		TxPackets: linkStats.RxPackets,
+	}, nil
+}
@@ -2,11 +2,12 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"qsrdocker/container"
-	"qsrdocker/network"
 	"strings"
 	"text/tabwriter"
 
@@ -27,8 +28,8 @@ var networkCmd = cli.Command{
 
 // networkCreateCmd 创建网络
 var networkCreateCmd = cli.Command{
-	Name:  "create",
-	Usage: "create a container network",
+	Name:      "create",
+	Usage:     "create a container network",
 	ArgsUsage: "NetWorkName",
 	Flags: []cli.Flag{
 		cli.StringFlag{
@@ -58,33 +59,29 @@ var networkCreateCmd = cli.Command{
 			return fmt.Errorf("Missing network CIDR")
 		}
 
-		// 创建目标网络
-		err := network.CreateNetwork(networkDriver, context.String("subnet"), networkID)
-		if err != nil {
-			return fmt.Errorf("Create network %v in driver %v error: %+v", networkID, networkDriver, err)
-		}
-		return nil
+		// 通过 qsrdockerd 创建目标网络
+		return daemonRequest(http.MethodPost, "/networks", nil, &apiNetworkCreate{
+			Name:   networkID,
+			Driver: networkDriver,
+			Subnet: subnetCIDR,
+		}, nil)
 	},
 }
 
 // networkRemoveCmd 删除已创建网络
 var networkRemoveCmd = cli.Command{
-	Name: "remove",
-	Usage: "Remove Network",
+	Name:      "remove",
+	Usage:     "Remove Network",
 	ArgsUsage: "NetWorkName",
 	Action: func(context *cli.Context) error {
-		
-		// 判断是否输入 NetWork Name 
+
+		// 判断是否输入 NetWork Name
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("Missing network name")
 		}
 
-		// 删除网络
-		err := network.DeleteNetwork(context.Args()[0])
-		if err != nil {
-			return fmt.Errorf("Remove network %v error: %v", context.Args()[0], err)
-		}
-		return nil
+		// 通过 qsrdockerd 删除网络
+		return daemonRequest(http.MethodDelete, strings.Join([]string{"/networks", url.PathEscape(context.Args()[0])}, "/"), nil, nil, nil)
 	},
 }
 
@@ -102,6 +99,27 @@ var networkLsCmd = cli.Command{
 
 // listNetWork 显示现在存在的网络
 func listNetwork() {
+	networks := getNetworks()
+
+	// 表格打印
+	w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
+	fmt.Fprint(w, "NETWORK ID\tGateWay IP\tIP Range\tDriver\n")
+	for _, nw := range networks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			nw.ID,
+			nw.GateWayIP,
+			nw.IPRangeString,
+			nw.Driver,
+		)
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+	}
+}
+
+// getNetworks 获取所有已创建网络的配置
+func getNetworks() []*container.Network {
 	networks := []*container.Network{}
 
 	// 获取网络配置数据
@@ -128,19 +146,5 @@ func listNetwork() {
 		},
 	)
 
-	// 表格打印
-	w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
-	fmt.Fprint(w, "NETWORK ID\tGateWay IP\tIP Range\tDriver\n")
-	for _, nw := range networks {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
-			nw.ID,
-			nw.GateWayIP,
-			nw.IPRangeString,
-			nw.Driver,
-		)
-	}
-
-	if err := w.Flush(); err != nil {
-		log.Errorf("Flush error %v", err)
-	}
+	return networks
 }
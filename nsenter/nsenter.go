@@ -13,6 +13,13 @@ package nsenter
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <grp.h>
+#include <sys/wait.h>
+#include <sys/prctl.h>
+#include <sys/syscall.h>
+#include <linux/capability.h>
+#include <linux/filter.h>
+#include <linux/seccomp.h>
 
 // __attribute__ 代表这个包被引用则自动执行该函数，类似go中的 init()
 // 或者 类似 构造函数
@@ -85,9 +92,127 @@ __attribute__((constructor)) void enter_namespace(void) {
 		close(fd);
 	}
 
+	// -w 工作目录, 加入 mnt namespace 后当前目录为容器的根目录
+	char *QSRDOCKER_WORKDIR = getenv("QSRDOCKER_WORKDIR");
+	if (QSRDOCKER_WORKDIR) {
+		if (chdir(QSRDOCKER_WORKDIR) == -1) {
+			fprintf(stderr, "chdir to %s failed: %s\n", QSRDOCKER_WORKDIR, strerror(errno));
+			exit(126);
+		}
+		unsetenv("QSRDOCKER_WORKDIR");
+	}
+
+	// --cap-add --cap-drop 保留的 capability 位图, 十六进制
+	// 加入 user namespace 后拥有全部 capability, 在切换用户前限制 bounding set
+	char *QSRDOCKER_CAPS = getenv("QSRDOCKER_CAPS");
+	unsigned long long caps = 0;
+	if (QSRDOCKER_CAPS) {
+		caps = strtoull(QSRDOCKER_CAPS, NULL, 16);
+		for (i=0; i<64; i++) {
+			if (caps & (1ULL << i)) {
+				continue;
+			}
+			// 内核不支持的 capability 返回 EINVAL
+			if (prctl(PR_CAPBSET_DROP, i, 0, 0, 0) == -1 && errno != EINVAL) {
+				fprintf(stderr, "drop capability %d from bounding set failed: %s\n", i, strerror(errno));
+				exit(126);
+			}
+		}
+		// 切换到非 root 用户时保留 permitted capability
+		if (prctl(PR_SET_KEEPCAPS, 1, 0, 0, 0) == -1) {
+			fprintf(stderr, "set keep capabilities failed: %s\n", strerror(errno));
+			exit(126);
+		}
+	}
+
+	// -u 运行用户, uid gid 附加组由 qsrdocker 根据容器的 /etc/passwd /etc/group 解析
+	// user namespace 禁用 setgroups 时不设置 QSRDOCKER_GROUPS
+	char *QSRDOCKER_UID = getenv("QSRDOCKER_UID");
+	char *QSRDOCKER_GID = getenv("QSRDOCKER_GID");
+	char *QSRDOCKER_GROUPS = getenv("QSRDOCKER_GROUPS");
+	if (QSRDOCKER_UID && QSRDOCKER_GID) {
+		if (QSRDOCKER_GROUPS) {
+			gid_t groups[64];
+			int ngroups = 0;
+			char *group = strtok(QSRDOCKER_GROUPS, ",");
+			while (group && ngroups < 64) {
+				groups[ngroups++] = (gid_t)atoi(group);
+				group = strtok(NULL, ",");
+			}
+			if (setgroups(ngroups, groups) == -1) {
+				fprintf(stderr, "setgroups failed: %s\n", strerror(errno));
+				exit(126);
+			}
+		}
+		if (setgid((gid_t)atoi(QSRDOCKER_GID)) == -1) {
+			fprintf(stderr, "setgid %s failed: %s\n", QSRDOCKER_GID, strerror(errno));
+			exit(126);
+		}
+		if (setuid((uid_t)atoi(QSRDOCKER_UID)) == -1) {
+			fprintf(stderr, "setuid %s failed: %s\n", QSRDOCKER_UID, strerror(errno));
+			exit(126);
+		}
+		unsetenv("QSRDOCKER_UID");
+		unsetenv("QSRDOCKER_GID");
+		unsetenv("QSRDOCKER_GROUPS");
+	}
+
+	if (QSRDOCKER_CAPS) {
+		struct __user_cap_header_struct header = { _LINUX_CAPABILITY_VERSION_3, 0 };
+		struct __user_cap_data_struct data[2];
+		for (i=0; i<2; i++) {
+			data[i].effective = data[i].permitted = data[i].inheritable = (__u32)(caps >> (32 * i));
+		}
+		if (syscall(SYS_capset, &header, data) == -1) {
+			fprintf(stderr, "set capabilities failed: %s\n", strerror(errno));
+			exit(126);
+		}
+		unsetenv("QSRDOCKER_CAPS");
+	}
+
+	// 禁止通过 setuid 文件获得权限
+	if (getenv("QSRDOCKER_NO_NEW_PRIVS")) {
+		if (prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) == -1) {
+			fprintf(stderr, "set no new privileges failed: %s\n", strerror(errno));
+			exit(126);
+		}
+		unsetenv("QSRDOCKER_NO_NEW_PRIVS");
+	}
+
+	// 容器的 seccomp 过滤程序, 每条指令为 16 位十六进制 code jt jf k
+	char *QSRDOCKER_SECCOMP = getenv("QSRDOCKER_SECCOMP");
+	if (QSRDOCKER_SECCOMP) {
+		size_t len = strlen(QSRDOCKER_SECCOMP) / 16;
+		struct sock_filter *filter = calloc(len, sizeof(struct sock_filter));
+		char field[9];
+		size_t n;
+		for (n=0; filter && n<len; n++) {
+			char *insn = QSRDOCKER_SECCOMP + n * 16;
+			memcpy(field, insn, 4); field[4] = 0;
+			filter[n].code = (__u16)strtoul(field, NULL, 16);
+			memcpy(field, insn + 4, 2); field[2] = 0;
+			filter[n].jt = (__u8)strtoul(field, NULL, 16);
+			memcpy(field, insn + 6, 2); field[2] = 0;
+			filter[n].jf = (__u8)strtoul(field, NULL, 16);
+			memcpy(field, insn + 8, 8); field[8] = 0;
+			filter[n].k = (__u32)strtoul(field, NULL, 16);
+		}
+		struct sock_fprog prog = { (unsigned short)len, filter };
+		unsetenv("QSRDOCKER_SECCOMP");
+		if (!filter || prctl(PR_SET_SECCOMP, SECCOMP_MODE_FILTER, &prog, 0, 0) == -1) {
+			fprintf(stderr, "set seccomp filter failed: %s\n", strerror(errno));
+			exit(126);
+		}
+		free(filter);
+	}
+
 	// 进入新的 namespace 执行命令
+	// 返回命令的退出码，供健康检查等调用方判断结果
 	int res = system(QSRDOCKER_CMD);
-	exit(0);
+	if (res != -1 && WIFEXITED(res)) {
+		exit(WEXITSTATUS(res));
+	}
+	exit(1);
 	return;
 }
 */
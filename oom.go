@@ -0,0 +1,96 @@
+package main
+
+import (
+	"qsrdocker/container"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// startOOMMonitor 监听容器 cgroup 的 OOM 事件
+// 由 monitor 在容器进程启动后调用，返回的函数在容器进程退出后、cgroup 删除前调用
+// 停止监听并返回本次运行期间是否有进程被 OOM killer 杀死
+func startOOMMonitor(containerInfo *container.ContainerInfo) func() bool {
+
+	containerID := containerInfo.ID
+	pid := containerInfo.Status.Pid
+	cgroupManager := containerInfo.Cgroup
+
+	// 记录初始的 oom_kill 计数, 容器退出时比较, 避免退出早于通知被处理
+	startState, err := cgroupManager.OOMState(pid)
+	if err != nil {
+		log.Warnf("Get container %v oom state error %v", containerID, err)
+	}
+
+	oomCh, stopNotify, err := cgroupManager.NotifyOOM(pid)
+	if err != nil {
+		log.Warnf("Register container %v oom notify error %v", containerID, err)
+		return func() bool { return false }
+	}
+
+	doneCh := make(chan bool)
+
+	go func() {
+		oomKilled := false
+
+		// stopNotify 后 oomCh 被关闭
+		for range oomCh {
+			log.Debugf("Container %v out of memory", containerID)
+
+			// oom_kill_disable 时进程不会被杀死, 而是挂起等待内存释放
+			state, _ := cgroupManager.OOMState(pid)
+			stalled := state != nil && state.OOMKillDisable && state.UnderOOM
+			if !stalled {
+				oomKilled = true
+			}
+
+			recordOOM(containerID, pid, stalled)
+		}
+
+		doneCh <- oomKilled
+	}()
+
+	return func() bool {
+		// 容器进程已退出, 此时 cgroup 尚未删除
+		endState, _ := cgroupManager.OOMState(pid)
+
+		stopNotify()
+		oomKilled := <-doneCh
+
+		if startState != nil && endState != nil && endState.OOMKillCount > startState.OOMKillCount {
+			oomKilled = true
+		}
+
+		return oomKilled
+	}
+}
+
+// recordOOM 记录 OOM 时间并写入事件
+// 在 config.json 文件锁内重新读取, 只修改 OOMTime, 避免覆盖 stop 等操作写入的状态
+func recordOOM(containerID string, pid int, stalled bool) {
+
+	var containerInfo *container.ContainerInfo
+
+	err := container.UpdateContainerInfo(containerID, func(info *container.ContainerInfo) (bool, error) {
+		if info.Status.Pid != pid {
+			return false, nil
+		}
+
+		info.Status.OOMTime = time.Now().Format("2006-01-02 15:04:05")
+		containerInfo = info
+
+		return true, nil
+	})
+	if err != nil {
+		log.Errorf("Record container %v oom info error %v", containerID, err)
+		return
+	}
+
+	// 容器已重启
+	if containerInfo == nil {
+		return
+	}
+
+	container.RecordContainerEvent("oom", containerInfo, map[string]string{"stalled": strconv.FormatBool(stalled)})
+}
@@ -18,8 +18,43 @@ import (
 )
 
 // QsrdockerRun 启动客户端
-func QsrdockerRun(tty bool, cmdList, volumes, envSlice, portmapping []string, resConfig *subsystems.ResourceConfig,
-	imageName, containerName, networkID, networkDriver, containerNetwork string) {
+// create + start, 返回新建容器的 ID，供 CLI / qsrdockerd API 使用
+// 容器交由 monitor 进程启动、回收
+// -it 容器由 monitor 持有 pty, 通过 qsrdocker attach 连接
+func QsrdockerRun(runConfig *container.RunConfig) (string, error) {
+
+	containerID, err := QsrdockerCreate(runConfig)
+	if err != nil {
+		return "", err
+	}
+
+	if err := startContainer(containerID, false); err != nil {
+		return containerID, err
+	}
+
+	return containerID, nil
+}
+
+// QsrdockerCreate 创建容器但不启动
+// 准备 workspace 数据卷 hosts 等文件，并将完整的运行配置持久化到 config.json
+// qsrdocker start 依据 config.json 启动容器
+func QsrdockerCreate(runConfig *container.RunConfig) (string, error) {
+
+	// 运行参数
+	cmdList := runConfig.Cmd
+	envSlice := runConfig.Env
+	resConfig := runConfig.Resource
+	imageName := runConfig.Image
+	containerName := runConfig.Name
+	stopSignal := runConfig.StopSignal
+	healthCheck := runConfig.HealthCheck
+	workingDir := runConfig.WorkingDir
+	user := runConfig.User
+
+	// 未设置资源限制时使用空配置
+	if resConfig == nil {
+		resConfig = &subsystems.ResourceConfig{}
+	}
 
 	// iptables初始化
 	network.IPtablesInit()
@@ -36,21 +71,9 @@ func QsrdockerRun(tty bool, cmdList, volumes, envSlice, portmapping []string, re
 	log.Debugf("Container ID is %v", containerID)
 
 	// 检测 containerName 是否被使用
-	cID, err := container.GetContainerIDByName(containerName)
-
-	// cID == ""  三种情况
-	// 1. can't get container Name:ID info cID == ""  err != nil  未通过测试，直接返回
-	// 2. containernames.json is not exist   cID == "" err == nil  通过测试
-	// 3. Name:ID not in config file
-	if strings.Replace(cID, " ", "", -1) == "" {
-		if err != nil && !strings.HasSuffix(err.Error(), "not in config file") {
-			log.Errorf("Container Name status err : %v", err)
-			return
-		}
-	} else {
-		// 成功获取 cID
-		log.Errorf("Container Name have been used in Container ID : %v", cID)
-		return
+	// 只检查完整的 name / ID, 容器名可以是其他容器ID的前缀
+	if err := checkContainerNameUsed(containerName); err != nil {
+		return "", err
 	}
 
 	// 获取 imageMateDataInfo
@@ -70,118 +93,195 @@ func QsrdockerRun(tty bool, cmdList, volumes, envSlice, portmapping []string, re
 				cmdList = append([]string{imageMateDataInfo.Path}, imageMateDataInfo.Args...)
 			}
 		}
+
+		// 未设置 --signal 时使用镜像的 STOPSIGNAL
+		if stopSignal == "" {
+			stopSignal = imageMateDataInfo.StopSignal
+		}
+
+		// 未设置 --health-cmd 时使用镜像的 HEALTHCHECK
+		if healthCheck == nil {
+			healthCheck = imageMateDataInfo.HealthCheck
+		}
+
+		// 未设置 -w -u 时使用镜像的 WORKDIR USER
+		if workingDir == "" {
+			workingDir = imageMateDataInfo.WorkingDir
+		}
+		if user == "" {
+			user = imageMateDataInfo.User
+		}
 	}
 
-	// 获取管道通信
-	containerProcess, writeCmdPipe, driverInfo := container.NewParentProcess(tty, containerName, containerID, imageName, networkDriver, envSlice)
+	if stopSignal == "" {
+		stopSignal = container.DefaultStopSignal
+	}
 
-	if containerProcess == nil || writeCmdPipe == nil || driverInfo == nil {
-		log.Errorf("New parent process error")
-		return
+	if len(cmdList) == 0 {
+		return "", fmt.Errorf("Run container get command is nil")
 	}
 
-	log.Debugf("Get Qsrdocker : %v parent process and pipe success", containerID)
+	// user namespace 映射在创建时确定, 重启时保持不变
+	usernsMode, err := container.ParseUsernsMode(runConfig.UsernsMode)
+	if err != nil {
+		return "", err
+	}
 
-	if err := containerProcess.Start(); err != nil { // 启动真正的容器进程
-		log.Error(err)
+	uidMap, gidMap, err := container.GetIDMappings(usernsMode)
+	if err != nil {
+		return "", err
 	}
 
-	log.Debugf("Create container process success, pis is %v ", containerProcess.Process.Pid)
+	// keep-id 默认以当前用户运行
+	if usernsMode == container.UsernsModeKeepID && user == "" {
+		user = fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	}
+
+	// --privileged 保留全部 capability, 不设置 no_new_privs
+	capabilities, err := container.TweakCapabilities(container.DefaultCapabilities, runConfig.CapAdd, runConfig.CapDrop, runConfig.Privileged)
+	if err != nil {
+		return "", err
+	}
+
+	// --privileged 未指定 --security-opt seccomp 时不限制系统调用
+	seccompProfile := runConfig.SeccompProfile
+	if runConfig.Privileged && seccompProfile == "" {
+		seccompProfile = container.SeccompProfileUnconfined
+	}
+
+	if err := container.CheckSeccompProfile(seccompProfile, capabilities); err != nil {
+		return "", err
+	}
+
+	// --device 宿主机设备, devices cgroup 默认禁止访问其他设备
+	devices, err := container.ParseDevices(runConfig.Devices)
+	if err != nil {
+		return "", err
+	}
+	resConfig.Devices = container.DeviceCgroupRules(devices, runConfig.Privileged)
+
+	// -v --mount --tmpfs 在创建 workspace 之前检查
+	mounts, err := container.ParseVolumes(runConfig.Volumes, runConfig.Mounts)
+	if err != nil {
+		return "", err
+	}
+
+	tmpfsMounts, err := container.ParseTmpfs(runConfig.Tmpfs)
+	if err != nil {
+		return "", err
+	}
+
+	// 创建容器运行目录
+	// 镜像层按照容器的 user namespace 映射转换属主
+	driverInfo, err := container.NewWorkSpace(imageName, containerID, uidMap, gidMap)
+	if err != nil {
+		// 若存在问题则删除挂载点目录
+		container.DeleteDockerDir(containerID)
+		return "", fmt.Errorf("Can't create docker workspace error : %v", err)
+	}
+
+	// 创建 log 文件
+	logFileFd, err := container.CreateContainerLogFile(containerID)
+	if err != nil {
+		container.DeleteWorkSpace(containerID)
+		return "", err
+	}
+	logFileFd.Close()
 
 	// 设置 新的 container info
 	containerInfo := &container.ContainerInfo{
 		ID:          containerID,   // 容器ID
 		Name:        containerName, // 容器name
 		CreatedTime: time.Now().Format("2006-01-02 15:04:05"),
-		Status: &container.StatusInfo{
-			Pid:       containerProcess.Process.Pid, // 容器进程 pid
-			StartTime: time.Now().Format("2006-01-02 15:04:05"),
-		},
+		Status:      &container.StatusInfo{},
 		Driver:      container.Driver,
 		GraphDriver: driverInfo,
-		TTy:         tty,
+		TTy:         runConfig.Tty,
 		Image:       imageName,
 		Path:        cmdList[0],
+		Args:        cmdList[1:],
 		Env:         envSlice, // 这里不需要加入 os.env() 仅仅只需要存入 -e 的输入
+		// 网络在容器进程启动后才能连接，先记录网络和端口映射
+		NetWorks: &container.Endpoint{
+			Network: &container.Network{
+				ID:     runConfig.NetworkID,
+				Driver: runConfig.NetworkDriver,
+			},
+			Ports: network.ParsePortMapping(runConfig.PortMapping),
+		},
+		Cgroup:      cgroups.NewCgroupManager(containerID, resConfig),
+		Restart:     runConfig.Restart,
+		StopSignal:  stopSignal,
+		HealthCheck: healthCheck,
+		Init:        runConfig.Init,
+		WorkingDir:  workingDir,
+		User:        user,
+		Hostname:    runConfig.Hostname,
+		UsernsMode:  usernsMode,
+		UidMappings: uidMap,
+		GidMappings: gidMap,
+
+		Privileged:   runConfig.Privileged,
+		CapAdd:       runConfig.CapAdd,
+		CapDrop:      runConfig.CapDrop,
+		Capabilities: capabilities,
+		ShmSize:      runConfig.ShmSize,
+		Devices:      devices,
+		ReadOnly:     runConfig.ReadOnly,
+		NoNewPrivs:   !runConfig.Privileged,
 	}
 
-	if len(cmdList) >= 1 {
-		containerInfo.Args = cmdList[1:]
-	}
-
-	// 检测 container 进程 状态
-	containerInfo.Status.StatusCheck()
+	containerInfo.Status.StatusSet("Created")
 
 	// 初始化 hosts hostname resolv.conf
-	container.InitContainerHostConfig(containerID)
-
-	// 将 hosts hostname resolv.conf 加入 bind mount
-	volumes = container.AddHostConfig(containerID, volumes)
-
-	// 创建 mount bind 数据卷 挂载 信息文件
-	mountInfo := container.SetVolume(containerID, volumes)
-	log.Debugf("SetVolume qsrdocker %v Info file", containerID)
+	container.InitContainerHostConfig(containerID, containerInfo.GetHostname())
 
-	containerInfo.Mount = mountInfo
-
-	// 创建 cgroup_manager
-	cgroupManager := cgroups.NewCgroupManager(containerID, resConfig)
-	// defer cgroupManager.Destroy()
-
-	// 初始化 /sys/fs/cgroup/[subsystem]/qsrdocker
-	cgroupManager.Init()
-
-	// set 设置资源
-	cgroupManager.Set()
-
-	// apply 应用资源(绑定PID至目标task)
-	cgroupManager.Apply(containerProcess.Process.Pid)
-
-	log.Debugf("Create cgroup config: %+v", resConfig)
-
-	// 将 cgroup 信息 存入 containerinfo
-	containerInfo.Cgroup = cgroupManager
+	// 自定义 seccomp profile 保存在容器目录中
+	if containerInfo.Seccomp, err = container.SaveSeccompProfile(containerID, seccompProfile); err != nil {
+		container.DeleteWorkSpace(containerID)
+		return "", err
+	}
 
-	// 连接网络
-	if err := network.Connect(networkID, networkDriver, portmapping, containerInfo); err != nil {
-		log.Errorf("Error Connect Network %v, using the host network now", err)
+	// 引用命名数据卷, 不存在时自动创建, -v /path 与未指定 source 的 --mount type=volume 创建匿名数据卷
+	if err := container.AttachVolumes(containerID, mounts); err != nil {
+		container.DeleteWorkSpace(containerID)
+		return "", err
 	}
 
-	// 将用户命令发送给 init container 进程
-	sendInitCommand(cmdList, writeCmdPipe)
+	// 创建 mount bind 数据卷 挂载 信息文件
+	// 将 hosts hostname resolv.conf 加入 bind mount
+	containerInfo.Mount = append(mounts, tmpfsMounts...)
+	containerInfo.Mount = append(containerInfo.Mount, container.SetVolume(containerID, container.AddHostConfig(containerID, nil))...)
+	log.Debugf("SetVolume qsrdocker %v Info file", containerID)
 
 	// 完成 ContainerName: ContainerID 的映射关系
 	recordContainerNameInfo(containerName, containerID)
 
 	// 将 containerInfo 存入
-	container.RecordContainerInfo(containerInfo, containerID)
+	if err := container.RecordContainerInfo(containerInfo, containerID); err != nil {
+		removeCreatedContainer(containerID, containerInfo.Mount)
+		return "", err
+	}
 
-	if tty {
-		containerProcess.Wait()
-		// 进程退出 exit
+	container.RecordContainerEvent("create", containerInfo, nil)
 
-		// 断开网络连接
-		err = network.Disconnect(containerInfo.NetWorks.Network.ID, containerInfo)
-		if err != nil {
-			log.Errorf("Stop container %v network error %v", containerName, err)
-		}
+	return containerID, nil
+}
 
-		// 删除容器信息
-		RemoveContainerNameInfo(containerID)
+// removeCreatedContainer 删除容器信息与工作目录
+// 与 docker run --rm 相同, 同时删除容器的匿名数据卷
+func removeCreatedContainer(containerID string, mounts []*container.MountInfo) {
 
-		// 删除工作目录
-		//if err := container.DeleteWorkSpace(containerID, volumes); err != nil {
-		if err := container.DeleteWorkSpace(containerID); err != nil {
-			log.Errorf("Error: %v", err)
-		}
-		// 删除 cgroup
-		cgroupManager.Destroy()
-	} else {
-		fmt.Printf("%v\n", containerID)
-	}
+	// 释放数据卷引用
+	container.ReleaseVolumes(containerID, mounts, true)
 
-	// 后台启动不需要 exit 了
-	//os.Exit(-1)
+	// 删除容器信息
+	RemoveContainerNameInfo(containerID)
+
+	// 删除工作目录
+	if err := container.DeleteWorkSpace(containerID); err != nil {
+		log.Errorf("Error: %v", err)
+	}
 }
 
 // readContainerPath 获取用户参数
@@ -201,16 +301,6 @@ func readContainerPath(readPipe *os.File) string {
 	return pathString
 }
 
-// sendInitCommand 将用户命令发送给守护进程 Parent
-func sendInitCommand(cmdList []string, writePipe *os.File) {
-	cmd := strings.Join(cmdList, " ") // 转为字符串
-	log.Debugf("Command : %v", cmd)
-
-	// 将 cmd 字符串通过管道传给 守护进程 parent
-	writePipe.WriteString(cmd)
-	writePipe.Close() // 关闭写端
-}
-
 // randStringContainerID 随机获取容器id
 func randStringContainerID(n int) string {
 
@@ -330,6 +420,21 @@ func recordContainerNameInfo(containerName, containerID string) {
 	}
 }
 
+// checkContainerNameUsed 检测 containerName 是否已被使用
+func checkContainerNameUsed(containerName string) error {
+
+	containerNameConfig, err := container.GetContainerNameConfig()
+	if err != nil {
+		return fmt.Errorf("Container Name status err : %v", err)
+	}
+
+	if cID, e := containerNameConfig[containerName]; e {
+		return fmt.Errorf("Container Name have been used in Container ID : %v", cID)
+	}
+
+	return nil
+}
+
 // RemoveContainerNameInfo 删除 name : id 映射
 func RemoveContainerNameInfo(containerID string) {
 
@@ -393,3 +498,52 @@ func RemoveContainerNameInfo(containerID string) {
 		log.Debugf("Remove container Name:ID success")
 	}
 }
+
+// renameContainerNameInfo 修改 name : id 映射
+// 先写入临时文件再 rename, 保证 containernames.json 不会被写坏
+func renameContainerNameInfo(containerID, oldName, newName string) error {
+
+	containerNameConfig, err := container.GetContainerNameConfig()
+	if err != nil {
+		return err
+	}
+
+	if containerNameConfig == nil {
+		containerNameConfig = make(map[string]string)
+	}
+
+	if cID, e := containerNameConfig[newName]; e && cID != containerID {
+		return fmt.Errorf("Container Name have been used in Container ID : %v", cID)
+	}
+
+	// 容器ID 本身的映射需要保留
+	if oldName != containerID {
+		delete(containerNameConfig, oldName)
+	}
+	containerNameConfig[newName] = containerID
+	containerNameConfig[containerID] = containerID
+
+	// 存入数据
+	containerNameConfigBytes, err := json.MarshalIndent(containerNameConfig, " ", "    ")
+	if err != nil {
+		return fmt.Errorf("Rename container Name:ID error %v", err)
+	}
+
+	containerNameConfigStr := strings.Join([]string{string(containerNameConfigBytes), "\n"}, "")
+
+	containerNamePath := path.Join(container.ContainerDir, container.ContainerNameFile)
+	tmpNamePath := strings.Join([]string{containerNamePath, ".tmp"}, "")
+
+	if err := ioutil.WriteFile(tmpNamePath, []byte(containerNameConfigStr), 0644); err != nil {
+		return fmt.Errorf("Rename container Name:ID fail err : %v", err)
+	}
+
+	if err := os.Rename(tmpNamePath, containerNamePath); err != nil {
+		os.Remove(tmpNamePath)
+		return fmt.Errorf("Rename container Name:ID fail err : %v", err)
+	}
+
+	log.Debugf("Rename container Name:ID %v => %v success", oldName, newName)
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"qsrdocker/cgroups/subsystems"
+	"qsrdocker/container"
+	"qsrdocker/network"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// statsInterval stats 的刷新间隔, 同时是计算 CPU 使用率的采样间隔
+const statsInterval = time.Second
+
+// containerStats 单个容器的资源使用
+type containerStats struct {
+	ID            string                  `json:"ID"`
+	Name          string                  `json:"Name"`
+	Read          string                  `json:"Read"`          // 采样时间
+	CPUPercent    float64                 `json:"CPUPercent"`    // 相对单个 CPU, 多核时可超过 100
+	MemoryPercent float64                 `json:"MemoryPercent"` // 相对内存限制
+	Cgroup        *subsystems.Stats       `json:"Cgroup"`        // cgroup 统计
+	Network       *network.InterfaceStats `json:"Network"`       // bridge 网络流量, 其他网络为 null
+
+	SystemCPUUsage uint64 `json:"SystemCPUUsage"` // 宿主机累计 CPU 时间, 单位 ns
+}
+
+// statsContainers 打印容器资源使用, 每次采样由 qsrdockerd 读取
+// 未指定容器时显示所有运行中的容器; noStream 时采样一次, 以 json 输出
+func statsContainers(containerNames []string, noStream bool) error {
+
+	// 上一次的采样，用于计算 CPU 使用率
+	prevStats := map[string]*containerStats{}
+
+	for round := 0; ; round++ {
+		names := containerNames
+
+		// 未指定容器时每次重新获取运行中的容器
+		if len(containerNames) == 0 {
+			var containerInfos []*container.ContainerInfo
+			if err := daemonRequest(http.MethodGet, "/containers", nil, nil, &containerInfos); err != nil {
+				return err
+			}
+			names = nil
+			for _, containerInfo := range containerInfos {
+				names = append(names, containerInfo.ID)
+			}
+		}
+
+		var statsList []*containerStats
+		for _, containerName := range names {
+			stats := &containerStats{}
+			if err := daemonRequest(http.MethodGet, containerAPIPath(containerName, "stats"), nil, nil, stats); err != nil {
+				// 指定的容器未运行时返回错误
+				if len(containerNames) > 0 {
+					return err
+				}
+				log.Warnf("Get container %v stats error %v", containerName, err)
+				continue
+			}
+
+			stats.calculateCPUPercent(prevStats[stats.ID])
+			prevStats[stats.ID] = stats
+
+			statsList = append(statsList, stats)
+		}
+
+		// 第一次采样没有 CPU 使用率, 等待下一次采样
+		if round == 0 {
+			time.Sleep(statsInterval)
+			continue
+		}
+
+		if noStream {
+			statsBytes, err := json.MarshalIndent(statsList, " ", "    ")
+			if err != nil {
+				return fmt.Errorf("Json marshal stats error %v", err)
+			}
+			fmt.Fprintln(os.Stdout, string(statsBytes))
+			return nil
+		}
+
+		// 清屏后重新打印
+		fmt.Fprint(os.Stdout, "\033[2J\033[H")
+		printStatsTable(statsList)
+
+		time.Sleep(statsInterval)
+	}
+}
+
+// getRunningContainerStats 读取运行中容器的资源使用, qsrdockerd 每次请求采样一次
+func getRunningContainerStats(containerName string) (*containerStats, error) {
+
+	containerInfo, err := container.GetContainerInfoByNameID(containerName)
+	if err != nil {
+		return nil, fmt.Errorf("Get containerInfo fail : %v", err)
+	}
+
+	if !containerInfo.Status.Running && !containerInfo.Status.Paused {
+		return nil, fmt.Errorf("Container %v is not running", containerName)
+	}
+
+	return getContainerStats(containerInfo)
+}
+
+// getContainerStats 读取容器的 cgroup 统计与网络流量
+func getContainerStats(containerInfo *container.ContainerInfo) (*containerStats, error) {
+
+	cgroupStats, err := containerInfo.Cgroup.Stats(containerInfo.Status.Pid)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &containerStats{
+		ID:             containerInfo.ID,
+		Name:           containerInfo.Name,
+		Read:           time.Now().Format(time.RFC3339Nano),
+		Cgroup:         cgroupStats,
+		SystemCPUUsage: getSystemCPUUsage(),
+	}
+
+	if cgroupStats.MemoryLimit > 0 {
+		stats.MemoryPercent = float64(cgroupStats.MemoryUsage) / float64(cgroupStats.MemoryLimit) * 100
+	}
+
+	if stats.Network, err = network.GetEndpointStats(containerInfo.NetWorks); err != nil {
+		log.Debugf("Get container %v network stats error %v", containerInfo.ID, err)
+	}
+
+	return stats, nil
+}
+
+// calculateCPUPercent 两次采样间容器 CPU 时间占宿主机 CPU 时间的比例
+func (stats *containerStats) calculateCPUPercent(prevStats *containerStats) {
+
+	if prevStats == nil {
+		return
+	}
+
+	cpuDelta := float64(stats.Cgroup.CPUUsage) - float64(prevStats.Cgroup.CPUUsage)
+	systemDelta := float64(stats.SystemCPUUsage) - float64(prevStats.SystemCPUUsage)
+
+	if cpuDelta > 0 && systemDelta > 0 {
+		stats.CPUPercent = cpuDelta / systemDelta * float64(runtime.NumCPU()) * 100
+	}
+}
+
+// getSystemCPUUsage 读取 /proc/stat 中宿主机累计 CPU 时间, 单位 ns
+func getSystemCPUUsage() uint64 {
+
+	content, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return 0
+	}
+
+	// cpu  user nice system idle iowait irq softirq steal ...
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 8 || fields[0] != "cpu" {
+			continue
+		}
+
+		var totalTicks uint64
+		for _, field := range fields[1:8] {
+			ticks, _ := strconv.ParseUint(field, 10, 64)
+			totalTicks += ticks
+		}
+
+		return totalTicks * uint64(time.Second) / clockTicks
+	}
+
+	return 0
+}
+
+// printStatsTable 以表格打印容器资源使用
+func printStatsTable(statsList []*containerStats) {
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "CONTAINER ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS\n")
+
+	for _, stats := range statsList {
+		netIO := "--"
+		if stats.Network != nil {
+			netIO = fmt.Sprintf("%s / %s", formatBytes(stats.Network.RxBytes), formatBytes(stats.Network.TxBytes))
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%s\t%s / %s\t%d\n",
+			stats.ID,
+			stats.Name,
+			stats.CPUPercent,
+			formatBytes(stats.Cgroup.MemoryUsage),
+			formatBytes(stats.Cgroup.MemoryLimit),
+			stats.MemoryPercent,
+			netIO,
+			formatBytes(stats.Cgroup.BlkioRead),
+			formatBytes(stats.Cgroup.BlkioWrite),
+			stats.Cgroup.PidsCurrent,
+		)
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+	}
+}
+
+// formatBytes 字节数转换为 B KiB MiB GiB
+func formatBytes(size uint64) string {
+	switch {
+	case size >= 1024*1024*1024:
+		return fmt.Sprintf("%.2fGiB", float64(size)/(1024.0*1024.0*1024.0))
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.2fMiB", float64(size)/(1024.0*1024.0))
+	case size >= 1024:
+		return fmt.Sprintf("%.2fKiB", float64(size)/1024.0)
+	default:
+		return fmt.Sprintf("%dB", size)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"qsrdocker/container"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// clockTicks /proc/[pid]/stat 中 utime stime 的单位, USER_HZ 在 Linux 上固定为 100
+const clockTicks = 100
+
+// processInfo 从 /proc 读取的进程信息
+type processInfo struct {
+	User    string
+	Pid     int
+	Ppid    int
+	State   string
+	CPUTime uint64 // utime + stime, 单位 clockTicks
+	RSS     uint64 // KB
+	Command string
+}
+
+// topTitles 默认输出的列
+var topTitles = []string{"USER", "PID", "PPID", "STAT", "TIME", "RSS", "CMD"}
+
+// topContainer 获取容器中运行的进程
+// 进程列表来自容器 cgroup 的 cgroup.procs, 进程信息来自宿主机 /proc, 不依赖镜像中的 ps
+// 指定 psArgs 时使用宿主机的 ps 命令输出并按 PID 过滤
+func topContainer(containerName string, psArgs []string) (*apiTopResponse, error) {
+
+	// 获取containerInfo信息
+	containerInfo, err := container.GetContainerInfoByNameID(containerName)
+	if err != nil {
+		return nil, fmt.Errorf("Get containerInfo fail : %v", err)
+	}
+
+	if !containerInfo.Status.Running && !containerInfo.Status.Paused {
+		return nil, fmt.Errorf("Container %v is not running", containerName)
+	}
+
+	pids, err := containerInfo.Cgroup.GetPids(containerInfo.Status.Pid)
+	if err != nil {
+		return nil, fmt.Errorf("Get container %v processes error %v", containerName, err)
+	}
+
+	// cgroup 无法读取时至少包含 init 进程
+	if len(pids) == 0 {
+		pids = []int{containerInfo.Status.Pid}
+	}
+
+	sort.Ints(pids)
+
+	if len(psArgs) > 0 {
+		return topContainerWithPs(pids, psArgs)
+	}
+
+	top := &apiTopResponse{Titles: topTitles, Processes: [][]string{}}
+
+	for _, pid := range pids {
+		process, err := readProcessInfo(pid)
+		if err != nil {
+			// 进程可能已经退出
+			log.Debugf("Read process %v info error %v", pid, err)
+			continue
+		}
+
+		top.Processes = append(top.Processes, []string{
+			process.User,
+			strconv.Itoa(process.Pid),
+			strconv.Itoa(process.Ppid),
+			process.State,
+			formatCPUTime(process.CPUTime),
+			strconv.FormatUint(process.RSS, 10),
+			process.Command,
+		})
+	}
+
+	return top, nil
+}
+
+// topContainerWithPs 执行宿主机的 ps 命令, 只保留容器中的进程
+// 最后一列 (通常为命令) 可能包含空格, 剩余的字段合并为最后一列
+func topContainerWithPs(pids []int, psArgs []string) (*apiTopResponse, error) {
+
+	output, err := exec.Command("ps", psArgs...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Run ps %v error %v", strings.Join(psArgs, " "), err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+
+	// 在表头中找到 PID 列
+	titles := strings.Fields(lines[0])
+	pidIndex := -1
+	for i, field := range titles {
+		if field == "PID" {
+			pidIndex = i
+			break
+		}
+	}
+
+	if pidIndex == -1 {
+		return nil, fmt.Errorf("Couldn't find PID field in ps output")
+	}
+
+	pidSet := map[int]bool{}
+	for _, pid := range pids {
+		pidSet[pid] = true
+	}
+
+	top := &apiTopResponse{Titles: titles, Processes: [][]string{}}
+
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) <= pidIndex {
+			continue
+		}
+
+		if pid, err := strconv.Atoi(fields[pidIndex]); err != nil || !pidSet[pid] {
+			continue
+		}
+
+		if len(fields) > len(titles) {
+			fields = append(fields[:len(titles)-1], strings.Join(fields[len(titles)-1:], " "))
+		}
+		top.Processes = append(top.Processes, fields)
+	}
+
+	return top, nil
+}
+
+// printTop 以表格打印容器中的进程
+func printTop(top *apiTopResponse) error {
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprintln(w, strings.Join(top.Titles, "\t"))
+
+	for _, process := range top.Processes {
+		fmt.Fprintln(w, strings.Join(process, "\t"))
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("Flush error %v", err)
+	}
+
+	return nil
+}
+
+// readProcessInfo 读取 /proc/[pid]/stat status cmdline
+func readProcessInfo(pid int) (*processInfo, error) {
+
+	procDir := path.Join("/proc", strconv.Itoa(pid))
+
+	statBytes, err := ioutil.ReadFile(path.Join(procDir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	// pid (comm) state ppid ...
+	// comm 可能包含空格和括号, 以最后一个 ) 分割
+	stat := string(statBytes)
+	commStart := strings.Index(stat, "(")
+	commEnd := strings.LastIndex(stat, ")")
+	if commStart < 0 || commEnd < commStart {
+		return nil, fmt.Errorf("Invalid stat %v", stat)
+	}
+
+	comm := stat[commStart+1 : commEnd]
+
+	// fields[0] state  fields[1] ppid  fields[11] utime  fields[12] stime  fields[21] rss
+	fields := strings.Fields(stat[commEnd+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("Invalid stat %v", stat)
+	}
+
+	ppid, _ := strconv.Atoi(fields[1])
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	rssPages, _ := strconv.ParseUint(fields[21], 10, 64)
+
+	process := &processInfo{
+		User:    readProcessUser(procDir),
+		Pid:     pid,
+		Ppid:    ppid,
+		State:   fields[0],
+		CPUTime: utime + stime,
+		RSS:     rssPages * uint64(os.Getpagesize()) / 1024,
+		Command: fmt.Sprintf("[%s]", comm),
+	}
+
+	// cmdline 以 \0 分割, 内核线程和僵尸进程为空
+	if cmdlineBytes, err := ioutil.ReadFile(path.Join(procDir, "cmdline")); err == nil && len(cmdlineBytes) > 0 {
+		cmdline := bytes.Split(bytes.TrimRight(cmdlineBytes, "\x00"), []byte{0})
+		args := make([]string, 0, len(cmdline))
+		for _, arg := range cmdline {
+			args = append(args, string(arg))
+		}
+		process.Command = strings.Join(args, " ")
+	}
+
+	return process, nil
+}
+
+// readProcessUser 从 /proc/[pid]/status 获取进程的 uid, 并转换为宿主机上的用户名
+func readProcessUser(procDir string) string {
+
+	statusBytes, err := ioutil.ReadFile(path.Join(procDir, "status"))
+	if err != nil {
+		return "?"
+	}
+
+	// Uid:	0	0	0	0
+	for _, line := range strings.Split(string(statusBytes), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "Uid:" {
+			continue
+		}
+
+		if u, err := user.LookupId(fields[1]); err == nil {
+			return u.Username
+		}
+		return fields[1]
+	}
+
+	return "?"
+}
+
+// formatCPUTime 与 ps 相同的 TIME 格式 [DD-]HH:MM:SS
+func formatCPUTime(ticks uint64) string {
+
+	seconds := ticks / clockTicks
+
+	days := seconds / 86400
+	seconds %= 86400
+
+	timeStr := fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds%3600/60, seconds%60)
+	if days > 0 {
+		timeStr = fmt.Sprintf("%d-%s", days, timeStr)
+	}
+
+	return timeStr
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"qsrdocker/container"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// volumeCmd 关于 数据卷的相关操作
+var volumeCmd = cli.Command{
+	Name:  "volume",
+	Usage: "qsrdocker volume COMMAND",
+	Subcommands: []cli.Command{
+		volumeLsCmd,
+		volumeCreateCmd,
+		volumeInspectCmd,
+		volumeRemoveCmd,
+		volumePruneCmd,
+	},
+}
+
+// volumeCreateCmd 创建命名数据卷
+var volumeCreateCmd = cli.Command{
+	Name:      "create",
+	Usage:     "Create a volume",
+	ArgsUsage: "[VolumeName]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "driver",
+			Usage: "Volume driver name",
+			Value: container.DefaultVolumeDriver,
+		},
+		cli.StringSliceFlag{
+			Name:  "label", // 多个 --label
+			Usage: "Set metadata for a volume, key=value",
+		},
+	},
+	Action: func(context *cli.Context) error {
+
+		// 未输入名称时由 qsrdockerd 随机生成
+		labels, err := parseVolumeLabels(context.StringSlice("label"))
+		if err != nil {
+			return err
+		}
+
+		volume := &container.Volume{}
+		if err := daemonRequest(http.MethodPost, "/volumes", nil, &apiVolumeCreate{
+			Name:   context.Args().First(),
+			Driver: context.String("driver"),
+			Labels: labels,
+		}, volume); err != nil {
+			return err
+		}
+
+		fmt.Println(volume.Name)
+		return nil
+	},
+}
+
+// volumeLsCmd 打印所有的数据卷
+var volumeLsCmd = cli.Command{
+	Name:      "ls",
+	Usage:     "List volumes",
+	ArgsUsage: "[]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "q", // 只打印名称
+			Usage: "Only display volume names",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return listVolumes(context.Bool("q"))
+	},
+}
+
+// volumeInspectCmd 打印数据卷信息
+var volumeInspectCmd = cli.Command{
+	Name:      "inspect",
+	Usage:     "Display detailed information on one or more volumes",
+	ArgsUsage: "VolumeName...",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing volume name")
+		}
+
+		volumes := []*container.Volume{}
+		for _, name := range context.Args() {
+			volume, err := container.GetVolume(name)
+			if err != nil {
+				log.Errorf("Get volume %v error : %v", name, err)
+				continue
+			}
+			volumes = append(volumes, volume)
+		}
+
+		volumesBytes, err := json.MarshalIndent(volumes, " ", "    ")
+		if err != nil {
+			return fmt.Errorf("Marshal volumes error : %v", err)
+		}
+
+		fmt.Fprint(os.Stdout, strings.Join([]string{string(volumesBytes), "\n"}, ""))
+		return nil
+	},
+}
+
+// volumeRemoveCmd 删除未被使用的数据卷
+var volumeRemoveCmd = cli.Command{
+	Name:      "rm",
+	Usage:     "Remove one or more volumes, volumes in use by containers can't be removed",
+	ArgsUsage: "VolumeName...",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing volume name")
+		}
+
+		for _, name := range context.Args() {
+			// 多个数据卷
+			if err := daemonRequest(http.MethodDelete, strings.Join([]string{"/volumes", url.PathEscape(name)}, "/"), nil, nil, nil); err != nil {
+				log.Errorf("Remove volume %v error : %v", name, err)
+				continue
+			}
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+// volumePruneCmd 删除未被使用的匿名数据卷
+var volumePruneCmd = cli.Command{
+	Name:      "prune",
+	Usage:     "Remove unused anonymous volumes",
+	ArgsUsage: "[]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "a", // 同时删除未被使用的命名数据卷
+			Usage: "Remove all unused volumes, not just anonymous ones",
+		},
+	},
+	Action: func(context *cli.Context) error {
+
+		query := url.Values{}
+		query.Set("all", strconv.FormatBool(context.Bool("a")))
+
+		pruneResponse := &apiVolumePruneResponse{}
+		if err := daemonRequest(http.MethodPost, "/volumes/prune", query, nil, pruneResponse); err != nil {
+			return err
+		}
+
+		for _, name := range pruneResponse.VolumesDeleted {
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+// listVolumes 显示现在存在的数据卷
+func listVolumes(quiet bool) error {
+	volumes, err := container.ListVolumes()
+	if err != nil {
+		return err
+	}
+
+	if quiet {
+		for _, volume := range volumes {
+			fmt.Println(volume.Name)
+		}
+		return nil
+	}
+
+	// 表格打印
+	w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
+	fmt.Fprint(w, "DRIVER\tVOLUME NAME\tREFCOUNT\tCREATED\n")
+	for _, volume := range volumes {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n",
+			volume.Driver,
+			volume.Name,
+			volume.RefCount,
+			volume.CreatedAt,
+		)
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+	}
+
+	return nil
+}
+
+// parseVolumeLabels 解析 --label key=value
+func parseVolumeLabels(labelSlice []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, label := range labelSlice {
+		kv := strings.SplitN(label, "=", 2)
+		if kv[0] == "" {
+			return nil, fmt.Errorf("Invalid label %v, use key=value", label)
+		}
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels, nil
+}